@@ -0,0 +1,176 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cmd-ctrl-q/go-movies-server/models"
+	"github.com/julienschmidt/httprouter"
+)
+
+// API keys are handed out as apiKeyPrefix followed by random characters
+const apiKeyPrefix = "gm_"
+
+type APIKeyPayload struct {
+	Name      string   `json:"name"`
+	Scopes    []string `json:"scopes"`
+	ExpiresAt string   `json:"expires_at"` // RFC 3339
+}
+
+// newAPIKey is the only response that carries the key itself
+type newAPIKey struct {
+	*models.APIKey
+	Key string `json:"key"`
+}
+
+func (app *application) getAllAPIKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := app.models.APIKeys.APIKeysAll()
+	if err != nil {
+		app.logger.Println("error getting api keys from db")
+		app.errorJSON(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, keys, "api_keys")
+	if err != nil {
+		app.logger.Println("error marshalling data")
+		app.errorJSON(w, http.StatusInternalServerError, err)
+		return
+	}
+}
+
+func (app *application) createAPIKey(w http.ResponseWriter, r *http.Request) {
+	var payload APIKeyPayload
+
+	err := json.NewDecoder(r.Body).Decode(&payload)
+	if err != nil {
+		app.logger.Println("error decoding api key:", err)
+		app.errorJSON(w, http.StatusBadRequest, err)
+		return
+	}
+
+	payload.Name = strings.TrimSpace(payload.Name)
+	if payload.Name == "" {
+		app.errorJSON(w, http.StatusBadRequest, errors.New("name is required"))
+		return
+	}
+
+	if len(payload.Scopes) == 0 {
+		app.errorJSON(w, http.StatusBadRequest, errors.New("at least one scope is required"))
+		return
+	}
+	for _, scope := range payload.Scopes {
+		if !models.ValidRole(scope) {
+			app.errorJSON(w, http.StatusBadRequest, fmt.Errorf("unknown scope %q", scope))
+			return
+		}
+	}
+
+	expires, err := time.Parse(time.RFC3339, payload.ExpiresAt)
+	if err != nil {
+		app.errorJSON(w, http.StatusBadRequest, errors.New("expires_at must be an RFC 3339 time"))
+		return
+	}
+	if !expires.After(time.Now()) {
+		app.errorJSON(w, http.StatusBadRequest, errors.New("expires_at must be in the future"))
+		return
+	}
+
+	p, ok := principalFromContext(r.Context())
+	if !ok {
+		app.errorJSON(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return
+	}
+
+	secret, err := randomToken(32)
+	if err != nil {
+		app.logger.Println("error creating api key")
+		app.errorJSON(w, http.StatusInternalServerError, err)
+		return
+	}
+	key := apiKeyPrefix + secret
+
+	apiKey := models.APIKey{
+		Name:      payload.Name,
+		Prefix:    key[:len(apiKeyPrefix)+6],
+		KeyHash:   hashToken(key),
+		Scopes:    payload.Scopes,
+		ExpiresAt: expires,
+		CreatedBy: p.UserID,
+		CreatedAt: time.Now(),
+	}
+
+	err = app.models.WithTx(r.Context(), func(tx models.Models) error {
+		var err error
+		apiKey.ID, err = tx.APIKeys.InsertAPIKey(apiKey)
+		if err != nil {
+			return err
+		}
+		return app.audit(r.Context(), tx, "api_key", "create", apiKey.ID, nil, apiKey)
+	})
+	if err != nil {
+		app.logger.Println("error inserting api key to database")
+		app.errorJSON(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, newAPIKey{APIKey: &apiKey, Key: key}, "api_key")
+	if err != nil {
+		app.logger.Println("error marshalling json response")
+		app.errorJSON(w, http.StatusInternalServerError, err)
+		return
+	}
+}
+
+func (app *application) revokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil {
+		app.logger.Println("error converting string id to int")
+		app.errorJSON(w, http.StatusBadRequest, err)
+		return
+	}
+
+	err = app.models.WithTx(r.Context(), func(tx models.Models) error {
+		before, err := tx.APIKeys.GetAPIKey(id)
+		if err != nil {
+			return err
+		}
+		err = tx.APIKeys.RevokeAPIKey(id)
+		if err != nil {
+			return err
+		}
+		after, err := tx.APIKeys.GetAPIKey(id)
+		if err != nil {
+			return err
+		}
+		return app.audit(r.Context(), tx, "api_key", "revoke", id, before, after)
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, http.StatusNotFound, errors.New("no active api key with that id"))
+			return
+		}
+		app.logger.Println("error revoking api key")
+		app.errorJSON(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	ok := jsonResponse{
+		OK:      true,
+		Message: "API key revoked",
+	}
+
+	err = app.writeJSON(w, http.StatusOK, ok, "response")
+	if err != nil {
+		app.logger.Println("error marshalling json response")
+		app.errorJSON(w, http.StatusInternalServerError, err)
+		return
+	}
+}
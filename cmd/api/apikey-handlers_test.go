@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/cmd-ctrl-q/go-movies-server/models"
+)
+
+func TestAPIKeys(t *testing.T) {
+	ta := newTestApp(t)
+	admin := ta.bearer(t, ta.addUser(t, "admin@example.com", "password1", models.RoleAdmin))
+	movie := ta.addMovie(t, "Heat", 1995, 4)
+
+	for _, body := range []string{
+		`{"name":"","scopes":["editor"],"expires_at":"2999-01-01T00:00:00Z"}`,
+		`{"name":"ingest","scopes":[],"expires_at":"2999-01-01T00:00:00Z"}`,
+		`{"name":"ingest","scopes":["root"],"expires_at":"2999-01-01T00:00:00Z"}`,
+		`{"name":"ingest","scopes":["editor"],"expires_at":"2000-01-01T00:00:00Z"}`,
+		`{"name":"ingest","scopes":["editor"],"expires_at":"soon"}`,
+	} {
+		rr := ta.do(t, http.MethodPost, "/v1/admin/apikeys", body, admin)
+		expectStatus(t, rr, http.StatusBadRequest)
+	}
+
+	rr := ta.do(t, http.MethodPost, "/v1/admin/apikeys",
+		`{"name":"ingest","scopes":["editor"],"expires_at":"2999-01-01T00:00:00Z"}`, admin)
+	expectStatus(t, rr, http.StatusCreated)
+
+	var created struct {
+		APIKey struct {
+			ID     int    `json:"id"`
+			Key    string `json:"key"`
+			Prefix string `json:"prefix"`
+		} `json:"api_key"`
+	}
+	decode(t, rr, &created)
+	key := http.Header{"X-Api-Key": {created.APIKey.Key}}
+
+	edit := `{"id":"` + itoa(movie) + `","title":"Heat","release_date":"1995-12-15","runtime":"170","rating":"5","version":1}`
+	rr = ta.do(t, http.MethodPost, "/v1/admin/editmovie", edit, key)
+	expectStatus(t, rr, http.StatusOK)
+
+	// editor scope does not reach admin endpoints
+	rr = ta.do(t, http.MethodGet, "/v1/admin/deletemovie/"+itoa(movie), "", key)
+	expectStatus(t, rr, http.StatusForbidden)
+
+	rr = ta.do(t, http.MethodGet, "/v1/admin/apikeys", "", admin)
+	expectStatus(t, rr, http.StatusOK)
+
+	var list struct {
+		APIKeys []models.APIKey `json:"api_keys"`
+	}
+	decode(t, rr, &list)
+	if len(list.APIKeys) != 1 || list.APIKeys[0].Prefix != created.APIKey.Prefix || list.APIKeys[0].LastUsedAt == nil {
+		t.Errorf("got %+v", list.APIKeys)
+	}
+
+	rr = ta.do(t, http.MethodPost, "/v1/admin/apikeys/"+itoa(created.APIKey.ID)+"/revoke", "", admin)
+	expectStatus(t, rr, http.StatusOK)
+
+	rr = ta.do(t, http.MethodPost, "/v1/admin/editmovie", edit, key)
+	expectStatus(t, rr, http.StatusUnauthorized)
+
+	rr = ta.do(t, http.MethodPost, "/v1/admin/editmovie", edit, http.Header{"X-Api-Key": {"gm_wrong"}})
+	expectStatus(t, rr, http.StatusUnauthorized)
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/cmd-ctrl-q/go-movies-server/models"
+)
+
+// auditIgnored lists fields left out of audit diffs because every write
+// changes them
+var auditIgnored = map[string]bool{"updated_at": true, "version": true}
+
+// systemUserID is the user id audited for changes the server makes on its
+// own, such as purging expired trash. No user has it, as ids start at 1
+const systemUserID = 0
+
+// systemContext returns a context in which the server acts as the system
+// user, for audit
+func systemContext() context.Context {
+	return context.WithValue(context.Background(), principalKey, &principal{UserID: systemUserID, Role: models.RoleAdmin})
+}
+
+// audit records a change made by the caller authenticated in ctx, in the
+// same transaction as the change itself. before is nil for creates and
+// after is nil for deletes
+func (app *application) audit(ctx context.Context, tx models.Models, entity, action string, id int, before, after interface{}) error {
+	p, ok := principalFromContext(ctx)
+	if !ok {
+		return errors.New("audit: no principal in request context")
+	}
+
+	changes, err := diff(before, after)
+	if err != nil {
+		return err
+	}
+
+	entry := models.AuditEntry{
+		UserID:    p.UserID,
+		Action:    action,
+		Entity:    entity,
+		EntityID:  id,
+		Changes:   changes,
+		CreatedAt: time.Now(),
+	}
+	if p.APIKeyID != 0 {
+		keyID := p.APIKeyID
+		entry.APIKeyID = &keyID
+	}
+
+	_, err = tx.Audit.InsertAudit(entry)
+	return err
+}
+
+// diff compares the json fields of two values and returns those that differ
+func diff(before, after interface{}) (map[string]models.FieldChange, error) {
+	b, err := jsonFields(before)
+	if err != nil {
+		return nil, err
+	}
+	a, err := jsonFields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := make(map[string]models.FieldChange)
+	for _, fields := range []map[string]json.RawMessage{b, a} {
+		for name := range fields {
+			if auditIgnored[name] || bytes.Equal(b[name], a[name]) {
+				continue
+			}
+			changes[name] = models.FieldChange{Before: b[name], After: a[name]}
+		}
+	}
+
+	return changes, nil
+}
+
+// jsonFields marshals v and splits it into its top level fields
+func jsonFields(v interface{}) (map[string]json.RawMessage, error) {
+	fields := make(map[string]json.RawMessage)
+	if v == nil {
+		return fields, nil
+	}
+
+	js, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(js, &fields)
+	return fields, err
+}
+
+// movieRecord is how movies appear in the audit log. Genres are listed by
+// name, as the ids of genre links change whenever they are rewritten
+type movieRecord struct {
+	*models.Movie
+	Genres []string `json:"genres"`
+}
+
+// auditMovie returns the audit record of a movie, or nil for no movie
+func auditMovie(movie *models.Movie) interface{} {
+	if movie == nil {
+		return nil
+	}
+
+	genres := []string{}
+	for _, name := range movie.MovieGenre {
+		genres = append(genres, name)
+	}
+	sort.Strings(genres)
+
+	return movieRecord{Movie: movie, Genres: genres}
+}
+
+// auditFilter reads the audit log query string
+func auditFilter(q url.Values) (models.AuditFilter, error) {
+	f := models.AuditFilter{
+		Entity: q.Get("entity"),
+		Limit:  defaultPageSize,
+	}
+
+	ints := []struct {
+		name string
+		dest *int
+	}{
+		{"entity_id", &f.EntityID},
+		{"user_id", &f.UserID},
+		{"limit", &f.Limit},
+		{"offset", &f.Offset},
+	}
+	for _, param := range ints {
+		if v := q.Get(param.name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return f, fmt.Errorf("%s must be a positive integer", param.name)
+			}
+			*param.dest = n
+		}
+	}
+	if f.Limit < 1 || f.Limit > maxPageSize {
+		return f, fmt.Errorf("limit must be between 1 and %d", maxPageSize)
+	}
+
+	times := []struct {
+		name string
+		dest *time.Time
+	}{
+		{"from", &f.From},
+		{"to", &f.To},
+	}
+	for _, param := range times {
+		if v := q.Get(param.name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return f, fmt.Errorf("%s must be an RFC 3339 time", param.name)
+			}
+			*param.dest = t
+		}
+	}
+
+	return f, nil
+}
+
+// getAuditLog lists audit entries, newest first, filtered by entity,
+// entity_id, user_id and a from/to time range
+func (app *application) getAuditLog(w http.ResponseWriter, r *http.Request) {
+	f, err := auditFilter(r.URL.Query())
+	if err != nil {
+		app.errorJSON(w, http.StatusBadRequest, err)
+		return
+	}
+
+	entries, err := app.models.Audit.AuditLog(f)
+	if err != nil {
+		app.logger.Println("error getting audit log")
+		app.errorJSON(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, entries, "audit")
+	if err != nil {
+		app.logger.Println("error marshalling json response")
+		app.errorJSON(w, http.StatusInternalServerError, err)
+		return
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/cmd-ctrl-q/go-movies-server/models"
+)
+
+type auditResponse struct {
+	Audit []*models.AuditEntry `json:"audit"`
+}
+
+func TestAuditLog(t *testing.T) {
+	ta := newTestApp(t)
+	editorUser := ta.addUser(t, "editor@example.com", "password1", models.RoleEditor)
+	editor := ta.bearer(t, editorUser)
+	adminUser := ta.addUser(t, "admin@example.com", "password1", models.RoleAdmin)
+	admin := ta.bearer(t, adminUser)
+	drama := ta.addGenre(t, "Drama")
+
+	create := `{"id":"0","title":"Heat","description":"Cops and robbers","release_date":"1995-12-15",
+		"runtime":"170","rating":"4","mpaa_rating":"R","genre_ids":[` + itoa(drama) + `]}`
+	rr := ta.do(t, http.MethodPost, "/v1/admin/editmovie", create, editor)
+	expectStatus(t, rr, http.StatusOK)
+
+	movies, _ := ta.store.All()
+	id := movies[0].ID
+
+	update := `{"id":"` + itoa(id) + `","title":"Heat","description":"Cops and robbers","release_date":"1995-12-15",
+		"runtime":"170","rating":"5","mpaa_rating":"R","version":"1"}`
+	rr = ta.do(t, http.MethodPost, "/v1/admin/editmovie", update, editor)
+	expectStatus(t, rr, http.StatusOK)
+
+	rr = ta.do(t, http.MethodGet, "/v1/admin/deletemovie/"+itoa(id), "", admin)
+	expectStatus(t, rr, http.StatusOK)
+
+	rr = ta.do(t, http.MethodGet, "/v1/admin/audit", "", editor)
+	expectStatus(t, rr, http.StatusForbidden)
+
+	rr = ta.do(t, http.MethodGet, "/v1/admin/audit?entity=movie&entity_id="+itoa(id), "", admin)
+	expectStatus(t, rr, http.StatusOK)
+	var log auditResponse
+	decode(t, rr, &log)
+	if len(log.Audit) != 3 {
+		t.Fatalf("got %d entries, want 3", len(log.Audit))
+	}
+
+	// newest first
+	deleted, updated, created := log.Audit[0], log.Audit[1], log.Audit[2]
+	if deleted.Action != "delete" || deleted.UserID != adminUser.ID {
+		t.Errorf("got %+v, want a delete by the admin", deleted)
+	}
+	if _, ok := deleted.Changes["deleted_at"]; !ok || len(deleted.Changes) != 1 {
+		t.Errorf("got delete changes %v, want deleted_at only", deleted.Changes)
+	}
+
+	if updated.Action != "update" || updated.UserID != editorUser.ID {
+		t.Errorf("got %+v, want an update by the editor", updated)
+	}
+	change, ok := updated.Changes["rating"]
+	if !ok || string(change.Before) != "4" || string(change.After) != "5" || len(updated.Changes) != 1 {
+		t.Errorf("got update changes %v, want rating 4 to 5 only", updated.Changes)
+	}
+
+	if created.Action != "create" || string(created.Changes["title"].Before) != "null" ||
+		string(created.Changes["genres"].After) != `["Drama"]` {
+		t.Errorf("got create %+v", created)
+	}
+
+	rr = ta.do(t, http.MethodGet, "/v1/admin/audit?user_id="+itoa(adminUser.ID), "", admin)
+	log = auditResponse{}
+	decode(t, rr, &log)
+	if len(log.Audit) != 1 {
+		t.Errorf("got %d entries by the admin, want 1", len(log.Audit))
+	}
+
+	future := url.QueryEscape(time.Now().Add(time.Hour).Format(time.RFC3339))
+	rr = ta.do(t, http.MethodGet, "/v1/admin/audit?from="+future, "", admin)
+	log = auditResponse{}
+	decode(t, rr, &log)
+	if len(log.Audit) != 0 {
+		t.Errorf("got %d entries from the future, want 0", len(log.Audit))
+	}
+
+	for _, query := range []string{"from=yesterday", "limit=0", "user_id=abc"} {
+		rr = ta.do(t, http.MethodGet, "/v1/admin/audit?"+query, "", admin)
+		expectStatus(t, rr, http.StatusBadRequest)
+	}
+}
+
+func TestAuditedAdminWrites(t *testing.T) {
+	ta := newTestApp(t)
+	admin := ta.bearer(t, ta.addUser(t, "admin@example.com", "password1", models.RoleAdmin))
+	id := ta.addMovie(t, "Heat", 1995, 4)
+
+	requests := []struct {
+		method, path, body string
+	}{
+		{http.MethodPost, "/v1/admin/editgenre", `{"id":0,"genre_name":"Drama"}`},
+		{http.MethodPost, "/v1/admin/apikeys", `{"name":"ingest","scopes":["editor"],"expires_at":"2999-01-01T00:00:00Z"}`},
+		{http.MethodGet, "/v1/admin/deletemovie/" + itoa(id), ""},
+		{http.MethodPost, "/v1/admin/trash/" + itoa(id) + "/restore", ""},
+	}
+	for _, req := range requests {
+		rr := ta.do(t, req.method, req.path, req.body, admin)
+		if rr.Code != http.StatusOK && rr.Code != http.StatusCreated {
+			t.Fatalf("%s %s: got status %d: %s", req.method, req.path, rr.Code, rr.Body.String())
+		}
+	}
+
+	entries, err := ta.store.AuditLog(models.AuditFilter{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, entry := range entries {
+		got = append(got, entry.Entity+" "+entry.Action)
+	}
+	want := []string{"movie restore", "movie delete", "api_key create", "genre create"}
+	if !equalStrings(got, want) {
+		t.Errorf("got audit %v, want %v", got, want)
+	}
+}
+
+func TestAuditedGenreCascade(t *testing.T) {
+	ta := newTestApp(t)
+	admin := ta.bearer(t, ta.addUser(t, "admin@example.com", "password1", models.RoleAdmin))
+	drama := ta.addGenre(t, "Drama")
+	crime := ta.addGenre(t, "Crime")
+	heat := ta.addMovie(t, "Heat", 1995, 4, drama, crime)
+	ronin := ta.addMovie(t, "Ronin", 1998, 4, drama)
+	tenet := ta.addMovie(t, "Tenet", 2020, 3, crime)
+
+	err := ta.store.DeleteMovie(ronin)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := ta.do(t, http.MethodGet, "/v1/admin/deletegenre/"+itoa(drama)+"?cascade=true", "", admin)
+	expectStatus(t, rr, http.StatusOK)
+
+	// every movie taken out of the genre is audited, in the trash or not
+	for id, want := range map[int]string{heat: `["Crime"]`, ronin: `[]`} {
+		entries, _ := ta.store.AuditLog(models.AuditFilter{Entity: "movie", EntityID: id, Limit: 10})
+		if len(entries) != 1 || entries[0].Action != "update" {
+			t.Errorf("movie %d: got audit %+v, want one update", id, entries)
+			continue
+		}
+		if got := string(entries[0].Changes["genres"].After); got != want {
+			t.Errorf("movie %d: got genres %s, want %s", id, got, want)
+		}
+	}
+	if entries, _ := ta.store.AuditLog(models.AuditFilter{Entity: "movie", EntityID: tenet, Limit: 10}); len(entries) != 0 {
+		t.Errorf("got audit %+v for a movie not in the genre", entries)
+	}
+	if entries, _ := ta.store.AuditLog(models.AuditFilter{Entity: "genre", EntityID: drama, Limit: 10}); len(entries) != 1 {
+		t.Errorf("got audit %+v, want the genre delete", entries)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	before := map[string]interface{}{"a": 1, "b": "x", "updated_at": 1}
+	after := map[string]interface{}{"a": 1, "b": "y", "c": true, "updated_at": 2}
+
+	changes, err := diff(before, after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 2 ||
+		string(changes["b"].Before) != `"x"` || string(changes["b"].After) != `"y"` ||
+		changes["c"].Before != nil || string(changes["c"].After) != "true" {
+		t.Errorf("got %v", changes)
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/cmd-ctrl-q/go-movies-server/models"
+	"github.com/julienschmidt/httprouter"
+)
+
+type GenrePayload struct {
+	ID        int    `json:"id"`
+	GenreName string `json:"genre_name"`
+}
+
+// saveGenre inserts a genre when its id is 0 and renames it otherwise,
+// auditing the change for the caller authenticated in ctx
+func (app *application) saveGenre(ctx context.Context, genre models.Genre) (*models.Genre, error) {
+	var saved *models.Genre
+	err := app.models.WithTx(ctx, func(tx models.Models) error {
+		var before *models.Genre
+		var err error
+		action := "update"
+
+		if genre.ID == 0 {
+			action = "create"
+			genre.ID, err = tx.Genres.InsertGenre(genre)
+		} else {
+			before, err = tx.Genres.GetGenre(genre.ID)
+			if err != nil {
+				return err
+			}
+			err = tx.Genres.UpdateGenre(genre)
+		}
+		if err != nil {
+			return err
+		}
+
+		saved, err = tx.Genres.GetGenre(genre.ID)
+		if err != nil {
+			return err
+		}
+		return app.audit(ctx, tx, "genre", action, genre.ID, before, saved)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return saved, nil
+}
+
+// removeGenre deletes a genre, auditing it for the caller authenticated
+// in ctx. Unless cascade is set, genres still assigned to movies give
+// models.ErrGenreInUse. A cascade changes the genres of its movies, which
+// are audited and revised too
+func (app *application) removeGenre(ctx context.Context, id int, cascade bool) error {
+	return app.models.WithTx(ctx, func(tx models.Models) error {
+		before, err := tx.Genres.GetGenre(id)
+		if err != nil {
+			return err
+		}
+
+		var movies []*models.Movie
+		if cascade {
+			movies, err = genreMovies(tx, before)
+			if err != nil {
+				return err
+			}
+		}
+		for _, movie := range movies {
+			err = baseline(tx, movie.ID)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = tx.Genres.DeleteGenre(id, cascade)
+		if err != nil {
+			return err
+		}
+
+		for _, movie := range movies {
+			after, err := tx.Movies.Get(movie.ID)
+			if errors.Is(err, sql.ErrNoRows) {
+				after, err = tx.Movies.GetTrashed(movie.ID)
+			}
+			if err != nil {
+				return err
+			}
+			err = app.audit(ctx, tx, "movie", "update", movie.ID, auditMovie(movie), auditMovie(after))
+			if err != nil {
+				return err
+			}
+			_, err = app.revise(ctx, tx, movie.ID, "update", nil)
+			if err != nil {
+				return err
+			}
+		}
+
+		return app.audit(ctx, tx, "genre", "delete", id, before, nil)
+	})
+}
+
+// editGenre creates a genre when the payload id is 0 and renames it
+// otherwise, responding with the stored genre
+func (app *application) editGenre(w http.ResponseWriter, r *http.Request) {
+	var payload GenrePayload
+
+	err := json.NewDecoder(r.Body).Decode(&payload)
+	if err != nil {
+		app.logger.Println("error decoding genre:", err)
+		app.errorJSON(w, http.StatusBadRequest, err)
+		return
+	}
+
+	genre := models.Genre{
+		ID:        payload.ID,
+		GenreName: strings.TrimSpace(payload.GenreName),
+	}
+	if genre.GenreName == "" {
+		app.errorJSON(w, http.StatusBadRequest, errors.New("genre_name is required"))
+		return
+	}
+
+	saved, err := app.saveGenre(r.Context(), genre)
+	switch {
+	case errors.Is(err, models.ErrDuplicateGenre):
+		app.errorJSON(w, http.StatusConflict, err)
+		return
+	case errors.Is(err, sql.ErrNoRows):
+		app.errorJSON(w, http.StatusNotFound, errors.New("genre not found"))
+		return
+	case err != nil:
+		app.logger.Println("error saving genre to database")
+		app.errorJSON(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	status := http.StatusOK
+	if payload.ID == 0 {
+		status = http.StatusCreated
+	}
+
+	err = app.writeJSON(w, status, saved, "genre")
+	if err != nil {
+		app.errorJSON(w, http.StatusInternalServerError, err)
+		return
+	}
+}
+
+// deleteGenre refuses to delete genres still assigned to movies,
+// unless called with ?cascade=true
+func (app *application) deleteGenre(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil {
+		app.logger.Println("error converting string id to int")
+		app.errorJSON(w, http.StatusBadRequest, err)
+		return
+	}
+
+	cascade := false
+	if v := r.URL.Query().Get("cascade"); v != "" {
+		cascade, err = strconv.ParseBool(v)
+		if err != nil {
+			app.errorJSON(w, http.StatusBadRequest, errors.New("cascade must be true or false"))
+			return
+		}
+	}
+
+	err = app.removeGenre(r.Context(), id, cascade)
+	switch {
+	case errors.Is(err, models.ErrGenreInUse):
+		app.errorJSON(w, http.StatusConflict, errors.New("genre is still assigned to movies, delete with cascade=true to remove it from them"))
+		return
+	case errors.Is(err, sql.ErrNoRows):
+		app.errorJSON(w, http.StatusNotFound, errors.New("genre not found"))
+		return
+	case err != nil:
+		app.logger.Println("error deleting genre")
+		app.errorJSON(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	ok := jsonResponse{
+		OK: true,
+	}
+
+	err = app.writeJSON(w, http.StatusOK, ok, "response")
+	if err != nil {
+		app.logger.Println("error marshalling json response")
+		app.errorJSON(w, http.StatusInternalServerError, err)
+		return
+	}
+}
+
+// genreMovies returns the movies in a genre, those in the trash included
+func genreMovies(tx models.Models, genre *models.Genre) ([]*models.Movie, error) {
+	movies, err := tx.Movies.All(genre.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	// genre names are unique, so they tell trashed movies' genres apart
+	trashed, err := tx.Movies.TrashedMovies()
+	if err != nil {
+		return nil, err
+	}
+	for _, movie := range trashed {
+		for _, name := range movie.MovieGenre {
+			if name == genre.GenreName {
+				movies = append(movies, movie)
+				break
+			}
+		}
+	}
+
+	return movies, nil
+}
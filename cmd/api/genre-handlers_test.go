@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/cmd-ctrl-q/go-movies-server/models"
+)
+
+func TestEditGenre(t *testing.T) {
+	ta := newTestApp(t)
+	admin := ta.bearer(t, ta.addUser(t, "admin@example.com", "password1", models.RoleAdmin))
+	editor := ta.bearer(t, ta.addUser(t, "editor@example.com", "password1", models.RoleEditor))
+	drama := ta.addGenre(t, "Drama")
+
+	tests := []struct {
+		name   string
+		body   string
+		header http.Header
+		want   int
+	}{
+		{"editor", `{"id":0,"genre_name":"Comedy"}`, editor, http.StatusForbidden},
+		{"create", `{"id":0,"genre_name":"Comedy"}`, admin, http.StatusCreated},
+		{"duplicate", `{"id":0,"genre_name":"drama"}`, admin, http.StatusConflict},
+		{"empty", `{"id":0,"genre_name":" "}`, admin, http.StatusBadRequest},
+		{"bad json", `not json`, admin, http.StatusBadRequest},
+		{"rename", `{"id":` + itoa(drama) + `,"genre_name":"Melodrama"}`, admin, http.StatusOK},
+		{"rename to taken", `{"id":` + itoa(drama) + `,"genre_name":"Comedy"}`, admin, http.StatusConflict},
+		{"missing", `{"id":999,"genre_name":"Western"}`, admin, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := ta.do(t, http.MethodPost, "/v1/admin/editgenre", tt.body, tt.header)
+			expectStatus(t, rr, tt.want)
+		})
+	}
+
+	genre, err := ta.store.GetGenre(drama)
+	if err != nil || genre.GenreName != "Melodrama" {
+		t.Errorf("got %+v, %v", genre, err)
+	}
+
+	// a new genre comes back with its id
+	rr := ta.do(t, http.MethodPost, "/v1/admin/editgenre", `{"id":0,"genre_name":"Western"}`, admin)
+	expectStatus(t, rr, http.StatusCreated)
+
+	var resp struct {
+		Genre models.Genre `json:"genre"`
+	}
+	decode(t, rr, &resp)
+	created, err := ta.store.GetGenre(resp.Genre.ID)
+	if err != nil || created.GenreName != "Western" {
+		t.Errorf("got genre %+v for the id in %s", created, rr.Body)
+	}
+}
+
+func TestDeleteGenre(t *testing.T) {
+	ta := newTestApp(t)
+	admin := ta.bearer(t, ta.addUser(t, "admin@example.com", "password1", models.RoleAdmin))
+	drama := ta.addGenre(t, "Drama")
+	unused := ta.addGenre(t, "Western")
+	movie := ta.addMovie(t, "Casablanca", 1942, 5, drama)
+
+	rr := ta.do(t, http.MethodGet, "/v1/admin/deletegenre/"+itoa(drama), "", admin)
+	expectStatus(t, rr, http.StatusConflict)
+
+	rr = ta.do(t, http.MethodGet, "/v1/admin/deletegenre/"+itoa(drama)+"?cascade=true", "", admin)
+	expectStatus(t, rr, http.StatusOK)
+
+	m, _ := ta.store.Get(movie)
+	if len(m.MovieGenre) != 0 {
+		t.Errorf("movie still has genres %v", m.MovieGenre)
+	}
+
+	rr = ta.do(t, http.MethodGet, "/v1/admin/deletegenre/"+itoa(unused), "", admin)
+	expectStatus(t, rr, http.StatusOK)
+
+	rr = ta.do(t, http.MethodGet, "/v1/admin/deletegenre/"+itoa(unused), "", admin)
+	expectStatus(t, rr, http.StatusNotFound)
+
+	rr = ta.do(t, http.MethodGet, "/v1/admin/deletegenre/1?cascade=maybe", "", admin)
+	expectStatus(t, rr, http.StatusBadRequest)
+}
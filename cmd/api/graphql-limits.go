@@ -0,0 +1,321 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/location"
+)
+
+// codeLimitExceeded is the error code of queries rejected by queryLimits
+const codeLimitExceeded = "QUERY_LIMIT_EXCEEDED"
+
+// fieldCost is the cost annotation of a field. cost is what resolving the
+// field costs, and items how many results a list field is expected to
+// return when no limit argument says
+type fieldCost struct {
+	cost  int
+	items int
+}
+
+// graphQLCosts annotates fields by type and name. Fields left out cost 1
+// when they resolve an object or list and nothing when they are scalars,
+// which come with their parent
+var graphQLCosts = map[string]fieldCost{
+	"RootQuery.list":   {cost: 2, items: defaultPageSize},
+	"RootQuery.search": {cost: 5, items: defaultPageSize},
+	"RootQuery.genres": {cost: 1, items: 20},
+	"Movie.genres":     {cost: 1, items: 5},
+	"Genre.movies":     {cost: 1, items: 50},
+
+	"RootMutation.createMovie": {cost: 10},
+	"RootMutation.updateMovie": {cost: 10},
+	"RootMutation.deleteMovie": {cost: 10},
+	"RootMutation.createGenre": {cost: 10},
+	"RootMutation.updateGenre": {cost: 10},
+	"RootMutation.deleteGenre": {cost: 10},
+}
+
+// queryLimits are the largest operations the GraphQL endpoint runs.
+// A zero limit is not enforced
+type queryLimits struct {
+	maxDepth      int
+	maxComplexity int
+	maxAliases    int
+}
+
+// queryStats measures an operation before it runs
+type queryStats struct {
+	depth      int
+	complexity int
+	aliases    int
+}
+
+// check returns an error for each limit the operation exceeds
+func (l queryLimits) check(stats queryStats) []gqlerrors.FormattedError {
+	var errs []gqlerrors.FormattedError
+	exceeded := func(limit string, max, actual int) {
+		if max > 0 && actual > max {
+			errs = append(errs, gqlerrors.FormattedError{
+				Message:   fmt.Sprintf("query %s %d exceeds the maximum of %d", limit, actual, max),
+				Locations: []location.SourceLocation{},
+				Extensions: map[string]interface{}{
+					"code":    codeLimitExceeded,
+					"limit":   limit,
+					"maximum": max,
+					"actual":  actual,
+				},
+			})
+		}
+	}
+
+	exceeded("depth", l.maxDepth, stats.depth)
+	exceeded("complexity", l.maxComplexity, stats.complexity)
+	exceeded("aliases", l.maxAliases, stats.aliases)
+
+	return errs
+}
+
+// maxMeasure caps every measure, so that the products and sums of huge
+// queries saturate instead of overflowing
+const maxMeasure = 1<<31 - 1
+
+// add returns a+b, saturated at maxMeasure
+func add(a, b int) int {
+	if a > maxMeasure-b {
+		return maxMeasure
+	}
+	return a + b
+}
+
+// mul returns a*b, saturated at maxMeasure
+func mul(a, b int) int {
+	if a != 0 && b > maxMeasure/a {
+		return maxMeasure
+	}
+	return a * b
+}
+
+// measure walks an operation against the schema. Introspection fields
+// count towards depth and aliases but cost nothing, so tools can always
+// read the schema. Fields the schema does not know are counted as scalars;
+// validation rejects them before
+func measure(schema graphql.Schema, doc *ast.Document, op *ast.OperationDefinition, variables map[string]interface{}) queryStats {
+	m := &measurer{
+		schema:    schema,
+		fragments: make(map[string]*ast.FragmentDefinition),
+		variables: variables,
+		defaults:  make(map[string]ast.Value),
+		spreads:   make(map[spreadKey]queryStats),
+		visiting:  make(map[string]bool),
+	}
+	for _, def := range doc.Definitions {
+		if frag, ok := def.(*ast.FragmentDefinition); ok {
+			m.fragments[frag.Name.Value] = frag
+		}
+	}
+	for _, def := range op.VariableDefinitions {
+		if def.DefaultValue != nil {
+			m.defaults[def.Variable.Name.Value] = def.DefaultValue
+		}
+	}
+
+	var root graphql.Type = schema.QueryType()
+	if op.Operation == ast.OperationTypeMutation {
+		root = schema.MutationType()
+	}
+
+	return m.selectionSet(op.SelectionSet, root)
+}
+
+type measurer struct {
+	schema    graphql.Schema
+	fragments map[string]*ast.FragmentDefinition
+	variables map[string]interface{}
+	defaults  map[string]ast.Value     // variable default values, used when a variable is not sent
+	spreads   map[spreadKey]queryStats // fragments already measured
+	visiting  map[string]bool          // fragments being walked, to stop at cycles
+}
+
+// spreadKey is a fragment spread into a parent type. A fragment measures
+// the same wherever it is spread into the same type, so each is walked
+// once however often it is spread
+type spreadKey struct {
+	fragment string
+	parent   string
+}
+
+// selectionSet measures a selection set on the parent type
+func (m *measurer) selectionSet(set *ast.SelectionSet, parent graphql.Type) queryStats {
+	var stats queryStats
+	if set == nil {
+		return stats
+	}
+
+	for _, selection := range set.Selections {
+		var s queryStats
+
+		switch sel := selection.(type) {
+		case *ast.Field:
+			s = m.field(sel, parent)
+			if sel.Alias != nil {
+				s.aliases = add(s.aliases, 1)
+			}
+
+		case *ast.InlineFragment:
+			t := parent
+			if sel.TypeCondition != nil {
+				t = m.schema.Type(sel.TypeCondition.Name.Value)
+			}
+			s = m.selectionSet(sel.SelectionSet, t)
+
+		case *ast.FragmentSpread:
+			s = m.spread(sel.Name.Value, parent)
+		}
+
+		if s.depth > stats.depth {
+			stats.depth = s.depth
+		}
+		stats.complexity = add(stats.complexity, s.complexity)
+		stats.aliases = add(stats.aliases, s.aliases)
+	}
+
+	return stats
+}
+
+// spread measures a named fragment spread into the parent type
+func (m *measurer) spread(name string, parent graphql.Type) queryStats {
+	frag, ok := m.fragments[name]
+	if !ok || m.visiting[name] {
+		return queryStats{}
+	}
+
+	key := spreadKey{fragment: name}
+	if parent != nil {
+		key.parent = parent.Name()
+	}
+	if stats, ok := m.spreads[key]; ok {
+		return stats
+	}
+
+	m.visiting[name] = true
+	stats := m.selectionSet(frag.SelectionSet, m.schema.Type(frag.TypeCondition.Name.Value))
+	delete(m.visiting, name)
+
+	m.spreads[key] = stats
+	return stats
+}
+
+// introspectionFields are the meta fields every type has, or the root
+// query has for __schema and __type, which the schema's own fields leave out
+var introspectionFields = map[string]*graphql.FieldDefinition{
+	"__schema":   graphql.SchemaMetaFieldDef,
+	"__type":     graphql.TypeMetaFieldDef,
+	"__typename": graphql.TypeNameMetaFieldDef,
+}
+
+// field measures one field, counting the field itself. An introspection
+// field and everything under it costs nothing
+func (m *measurer) field(f *ast.Field, parent graphql.Type) queryStats {
+	var fieldType graphql.Type
+	parentName := ""
+	if obj, ok := parent.(*graphql.Object); ok {
+		parentName = obj.Name()
+		if def, ok := obj.Fields()[f.Name.Value]; ok {
+			fieldType = def.Type
+		}
+	}
+	if def, ok := introspectionFields[f.Name.Value]; ok {
+		fieldType = def.Type
+	}
+
+	var child graphql.Type
+	if fieldType != nil {
+		child, _ = graphql.GetNamed(fieldType).(graphql.Type)
+	}
+	stats := m.selectionSet(f.SelectionSet, child)
+	stats.depth++
+
+	if strings.HasPrefix(f.Name.Value, "__") {
+		stats.complexity = 0
+		return stats
+	}
+
+	annotation, ok := graphQLCosts[parentName+"."+f.Name.Value]
+	if !ok && f.SelectionSet != nil {
+		annotation.cost = 1
+	}
+
+	items := 1
+	if isList(fieldType) {
+		items = m.items(f, annotation)
+	}
+
+	stats.complexity = add(annotation.cost, mul(items, stats.complexity))
+	return stats
+}
+
+// defaultListItems is how many results a list field without a limit
+// argument or an annotation is expected to return
+const defaultListItems = 10
+
+// items returns how many results a list field asks for with its limit
+// argument, or else the number its annotation expects. A limit variable
+// that was not sent takes its default value
+func (m *measurer) items(f *ast.Field, annotation fieldCost) int {
+	for _, arg := range f.Arguments {
+		if arg.Name.Value != "limit" {
+			continue
+		}
+
+		value := arg.Value
+		if v, ok := value.(*ast.Variable); ok {
+			if _, sent := m.variables[v.Name.Value]; !sent && m.defaults[v.Name.Value] != nil {
+				value = m.defaults[v.Name.Value]
+			}
+		}
+
+		switch v := value.(type) {
+		case *ast.IntValue:
+			if n, err := strconv.Atoi(v.Value); err == nil && n > 0 {
+				return saturate(float64(n))
+			}
+		case *ast.Variable:
+			switch n := m.variables[v.Name.Value].(type) {
+			case float64:
+				if n > 0 {
+					return saturate(n)
+				}
+			case int:
+				if n > 0 {
+					return saturate(float64(n))
+				}
+			}
+		}
+	}
+
+	if annotation.items > 0 {
+		return annotation.items
+	}
+	return defaultListItems
+}
+
+// saturate converts a positive number to an int, saturated at maxMeasure
+func saturate(n float64) int {
+	if n > maxMeasure {
+		return maxMeasure
+	}
+	return int(n)
+}
+
+func isList(t graphql.Type) bool {
+	if nonNull, ok := t.(*graphql.NonNull); ok {
+		t = nonNull.OfType
+	}
+	_, ok := t.(*graphql.List)
+	return ok
+}
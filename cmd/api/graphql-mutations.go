@@ -0,0 +1,352 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cmd-ctrl-q/go-movies-server/models"
+	"github.com/graphql-go/graphql"
+)
+
+// error codes reported in the extensions of GraphQL errors
+const (
+	codeUnauthenticated = "UNAUTHENTICATED"
+	codeForbidden       = "FORBIDDEN"
+	codeBadInput        = "BAD_USER_INPUT"
+	codeNotFound        = "NOT_FOUND"
+	codeConflict        = "CONFLICT"
+	codeInternal        = "INTERNAL_SERVER_ERROR"
+)
+
+var authFailureKey contextKey = "authFailure"
+
+// authFailure is why the credentials of a GraphQL request were rejected
+type authFailure struct {
+	status int
+	err    error
+}
+
+// graphQLError is a resolver error whose code, and the message of every
+// invalid input field, are reported in the error's extensions
+type graphQLError struct {
+	message string
+	code    string
+	fields  map[string]string
+	version int // current version of a movie, on a version conflict
+}
+
+func (e *graphQLError) Error() string {
+	return e.message
+}
+
+func (e *graphQLError) Extensions() map[string]interface{} {
+	ext := map[string]interface{}{"code": e.code}
+	if len(e.fields) > 0 {
+		ext["fields"] = e.fields
+	}
+	if e.version != 0 {
+		ext["version"] = e.version
+	}
+	return ext
+}
+
+// withCaller authenticates a GraphQL request that carries credentials,
+// with the same checks as checkToken. Anonymous callers may still run
+// queries, so rejected credentials are kept in the context and reported
+// only by the fields that need a caller
+func (app *application) withCaller(r *http.Request) context.Context {
+	ctx := r.Context()
+	if r.Header.Get("X-API-Key") == "" && r.Header.Get("Authorization") == "" {
+		return ctx
+	}
+
+	p, status, err := app.authenticate(r)
+	if err != nil {
+		return context.WithValue(ctx, authFailureKey, authFailure{status: status, err: err})
+	}
+	return context.WithValue(ctx, principalKey, p)
+}
+
+// authorize is the GraphQL counterpart of checkToken and requireRole. It
+// returns an error unless the caller holds at least the given role
+func authorize(ctx context.Context, role string) error {
+	if failure, ok := ctx.Value(authFailureKey).(authFailure); ok {
+		code := codeUnauthenticated
+		if failure.status == http.StatusInternalServerError {
+			code = codeInternal
+		}
+		return &graphQLError{message: failure.err.Error(), code: code}
+	}
+
+	p, ok := principalFromContext(ctx)
+	if !ok {
+		return &graphQLError{message: "unauthorized", code: codeUnauthenticated}
+	}
+	if !p.hasRole(role) {
+		return &graphQLError{message: "forbidden - requires " + role + " role", code: codeForbidden}
+	}
+	return nil
+}
+
+// guard wraps a resolver so that it only runs for callers holding role
+func guard(role string, resolve graphql.FieldResolveFn) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		err := authorize(p.Context, role)
+		if err != nil {
+			return nil, err
+		}
+		return resolve(p)
+	}
+}
+
+// mutationError turns an error from the models into a GraphQL error.
+// entity names what was not found on sql.ErrNoRows
+func (app *application) mutationError(err error, entity string) error {
+	switch {
+	case errors.Is(err, models.ErrUnknownGenre):
+		return &graphQLError{
+			message: "invalid input",
+			code:    codeBadInput,
+			fields:  map[string]string{"genre_ids": err.Error()},
+		}
+	case errors.Is(err, models.ErrDuplicateGenre),
+		errors.Is(err, models.ErrVersionConflict):
+		return &graphQLError{message: err.Error(), code: codeConflict}
+	case errors.Is(err, models.ErrGenreInUse):
+		return &graphQLError{
+			message: "genre is still assigned to movies, delete with cascade: true to remove it from them",
+			code:    codeConflict,
+		}
+	case errors.Is(err, sql.ErrNoRows):
+		return &graphQLError{message: entity + " not found", code: codeNotFound}
+	}
+
+	app.logger.Println("error in graphql mutation:", err)
+	return &graphQLError{message: err.Error(), code: codeInternal}
+}
+
+// movieInput copies a MovieInput onto movie and returns the genre ids it
+// names, nil when it names none. Optional fields left out keep the value
+// movie already has
+func movieInput(input map[string]interface{}, movie *models.Movie) ([]int, error) {
+	invalid := make(map[string]string)
+
+	title, _ := input["title"].(string)
+	movie.Title = strings.TrimSpace(title)
+	if movie.Title == "" {
+		invalid["title"] = "must not be empty"
+	}
+
+	if v, ok := input["description"].(string); ok {
+		movie.Description = v
+	}
+	if v, ok := input["mpaa_rating"].(string); ok {
+		movie.MPAARating = v
+	}
+
+	date, _ := input["release_date"].(string)
+	releaseDate, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		invalid["release_date"] = "must be a date like 2006-01-02"
+	}
+	movie.ReleaseDate = releaseDate
+	movie.Year = releaseDate.Year()
+
+	movie.Runtime, _ = input["runtime"].(int)
+	if movie.Runtime < 0 {
+		invalid["runtime"] = "must not be negative"
+	}
+	movie.Rating, _ = input["rating"].(int)
+	if movie.Rating < 0 || movie.Rating > 5 {
+		invalid["rating"] = "must be between 0 and 5"
+	}
+
+	var genreIDs []int
+	if ids, ok := input["genre_ids"].([]interface{}); ok {
+		genreIDs = []int{}
+		for _, id := range ids {
+			if n, ok := id.(int); ok {
+				genreIDs = append(genreIDs, n)
+			}
+		}
+	}
+
+	if len(invalid) > 0 {
+		return nil, &graphQLError{message: "invalid input", code: codeBadInput, fields: invalid}
+	}
+	return genreIDs, nil
+}
+
+// genreInput reads a GenreInput
+func genreInput(input map[string]interface{}) (models.Genre, error) {
+	name, _ := input["genre_name"].(string)
+	genre := models.Genre{GenreName: strings.TrimSpace(name)}
+	if genre.GenreName == "" {
+		return genre, &graphQLError{
+			message: "invalid input",
+			code:    codeBadInput,
+			fields:  map[string]string{"genre_name": "must not be empty"},
+		}
+	}
+	return genre, nil
+}
+
+// graphQLMutations returns the root mutation object. Each field checks
+// the caller itself, with the role its REST endpoint requires
+func (app *application) graphQLMutations(movieType, genreType *graphql.Object) *graphql.Object {
+	movieInputType := graphql.NewInputObject(graphql.InputObjectConfig{
+		Name: "MovieInput",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"title":        &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String)},
+			"description":  &graphql.InputObjectFieldConfig{Type: graphql.String},
+			"release_date": &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String), Description: "YYYY-MM-DD"},
+			"runtime":      &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.Int)},
+			"rating":       &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.Int)},
+			"mpaa_rating":  &graphql.InputObjectFieldConfig{Type: graphql.String},
+			"genre_ids": &graphql.InputObjectFieldConfig{
+				Type:        graphql.NewList(graphql.NewNonNull(graphql.Int)),
+				Description: "Replaces the genres of the movie when given",
+			},
+		},
+	})
+
+	genreInputType := graphql.NewInputObject(graphql.InputObjectConfig{
+		Name: "GenreInput",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"genre_name": &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String)},
+		},
+	})
+
+	id := &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)}
+
+	fields := graphql.Fields{
+		"createMovie": &graphql.Field{
+			Type:        movieType,
+			Description: "Create a movie",
+			Args: graphql.FieldConfigArgument{
+				"input": &graphql.ArgumentConfig{Type: graphql.NewNonNull(movieInputType)},
+			},
+			Resolve: guard(models.RoleEditor, func(p graphql.ResolveParams) (interface{}, error) {
+				movie := models.Movie{CreatedAt: time.Now(), UpdatedAt: time.Now()}
+				genreIDs, err := movieInput(p.Args["input"].(map[string]interface{}), &movie)
+				if err != nil {
+					return nil, err
+				}
+
+				saved, err := app.saveMovie(p.Context, movie, genreIDs, nil)
+				if err != nil {
+					return nil, app.mutationError(err, "movie")
+				}
+				return saved, nil
+			}),
+		},
+		"updateMovie": &graphql.Field{
+			Type:        movieType,
+			Description: "Update a movie. version must be the version being edited",
+			Args: graphql.FieldConfigArgument{
+				"id":      id,
+				"version": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+				"input":   &graphql.ArgumentConfig{Type: graphql.NewNonNull(movieInputType)},
+			},
+			Resolve: guard(models.RoleEditor, func(p graphql.ResolveParams) (interface{}, error) {
+				m, err := app.models.WithContext(p.Context).Movies.Get(p.Args["id"].(int))
+				if err != nil {
+					return nil, app.mutationError(err, "movie")
+				}
+				if p.Args["version"].(int) != m.Version {
+					return nil, &graphQLError{message: models.ErrVersionConflict.Error(), code: codeConflict, version: m.Version}
+				}
+
+				movie := *m
+				movie.UpdatedAt = time.Now()
+				genreIDs, err := movieInput(p.Args["input"].(map[string]interface{}), &movie)
+				if err != nil {
+					return nil, err
+				}
+
+				saved, err := app.saveMovie(p.Context, movie, genreIDs, auditMovie(m))
+				if err != nil {
+					return nil, app.mutationError(err, "movie")
+				}
+				return saved, nil
+			}),
+		},
+		"deleteMovie": &graphql.Field{
+			Type:        graphql.Boolean,
+			Description: "Move a movie to the trash",
+			Args:        graphql.FieldConfigArgument{"id": id},
+			Resolve: guard(models.RoleAdmin, func(p graphql.ResolveParams) (interface{}, error) {
+				err := app.trashMovie(p.Context, p.Args["id"].(int))
+				if err != nil {
+					return nil, app.mutationError(err, "movie")
+				}
+				return true, nil
+			}),
+		},
+		"createGenre": &graphql.Field{
+			Type:        genreType,
+			Description: "Create a genre",
+			Args: graphql.FieldConfigArgument{
+				"input": &graphql.ArgumentConfig{Type: graphql.NewNonNull(genreInputType)},
+			},
+			Resolve: guard(models.RoleAdmin, func(p graphql.ResolveParams) (interface{}, error) {
+				genre, err := genreInput(p.Args["input"].(map[string]interface{}))
+				if err != nil {
+					return nil, err
+				}
+
+				saved, err := app.saveGenre(p.Context, genre)
+				if err != nil {
+					return nil, app.mutationError(err, "genre")
+				}
+				return saved, nil
+			}),
+		},
+		"updateGenre": &graphql.Field{
+			Type:        genreType,
+			Description: "Rename a genre",
+			Args: graphql.FieldConfigArgument{
+				"id":    id,
+				"input": &graphql.ArgumentConfig{Type: graphql.NewNonNull(genreInputType)},
+			},
+			Resolve: guard(models.RoleAdmin, func(p graphql.ResolveParams) (interface{}, error) {
+				genre, err := genreInput(p.Args["input"].(map[string]interface{}))
+				if err != nil {
+					return nil, err
+				}
+				genre.ID = p.Args["id"].(int)
+
+				saved, err := app.saveGenre(p.Context, genre)
+				if err != nil {
+					return nil, app.mutationError(err, "genre")
+				}
+				return saved, nil
+			}),
+		},
+		"deleteGenre": &graphql.Field{
+			Type:        graphql.Boolean,
+			Description: "Delete a genre. Genres still assigned to movies need cascade: true",
+			Args: graphql.FieldConfigArgument{
+				"id": id,
+				"cascade": &graphql.ArgumentConfig{
+					Type:         graphql.Boolean,
+					DefaultValue: false,
+				},
+			},
+			Resolve: guard(models.RoleAdmin, func(p graphql.ResolveParams) (interface{}, error) {
+				cascade, _ := p.Args["cascade"].(bool)
+				err := app.removeGenre(p.Context, p.Args["id"].(int), cascade)
+				if err != nil {
+					return nil, app.mutationError(err, "genre")
+				}
+				return true, nil
+			}),
+		},
+	}
+
+	return graphql.NewObject(graphql.ObjectConfig{Name: "RootMutation", Fields: fields})
+}
@@ -1,147 +1,447 @@
 package main
 
 import (
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
 	"strings"
 
 	"github.com/cmd-ctrl-q/go-movies-server/models"
 	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/source"
 )
 
-var err error
-var movies []*models.Movie
-
-// GraphQL schema definition
-var fields = graphql.Fields{
-	// returns a single movie
-	"movie": &graphql.Field{
-		Type:        movieType,
-		Description: "Get movie by id",
-		Args: graphql.FieldConfigArgument{
-			"id": &graphql.ArgumentConfig{
-				Type: graphql.Int,
-			},
-		},
-		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
-			id, ok := p.Args["id"].(int)
-			if ok {
-				// find movie for the given id
-				for _, movie := range movies {
-					if movie.ID == id {
-						return movie, nil
-					}
-				}
-			}
-			return nil, nil
+// graphQLSchema builds the GraphQL schema. It is built once at startup;
+// resolvers query the models with the context of the request being served
+func (app *application) graphQLSchema() (graphql.Schema, error) {
+	// paging arguments shared by the list fields
+	page := graphql.FieldConfigArgument{
+		"limit": &graphql.ArgumentConfig{
+			Type:         graphql.Int,
+			DefaultValue: defaultPageSize,
 		},
-	},
-	// return a list of movies
-	"list": &graphql.Field{
-		Type:        graphql.NewList(movieType),
-		Description: "Get all movies",
-		Resolve: func(params graphql.ResolveParams) (interface{}, error) {
-			return movies, nil
+		"offset": &graphql.ArgumentConfig{
+			Type:         graphql.Int,
+			DefaultValue: 0,
 		},
-	},
-	"search": &graphql.Field{
-		Type:        graphql.NewList(movieType),
-		Description: "Search movies by title",
-		Args: graphql.FieldConfigArgument{
-			"titleContains": &graphql.ArgumentConfig{
-				Type: graphql.String,
+	}
+
+	movieType := newMovieType()
+	genreType := newGenreType()
+	linkTypes(movieType, genreType)
+
+	fields := graphql.Fields{
+		// returns a single movie
+		"movie": &graphql.Field{
+			Type:        movieType,
+			Description: "Get movie by id",
+			Args: graphql.FieldConfigArgument{
+				"id": &graphql.ArgumentConfig{
+					Type: graphql.Int,
+				},
 			},
-		},
-		Resolve: func(params graphql.ResolveParams) (interface{}, error) {
-			var theList []*models.Movie
-			search, ok := params.Args["titleContains"].(string)
-			if ok {
-				for _, currentMovie := range movies {
-					if strings.Contains(currentMovie.Title, search) {
-						// add to list
-						theList = append(theList, currentMovie)
-					}
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				id, ok := p.Args["id"].(int)
+				if !ok {
+					return nil, nil
 				}
-			}
-			return theList, nil
-		},
-	},
-}
 
-var movieType = graphql.NewObject(
-	// objects to be exposed
-	graphql.ObjectConfig{
-		Name: "Movie",
-		Fields: graphql.Fields{
-			"id": &graphql.Field{
-				Type: graphql.Int,
-			},
-			"title": &graphql.Field{
-				Type: graphql.String,
-			},
-			"description": &graphql.Field{
-				Type: graphql.String,
+				movie, err := app.models.WithContext(p.Context).Movies.Get(id)
+				if errors.Is(err, sql.ErrNoRows) {
+					return nil, nil
+				}
+				return movie, err
 			},
-			"year": &graphql.Field{
-				Type: graphql.Int,
+		},
+		// return a page of movies
+		"list": &graphql.Field{
+			Type:        graphql.NewList(movieType),
+			Description: "Get a page of movies, sorted by title",
+			Args:        page,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return app.listMovies(p, "")
 			},
-			"release_date": &graphql.Field{
-				Type: graphql.DateTime,
+		},
+		"search": &graphql.Field{
+			Type:        graphql.NewList(movieType),
+			Description: "Search movies by title, ignoring case",
+			Args: graphql.FieldConfigArgument{
+				"titleContains": &graphql.ArgumentConfig{
+					Type: graphql.String,
+				},
+				"limit":  page["limit"],
+				"offset": page["offset"],
 			},
-			"runtime": &graphql.Field{
-				Type: graphql.Int,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				search, _ := p.Args["titleContains"].(string)
+				if search == "" {
+					return []*models.Movie{}, nil
+				}
+				return app.listMovies(p, search)
 			},
-			"rating": &graphql.Field{
-				Type: graphql.Int,
+		},
+		"genres": &graphql.Field{
+			Type:        graphql.NewList(genreType),
+			Description: "Get all genres",
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return app.models.WithContext(p.Context).Genres.GenresAll()
 			},
-			"mpaa_rating": &graphql.Field{
-				Type: graphql.String,
+		},
+		"genre": &graphql.Field{
+			Type:        genreType,
+			Description: "Get genre by id",
+			Args: graphql.FieldConfigArgument{
+				"id": &graphql.ArgumentConfig{
+					Type: graphql.Int,
+				},
 			},
-			"created_at": &graphql.Field{
-				Type: graphql.DateTime,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				id, ok := p.Args["id"].(int)
+				if !ok {
+					return nil, nil
+				}
+
+				genre, err := app.models.WithContext(p.Context).Genres.GetGenre(id)
+				if errors.Is(err, sql.ErrNoRows) {
+					return nil, nil
+				}
+				return genre, err
 			},
-			"updated_at": &graphql.Field{
-				Type: graphql.DateTime,
+		},
+	}
+
+	rootQuery := graphql.ObjectConfig{Name: "RootQuery", Fields: fields}
+	return graphql.NewSchema(graphql.SchemaConfig{
+		Query:    graphql.NewObject(rootQuery),
+		Mutation: app.graphQLMutations(movieType, genreType),
+	})
+}
+
+// listMovies resolves one page of movies, sorted by title, whose title
+// contains title
+func (app *application) listMovies(p graphql.ResolveParams, title string) (interface{}, error) {
+	limit, _ := p.Args["limit"].(int)
+	offset, _ := p.Args["offset"].(int)
+	if limit < 1 || limit > maxPageSize {
+		return nil, fmt.Errorf("limit must be between 1 and %d", maxPageSize)
+	}
+	if offset < 0 {
+		return nil, errors.New("offset must be a non-negative integer")
+	}
+
+	movies, _, err := app.models.WithContext(p.Context).Movies.List(models.MovieFilter{
+		Limit:  limit,
+		Offset: offset,
+		Title:  title,
+	})
+	return movies, err
+}
+
+// newMovieType returns the Movie object without its genres, which
+// graphQLSchema links once the Genre object exists
+func newMovieType() *graphql.Object {
+	return graphql.NewObject(
+		// objects to be exposed
+		graphql.ObjectConfig{
+			Name: "Movie",
+			Fields: graphql.Fields{
+				"id": &graphql.Field{
+					Type: graphql.Int,
+				},
+				"title": &graphql.Field{
+					Type: graphql.String,
+				},
+				"description": &graphql.Field{
+					Type: graphql.String,
+				},
+				"year": &graphql.Field{
+					Type: graphql.Int,
+				},
+				"release_date": &graphql.Field{
+					Type: graphql.DateTime,
+				},
+				"runtime": &graphql.Field{
+					Type: graphql.Int,
+				},
+				"rating": &graphql.Field{
+					Type: graphql.Int,
+				},
+				"mpaa_rating": &graphql.Field{
+					Type: graphql.String,
+				},
+				"created_at": &graphql.Field{
+					Type: graphql.DateTime,
+				},
+				"updated_at": &graphql.Field{
+					Type: graphql.DateTime,
+				},
+				"poster": &graphql.Field{
+					Type: graphql.String,
+				},
+				"version": &graphql.Field{
+					Type: graphql.Int,
+				},
 			},
-			"poster": &graphql.Field{
-				Type: graphql.String,
+		},
+	)
+}
+
+// newGenreType returns the Genre object without its movies
+func newGenreType() *graphql.Object {
+	return graphql.NewObject(
+		graphql.ObjectConfig{
+			Name: "Genre",
+			Fields: graphql.Fields{
+				"id": &graphql.Field{
+					Type: graphql.Int,
+				},
+				"genre_name": &graphql.Field{
+					Type: graphql.String,
+				},
 			},
 		},
-	},
+	)
+}
+
+// linkTypes adds the fields between movies and genres. They resolve
+// through the request's loaders, so nested lists are fetched in one
+// query per level instead of one per parent
+func linkTypes(movieType, genreType *graphql.Object) {
+	movieType.AddFieldConfig("genres", &graphql.Field{
+		Type:        graphql.NewList(genreType),
+		Description: "Genres of the movie, by name",
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			movie, ok := p.Source.(*models.Movie)
+			if !ok {
+				return nil, nil
+			}
+			return loadersFromContext(p.Context).genresByMovie.load(movie.ID), nil
+		},
+	})
+
+	genreType.AddFieldConfig("movies", &graphql.Field{
+		Type:        graphql.NewList(movieType),
+		Description: "Movies in the genre, by title",
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			genre, ok := p.Source.(*models.Genre)
+			if !ok {
+				return nil, nil
+			}
+			return loadersFromContext(p.Context).moviesByGenre.load(genre.ID), nil
+		},
+	})
+}
+
+// media types of GraphQL responses
+const (
+	graphQLResponseType = "application/graphql-response+json"
+	jsonType            = "application/json"
 )
 
+// graphQLRequest is a GraphQL-over-HTTP request, sent as a JSON body or,
+// for queries, in the url
+type graphQLRequest struct {
+	Query         string                 `json:"query"`
+	Variables     map[string]interface{} `json:"variables"`
+	OperationName string                 `json:"operationName"`
+	Extensions    map[string]interface{} `json:"extensions"`
+}
+
+// moviesGraphQL serves GraphQL over HTTP. Queries may be sent with GET or
+// POST, mutations only with POST. Credentials are optional and checked by
+// the fields that need them, so a response can hold data for some fields
+// and errors for others
 func (app *application) moviesGraphQL(w http.ResponseWriter, r *http.Request) {
-	movies, err = app.models.DB.All()
-	if err != nil {
-		app.errorJSON(w, http.StatusInternalServerError, err)
+	w.Header().Add("Vary", "Accept")
+	w.Header().Add("Vary", "Authorization")
+	w.Header().Add("Vary", "X-API-Key")
+
+	mediaType := graphQLMediaType(r.Header.Get("Accept"))
+	if mediaType == "" {
+		app.errorJSON(w, http.StatusNotAcceptable, fmt.Errorf("responses are %s or %s", graphQLResponseType, jsonType))
 		return
 	}
 
-	q, err := io.ReadAll(r.Body)
+	req, status, err := readGraphQLRequest(w, r)
 	if err != nil {
-		app.errorJSON(w, http.StatusBadRequest, fmt.Errorf("error reading body: %w", err))
+		app.writeGraphQL(w, mediaType, status, &graphql.Result{Errors: gqlerrors.FormatErrors(err)})
+		return
 	}
-	query := string(q)
 
-	rootQuery := graphql.ObjectConfig{Name: "RootQuery", Fields: fields}
-	schemaConfig := graphql.SchemaConfig{Query: graphql.NewObject(rootQuery)}
-	schema, err := graphql.NewSchema(schemaConfig)
+	// the document is parsed and validated once, then measured and
+	// executed as parsed
+	doc, err := parser.Parse(parser.ParseParams{Source: source.NewSource(&source.Source{
+		Body: []byte(req.Query),
+		Name: "GraphQL request",
+	})})
 	if err != nil {
-		app.errorJSON(w, http.StatusBadRequest, errors.New("failed to create schema"))
+		app.writeGraphQLErrors(w, mediaType, gqlerrors.FormatErrors(err))
+		return
+	}
+	validation := graphql.ValidateDocument(&app.schema, doc, nil)
+	if !validation.IsValid {
+		app.writeGraphQLErrors(w, mediaType, validation.Errors)
 		return
 	}
 
-	params := graphql.Params{Schema: schema, RequestString: query}
-	resp := graphql.Do(params)
-	if len(resp.Errors) > 0 {
-		app.errorJSON(w, http.StatusBadRequest, fmt.Errorf("failed: %+v", resp.Errors))
+	op := findOperation(doc, req.OperationName)
+	if r.Method == http.MethodGet && op != nil && op.Operation == ast.OperationTypeMutation {
+		w.Header().Set("Allow", http.MethodPost)
+		app.writeGraphQL(w, mediaType, http.StatusMethodNotAllowed,
+			&graphql.Result{Errors: gqlerrors.FormatErrors(errors.New("mutations must be sent with POST"))})
+		return
+	}
+
+	if op != nil {
+		errs := app.config.graphql.check(measure(app.schema, doc, op, req.Variables))
+		if len(errs) > 0 {
+			app.writeGraphQLErrors(w, mediaType, errs)
+			return
+		}
+	}
+
+	resp := graphql.Execute(graphql.ExecuteParams{
+		Schema:        app.schema,
+		AST:           doc,
+		Args:          req.Variables,
+		OperationName: req.OperationName,
+		Context:       withLoaders(app.withCaller(r), app.models),
+	})
+
+	// a request that could not run at all has no data
+	if resp.Data == nil {
+		app.writeGraphQLErrors(w, mediaType, resp.Errors)
+		return
+	}
+	app.writeGraphQL(w, mediaType, http.StatusOK, resp)
+}
+
+// graphQLMediaType picks the response media type from an Accept header:
+// application/graphql-response+json unless only application/json is
+// accepted. It returns "" when neither is
+func graphQLMediaType(accept string) string {
+	if strings.TrimSpace(accept) == "" {
+		return graphQLResponseType
+	}
+
+	var wildcard, plain bool
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil || params["q"] == "0" {
+			continue
+		}
+		switch mediaType {
+		case graphQLResponseType:
+			return graphQLResponseType
+		case jsonType:
+			plain = true
+		case "application/*", "*/*":
+			wildcard = true
+		}
+	}
+
+	switch {
+	case plain:
+		return jsonType
+	case wildcard:
+		return graphQLResponseType
+	}
+	return ""
+}
+
+// maxGraphQLBody is the largest POST body a GraphQL request may have
+const maxGraphQLBody = 1 << 20
+
+// readGraphQLRequest reads a request from the url of a GET or the JSON body
+// of a POST. On failure it returns the status to reject the request with
+func readGraphQLRequest(w http.ResponseWriter, r *http.Request) (graphQLRequest, int, error) {
+	var req graphQLRequest
+
+	if r.Method == http.MethodGet {
+		qs := r.URL.Query()
+		req.Query = qs.Get("query")
+		req.OperationName = qs.Get("operationName")
+		if v := qs.Get("variables"); v != "" {
+			err := json.Unmarshal([]byte(v), &req.Variables)
+			if err != nil {
+				return req, http.StatusBadRequest, errors.New("variables must be a JSON object")
+			}
+		}
+	} else {
+		mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil || mediaType != jsonType {
+			return req, http.StatusUnsupportedMediaType, fmt.Errorf("requests must be sent as %s", jsonType)
+		}
+
+		// the reader stops at the limit with an error, after returning
+		// exactly maxGraphQLBody bytes
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxGraphQLBody))
+		if err != nil && len(body) == maxGraphQLBody {
+			return req, http.StatusRequestEntityTooLarge, fmt.Errorf("body must not be larger than %d bytes", maxGraphQLBody)
+		}
+		if err != nil {
+			return req, http.StatusBadRequest, fmt.Errorf("error reading body: %w", err)
+		}
+		err = json.Unmarshal(body, &req)
+		if err != nil {
+			return req, http.StatusBadRequest, fmt.Errorf("body must be a JSON object with a query, variables and operationName: %w", err)
+		}
+	}
+
+	if strings.TrimSpace(req.Query) == "" {
+		return req, http.StatusBadRequest, errors.New("query is required")
+	}
+
+	return req, http.StatusOK, nil
+}
+
+// findOperation returns the operation a document runs, or nil when the
+// document does not name it; Execute reports that
+func findOperation(doc *ast.Document, operationName string) *ast.OperationDefinition {
+	var found *ast.OperationDefinition
+	for _, def := range doc.Definitions {
+		op, ok := def.(*ast.OperationDefinition)
+		if !ok {
+			continue
+		}
+		if operationName == "" && found != nil {
+			return nil
+		}
+		if operationName == "" || op.Name != nil && op.Name.Value == operationName {
+			found = op
+		}
+	}
+	return found
+}
+
+// writeGraphQLErrors writes the errors of a request that could not run at
+// all. Legacy json clients get a 200 for it, as they expect of any GraphQL
+// response
+func (app *application) writeGraphQLErrors(w http.ResponseWriter, mediaType string, errs []gqlerrors.FormattedError) {
+	status := http.StatusOK
+	if mediaType == graphQLResponseType {
+		status = http.StatusBadRequest
+	}
+	app.writeGraphQL(w, mediaType, status, &graphql.Result{Errors: errs})
+}
+
+// writeGraphQL writes a GraphQL response in the negotiated media type
+func (app *application) writeGraphQL(w http.ResponseWriter, mediaType string, status int, resp *graphql.Result) {
+	js, err := json.Marshal(resp)
+	if err != nil {
+		app.logger.Println("error marshalling graphql response")
+		app.errorJSON(w, http.StatusInternalServerError, err)
+		return
 	}
 
-	j, _ := json.MarshalIndent(resp, "", "\t")
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	w.Write(j)
+	w.Header().Set("Content-Type", mediaType+"; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(js)
 }
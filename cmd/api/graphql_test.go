@@ -0,0 +1,626 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cmd-ctrl-q/go-movies-server/models"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+)
+
+// graphQL posts a query as a GraphQL-over-HTTP JSON body
+func (ta *testApp) graphQL(t *testing.T, query string, header http.Header) *httptest.ResponseRecorder {
+	t.Helper()
+
+	body, err := json.Marshal(graphQLRequest{Query: query})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ta.do(t, http.MethodPost, "/v1/graphql", string(body), header)
+}
+
+type graphQLResponse struct {
+	Data   map[string]json.RawMessage `json:"data"`
+	Errors []struct {
+		Message    string                 `json:"message"`
+		Extensions map[string]interface{} `json:"extensions"`
+	} `json:"errors"`
+}
+
+type graphQLMovie struct {
+	ID    int    `json:"id"`
+	Title string `json:"title"`
+}
+
+func TestGraphQL(t *testing.T) {
+	ta := newTestApp(t)
+	id := ta.addMovie(t, "Casablanca", 1942, 5)
+	ta.addMovie(t, "Heat", 1995, 4)
+	ta.addMovie(t, "The Big Sleep", 1946, 4)
+
+	query := func(q string) map[string]json.RawMessage {
+		t.Helper()
+		rr := ta.graphQL(t, q, nil)
+		expectStatus(t, rr, http.StatusOK)
+		var resp struct {
+			Data map[string]json.RawMessage `json:"data"`
+		}
+		decode(t, rr, &resp)
+		return resp.Data
+	}
+	titles := func(js json.RawMessage) []string {
+		t.Helper()
+		var movies []graphQLMovie
+		if err := json.Unmarshal(js, &movies); err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, movie := range movies {
+			got = append(got, movie.Title)
+		}
+		return got
+	}
+
+	data := query(`{ search(titleContains: "casa") { id title } }`)
+	if got := titles(data["search"]); !equalStrings(got, []string{"Casablanca"}) {
+		t.Errorf("got search %v, want only Casablanca", got)
+	}
+
+	data = query(`{ list(limit: 2, offset: 1) { title } }`)
+	if got := titles(data["list"]); !equalStrings(got, []string{"Heat", "The Big Sleep"}) {
+		t.Errorf("got list %v, want the second page", got)
+	}
+
+	data = query(`{ movie(id: ` + itoa(id) + `) { id title } missing: movie(id: 999) { id } }`)
+	var movie graphQLMovie
+	if err := json.Unmarshal(data["movie"], &movie); err != nil {
+		t.Fatal(err)
+	}
+	if movie.ID != id || movie.Title != "Casablanca" || string(data["missing"]) != "null" {
+		t.Errorf("got movie %s and missing %s", data["movie"], data["missing"])
+	}
+
+	// a trashed movie is gone from every field
+	err := ta.store.DeleteMovie(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = query(`{ movie(id: ` + itoa(id) + `) { id } search(titleContains: "Casa") { id } }`)
+	if string(data["movie"]) != "null" || len(titles(data["search"])) != 0 {
+		t.Errorf("got %s and %s, want the trashed movie hidden", data["movie"], data["search"])
+	}
+
+	// a failing field is reported next to the data of the others
+	rr := ta.graphQL(t, `{ list(limit: 1000) { id } genres { id } }`, nil)
+	expectStatus(t, rr, http.StatusOK)
+	var failed graphQLResponse
+	decode(t, rr, &failed)
+	if len(failed.Errors) != 1 || string(failed.Data["list"]) != "null" || failed.Data["genres"] == nil {
+		t.Errorf("got %+v, want an error for the list only", failed)
+	}
+
+	rr = ta.graphQL(t, `{ list { nope } }`, nil)
+	expectStatus(t, rr, http.StatusBadRequest)
+}
+
+// TestGraphQLConcurrent runs queries in parallel; run it with -race
+func TestGraphQLConcurrent(t *testing.T) {
+	ta := newTestApp(t)
+	ta.addMovie(t, "Casablanca", 1942, 5)
+	ta.addMovie(t, "Heat", 1995, 4)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(q string) {
+			defer wg.Done()
+			rr := ta.graphQL(t, q, nil)
+			if rr.Code != http.StatusOK {
+				t.Errorf("%s: got status %d", q, rr.Code)
+			}
+		}([]string{`{ list { id } }`, `{ search(titleContains: "Heat") { title } }`}[i%2])
+	}
+	wg.Wait()
+}
+
+// countingStore counts the batched lookups the GraphQL loaders make
+type countingStore struct {
+	models.MovieStore
+	models.GenreStore
+	genreMovies, movieGenres int
+}
+
+func (c *countingStore) GenreMovies(genreIDs []int) (map[int][]*models.Movie, error) {
+	c.genreMovies++
+	return c.MovieStore.GenreMovies(genreIDs)
+}
+
+func (c *countingStore) MovieGenres(movieIDs []int) (map[int][]*models.Genre, error) {
+	c.movieGenres++
+	return c.GenreStore.MovieGenres(movieIDs)
+}
+
+func TestGraphQLGenres(t *testing.T) {
+	ta := newTestApp(t)
+	crime := ta.addGenre(t, "Crime")
+	drama := ta.addGenre(t, "Drama")
+	ta.addGenre(t, "Western")
+	ta.addMovie(t, "Casablanca", 1942, 5, drama)
+	ta.addMovie(t, "Heat", 1995, 4, crime, drama)
+	ta.addMovie(t, "The Big Sleep", 1946, 4, crime)
+
+	counter := &countingStore{MovieStore: ta.models.Movies, GenreStore: ta.models.Genres}
+	ta.models.Movies = counter
+	ta.models.Genres = counter
+
+	rr := ta.graphQL(t, `{ list { title genres { genre_name movies { title } } } }`, nil)
+	expectStatus(t, rr, http.StatusOK)
+	var resp struct {
+		Data struct {
+			List []struct {
+				Title  string `json:"title"`
+				Genres []struct {
+					GenreName string         `json:"genre_name"`
+					Movies    []graphQLMovie `json:"movies"`
+				} `json:"genres"`
+			} `json:"list"`
+		} `json:"data"`
+	}
+	decode(t, rr, &resp)
+
+	if len(resp.Data.List) != 3 {
+		t.Fatalf("got %d movies, want 3", len(resp.Data.List))
+	}
+	heat := resp.Data.List[1]
+	if heat.Title != "Heat" || len(heat.Genres) != 2 ||
+		heat.Genres[0].GenreName != "Crime" || heat.Genres[1].GenreName != "Drama" {
+		t.Fatalf("got %+v, want Heat in Crime and Drama", heat)
+	}
+	var dramas []string
+	for _, movie := range heat.Genres[1].Movies {
+		dramas = append(dramas, movie.Title)
+	}
+	if !equalStrings(dramas, []string{"Casablanca", "Heat"}) {
+		t.Errorf("got dramas %v", dramas)
+	}
+
+	// one lookup per level of nesting, however many movies and genres
+	if counter.movieGenres != 1 || counter.genreMovies != 1 {
+		t.Errorf("got %d genre and %d movie lookups, want 1 each", counter.movieGenres, counter.genreMovies)
+	}
+
+	rr = ta.graphQL(t,
+		`{ genres { genre_name movies { title } } genre(id: `+itoa(crime)+`) { genre_name } missing: genre(id: 999) { id } }`, nil)
+	expectStatus(t, rr, http.StatusOK)
+	var genres struct {
+		Data struct {
+			Genres []struct {
+				GenreName string         `json:"genre_name"`
+				Movies    []graphQLMovie `json:"movies"`
+			} `json:"genres"`
+			Genre   *models.Genre `json:"genre"`
+			Missing *models.Genre `json:"missing"`
+		} `json:"data"`
+	}
+	decode(t, rr, &genres)
+	if len(genres.Data.Genres) != 3 || genres.Data.Genres[2].GenreName != "Western" ||
+		genres.Data.Genres[2].Movies == nil || len(genres.Data.Genres[2].Movies) != 0 {
+		t.Errorf("got genres %+v, want Western with no movies", genres.Data.Genres)
+	}
+	if genres.Data.Genre == nil || genres.Data.Genre.GenreName != "Crime" || genres.Data.Missing != nil {
+		t.Errorf("got genre %+v and missing %+v", genres.Data.Genre, genres.Data.Missing)
+	}
+}
+
+func TestGraphQLMutations(t *testing.T) {
+	ta := newTestApp(t)
+	viewer := ta.bearer(t, ta.addUser(t, "viewer@example.com", "password1", models.RoleViewer))
+	editorUser := ta.addUser(t, "editor@example.com", "password1", models.RoleEditor)
+	editor := ta.bearer(t, editorUser)
+	admin := ta.bearer(t, ta.addUser(t, "admin@example.com", "password1", models.RoleAdmin))
+	drama := ta.addGenre(t, "Drama")
+
+	gql := func(q string, header http.Header) graphQLResponse {
+		t.Helper()
+		rr := ta.graphQL(t, q, header)
+		expectStatus(t, rr, http.StatusOK)
+		var resp graphQLResponse
+		decode(t, rr, &resp)
+		return resp
+	}
+	code := func(resp graphQLResponse) string {
+		t.Helper()
+		if len(resp.Errors) != 1 {
+			t.Fatalf("got errors %+v, want one", resp.Errors)
+		}
+		c, _ := resp.Errors[0].Extensions["code"].(string)
+		return c
+	}
+
+	create := `mutation { createMovie(input: {title: "Heat", description: "Cops and robbers",
+		release_date: "1995-12-15", runtime: 170, rating: 4, mpaa_rating: "R", genre_ids: [` + itoa(drama) + `]})
+		{ id title year version genres { genre_name } } }`
+
+	// each field checks the caller, and queries need no caller at all
+	bad := http.Header{"Authorization": {"Bearer nonsense"}}
+	resp := gql(create, bad)
+	if code(resp) != codeUnauthenticated {
+		t.Errorf("got %+v with a bad token", resp.Errors)
+	}
+	resp = gql(`{ genres { id } }`, bad)
+	if len(resp.Errors) != 0 {
+		t.Errorf("got %+v for a query with a bad token", resp.Errors)
+	}
+	if code(gql(create, nil)) != codeUnauthenticated {
+		t.Error("anonymous caller created a movie")
+	}
+	if code(gql(create, viewer)) != codeForbidden {
+		t.Error("viewer created a movie")
+	}
+
+	resp = gql(`mutation { createMovie(input: {title: " ", release_date: "15/12/1995", runtime: 170, rating: 9})
+		{ id } }`, editor)
+	if code(resp) != codeBadInput {
+		t.Fatalf("got %+v for invalid input", resp.Errors)
+	}
+	fields, _ := resp.Errors[0].Extensions["fields"].(map[string]interface{})
+	if len(fields) != 3 || fields["title"] == nil || fields["release_date"] == nil || fields["rating"] == nil {
+		t.Errorf("got invalid fields %v, want title, release_date and rating", fields)
+	}
+
+	resp = gql(`mutation { createMovie(input: {title: "Heat", release_date: "1995-12-15", runtime: 170,
+		rating: 4, genre_ids: [999]}) { id } }`, editor)
+	if code(resp) != codeBadInput || resp.Errors[0].Extensions["fields"] == nil {
+		t.Errorf("got %+v for an unknown genre", resp.Errors)
+	}
+
+	resp = gql(create, editor)
+	var created struct {
+		graphQLMovie
+		Year    int `json:"year"`
+		Version int `json:"version"`
+		Genres  []struct {
+			GenreName string `json:"genre_name"`
+		} `json:"genres"`
+	}
+	if err := json.Unmarshal(resp.Data["createMovie"], &created); err != nil || len(resp.Errors) != 0 {
+		t.Fatalf("got %s %+v", resp.Data["createMovie"], resp.Errors)
+	}
+	if created.Year != 1995 || created.Version != 1 || len(created.Genres) != 1 || created.Genres[0].GenreName != "Drama" {
+		t.Errorf("got created %+v", created)
+	}
+	id := itoa(created.ID)
+
+	// writes are audited and revised like their REST counterparts
+	entries, _ := ta.store.AuditLog(models.AuditFilter{Entity: "movie", Limit: 1})
+	if len(entries) != 1 || entries[0].Action != "create" || entries[0].UserID != editorUser.ID {
+		t.Errorf("got audit %+v, want the create by the editor", entries)
+	}
+	revisions, _ := ta.store.MovieRevisions(created.ID)
+	if len(revisions) != 1 {
+		t.Errorf("got %d revisions, want 1", len(revisions))
+	}
+
+	update := func(version string) graphQLResponse {
+		return gql(`mutation { updateMovie(id: `+id+`, version: `+version+`, input: {title: "Heat",
+			release_date: "1995-12-15", runtime: 170, rating: 5}) { rating description version } }`, editor)
+	}
+	resp = update("1")
+	var updated struct {
+		Rating      int    `json:"rating"`
+		Description string `json:"description"`
+		Version     int    `json:"version"`
+	}
+	if err := json.Unmarshal(resp.Data["updateMovie"], &updated); err != nil || len(resp.Errors) != 0 {
+		t.Fatalf("got %s %+v", resp.Data["updateMovie"], resp.Errors)
+	}
+	if updated.Rating != 5 || updated.Version != 2 || updated.Description != "Cops and robbers" {
+		t.Errorf("got updated %+v, want the description kept", updated)
+	}
+
+	resp = update("1")
+	if code(resp) != codeConflict || resp.Errors[0].Extensions["version"] != float64(2) {
+		t.Errorf("got %+v for a stale version", resp.Errors)
+	}
+
+	del := `mutation { deleteMovie(id: ` + id + `) }`
+	if code(gql(del, editor)) != codeForbidden {
+		t.Error("editor deleted a movie")
+	}
+	resp = gql(del, admin)
+	if string(resp.Data["deleteMovie"]) != "true" {
+		t.Errorf("got %s %+v for the delete", resp.Data["deleteMovie"], resp.Errors)
+	}
+	if code(gql(del, admin)) != codeNotFound {
+		t.Error("deleted a movie twice")
+	}
+}
+
+func TestGraphQLGenreMutations(t *testing.T) {
+	ta := newTestApp(t)
+	editor := ta.bearer(t, ta.addUser(t, "editor@example.com", "password1", models.RoleEditor))
+	admin := ta.bearer(t, ta.addUser(t, "admin@example.com", "password1", models.RoleAdmin))
+	drama := ta.addGenre(t, "Drama")
+	ta.addMovie(t, "Heat", 1995, 4, drama)
+
+	gql := func(q string, header http.Header) graphQLResponse {
+		t.Helper()
+		rr := ta.graphQL(t, q, header)
+		expectStatus(t, rr, http.StatusOK)
+		var resp graphQLResponse
+		decode(t, rr, &resp)
+		return resp
+	}
+	code := func(resp graphQLResponse) string {
+		t.Helper()
+		if len(resp.Errors) != 1 {
+			t.Fatalf("got errors %+v, want one", resp.Errors)
+		}
+		c, _ := resp.Errors[0].Extensions["code"].(string)
+		return c
+	}
+
+	create := `mutation { createGenre(input: {genre_name: "Western"}) { id genre_name } }`
+	if code(gql(create, editor)) != codeForbidden {
+		t.Error("editor created a genre")
+	}
+	resp := gql(create, admin)
+	var genre models.Genre
+	if err := json.Unmarshal(resp.Data["createGenre"], &genre); err != nil || genre.GenreName != "Western" {
+		t.Fatalf("got %s %+v", resp.Data["createGenre"], resp.Errors)
+	}
+	if code(gql(create, admin)) != codeConflict {
+		t.Error("created the same genre twice")
+	}
+	if code(gql(`mutation { createGenre(input: {genre_name: ""}) { id } }`, admin)) != codeBadInput {
+		t.Error("created a genre without a name")
+	}
+
+	resp = gql(`mutation { updateGenre(id: `+itoa(genre.ID)+`, input: {genre_name: "Westerns"}) { genre_name } }`, admin)
+	var renamed models.Genre
+	if json.Unmarshal(resp.Data["updateGenre"], &renamed); renamed.GenreName != "Westerns" {
+		t.Errorf("got %s %+v for the rename", resp.Data["updateGenre"], resp.Errors)
+	}
+	if code(gql(`mutation { updateGenre(id: 999, input: {genre_name: "X"}) { id } }`, admin)) != codeNotFound {
+		t.Error("renamed a missing genre")
+	}
+
+	del := `mutation { deleteGenre(id: ` + itoa(drama) + `) }`
+	if code(gql(del, admin)) != codeConflict {
+		t.Error("deleted a genre in use")
+	}
+	resp = gql(`mutation { deleteGenre(id: `+itoa(drama)+`, cascade: true) }`, admin)
+	if string(resp.Data["deleteGenre"]) != "true" {
+		t.Errorf("got %s %+v for the cascading delete", resp.Data["deleteGenre"], resp.Errors)
+	}
+
+	entries, _ := ta.store.AuditLog(models.AuditFilter{Entity: "genre", Limit: 10})
+	if len(entries) != 3 {
+		t.Errorf("got %d genre audit entries, want 3", len(entries))
+	}
+}
+
+func TestGraphQLTransport(t *testing.T) {
+	ta := newTestApp(t)
+	editor := ta.bearer(t, ta.addUser(t, "editor@example.com", "password1", models.RoleEditor))
+	id := ta.addMovie(t, "Casablanca", 1942, 5)
+
+	send := func(method, path, body string, header http.Header) (*httptest.ResponseRecorder, graphQLResponse) {
+		t.Helper()
+		rr := ta.do(t, method, path, body, header)
+		var resp graphQLResponse
+		if rr.Code != http.StatusNotAcceptable {
+			// exactly one json document
+			dec := json.NewDecoder(rr.Body)
+			if err := dec.Decode(&resp); err != nil || dec.More() {
+				t.Fatalf("got body %q, want one GraphQL response", rr.Body.String())
+			}
+		}
+		return rr, resp
+	}
+
+	// variables and operationName pick and parameterise one operation
+	body := `{"query":"query Movie($id: Int) { movie(id: $id) { title } } query Genres { genres { id } }",
+		"variables":{"id":` + itoa(id) + `},"operationName":"Movie"}`
+	rr, resp := send(http.MethodPost, "/v1/graphql", body, nil)
+	expectStatus(t, rr, http.StatusOK)
+	if got := rr.Header().Get("Content-Type"); got != "application/graphql-response+json; charset=utf-8" {
+		t.Errorf("got Content-Type %q", got)
+	}
+	if string(resp.Data["movie"]) != `{"title":"Casablanca"}` || resp.Data["genres"] != nil {
+		t.Errorf("got data %s", resp.Data)
+	}
+
+	qs := url.Values{
+		"query":     {"query ($id: Int) { movie(id: $id) { title } }"},
+		"variables": {`{"id":` + itoa(id) + `}`},
+	}
+	rr, resp = send(http.MethodGet, "/v1/graphql?"+qs.Encode(), "", nil)
+	expectStatus(t, rr, http.StatusOK)
+	if string(resp.Data["movie"]) != `{"title":"Casablanca"}` {
+		t.Errorf("got data %s for a GET", resp.Data)
+	}
+
+	mutation := `mutation ($input: GenreInput!) { createGenre(input: $input) { id } }`
+	qs = url.Values{"query": {mutation}, "variables": {`{"input":{"genre_name":"Drama"}}`}}
+	rr, _ = send(http.MethodGet, "/v1/graphql?"+qs.Encode(), "", editor)
+	expectStatus(t, rr, http.StatusMethodNotAllowed)
+	if got := rr.Header().Get("Allow"); got != http.MethodPost {
+		t.Errorf("got Allow %q, want POST", got)
+	}
+
+	// input objects arrive as variables, with JSON numbers for ints
+	body = `{"query":"mutation ($input: MovieInput!) { createMovie(input: $input) { runtime } }",
+		"variables":{"input":{"title":"Heat","release_date":"1995-12-15","runtime":170,"rating":4}}}`
+	rr, resp = send(http.MethodPost, "/v1/graphql", body, editor)
+	expectStatus(t, rr, http.StatusOK)
+	if string(resp.Data["createMovie"]) != `{"runtime":170}` {
+		t.Errorf("got %s %+v for a mutation with variables", resp.Data["createMovie"], resp.Errors)
+	}
+
+	tests := []struct {
+		name        string
+		method      string
+		path, body  string
+		header      http.Header
+		want        int
+		contentType string
+	}{
+		{"not json", http.MethodPost, "/v1/graphql", `{ list { id } }`, nil, http.StatusBadRequest, graphQLResponseType},
+		{"plain text", http.MethodPost, "/v1/graphql", `{ list { id } }`,
+			http.Header{"Content-Type": {"text/plain"}}, http.StatusUnsupportedMediaType, graphQLResponseType},
+		{"no query", http.MethodPost, "/v1/graphql", `{"variables":{}}`, nil, http.StatusBadRequest, graphQLResponseType},
+		{"bad variables", http.MethodGet, "/v1/graphql?query=%7B+list+%7B+id+%7D+%7D&variables=%5B%5D", "", nil,
+			http.StatusBadRequest, graphQLResponseType},
+		{"too large", http.MethodPost, "/v1/graphql",
+			`{"query":"{ list { id } }","extensions":{"pad":"` + strings.Repeat("x", maxGraphQLBody) + `"}}`, nil,
+			http.StatusRequestEntityTooLarge, graphQLResponseType},
+		{"syntax error", http.MethodPost, "/v1/graphql", `{"query":"{ list { id "}`, nil,
+			http.StatusBadRequest, graphQLResponseType},
+		{"invalid document", http.MethodPost, "/v1/graphql", `{"query":"{ list { nope } }"}`, nil,
+			http.StatusBadRequest, graphQLResponseType},
+		{"invalid document for json clients", http.MethodPost, "/v1/graphql", `{"query":"{ list { nope } }"}`,
+			http.Header{"Accept": {"application/json"}}, http.StatusOK, jsonType},
+		{"preferred media type", http.MethodPost, "/v1/graphql", `{"query":"{ list { id } }"}`,
+			http.Header{"Accept": {"application/json, application/graphql-response+json"}}, http.StatusOK, graphQLResponseType},
+		{"any media type", http.MethodPost, "/v1/graphql", `{"query":"{ list { id } }"}`,
+			http.Header{"Accept": {"*/*"}}, http.StatusOK, graphQLResponseType},
+		{"unacceptable", http.MethodPost, "/v1/graphql", `{"query":"{ list { id } }"}`,
+			http.Header{"Accept": {"text/html"}}, http.StatusNotAcceptable, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr, resp := send(tt.method, tt.path, tt.body, tt.header)
+			expectStatus(t, rr, tt.want)
+			if tt.contentType == "" {
+				return
+			}
+			if got := rr.Header().Get("Content-Type"); got != tt.contentType+"; charset=utf-8" {
+				t.Errorf("got Content-Type %q, want %s", got, tt.contentType)
+			}
+			if rr.Code != http.StatusOK && len(resp.Errors) == 0 {
+				t.Error("got no errors")
+			}
+		})
+	}
+}
+
+func TestGraphQLLimits(t *testing.T) {
+	ta := newTestApp(t)
+	ta.config.graphql = queryLimits{maxDepth: 3, maxComplexity: 100, maxAliases: 2}
+	ta.addMovie(t, "Casablanca", 1942, 5)
+
+	tests := []struct {
+		name      string
+		query     string
+		variables string
+		header    http.Header
+		want      int
+		limit     string
+		actual    float64
+	}{
+		{"within limits", `{ list(limit: 5) { id genres { id } } }`, "", nil, http.StatusOK, "", 0},
+		{"default page size", `{ list { genres { id } } }`, "", nil, http.StatusOK, "", 0},
+		{"introspection costs nothing", `{ list(limit: 98) { genres { id } } __schema { types { name } } }`, "", nil,
+			http.StatusOK, "", 0},
+		{"introspection too deep", `{ __schema { types { fields { type { ofType { name } } } } } }`, "", nil,
+			http.StatusBadRequest, "depth", 6},
+		{"introspection aliases", `{ a: __typename b: __typename c: __schema { queryType { name } } }`, "", nil,
+			http.StatusBadRequest, "aliases", 3},
+		{"too deep", `{ movie(id: 1) { genres { movies { id } } } }`, "", nil, http.StatusBadRequest, "depth", 4},
+		{"too deep through fragments", `{ ...deep } fragment deep on RootQuery { movie(id: 1) { genres { ... on Genre { movies { id } } } } }`,
+			"", nil, http.StatusBadRequest, "depth", 4},
+		{"too deep for json clients", `{ movie(id: 1) { genres { movies { id } } } }`, "",
+			http.Header{"Accept": {"application/json"}}, http.StatusOK, "depth", 4},
+		{"too complex", `{ list(limit: 100) { genres { id } } }`, "", nil, http.StatusBadRequest, "complexity", 102},
+		{"too complex by variable", `query ($n: Int) { list(limit: $n) { genres { id } } }`, `{"n":100}`, nil,
+			http.StatusBadRequest, "complexity", 102},
+		{"too complex by default value", `query ($n: Int = 100) { list(limit: $n) { genres { id } } }`, "", nil,
+			http.StatusBadRequest, "complexity", 102},
+		{"default value overridden", `query ($n: Int = 100) { list(limit: $n) { genres { id } } }`, `{"n":5}`, nil,
+			http.StatusOK, "", 0},
+		{"too many aliases", `{ a: genres { id } b: genres { id } c: genres { id } }`, "", nil,
+			http.StatusBadRequest, "aliases", 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := `{"query":` + strconv.Quote(tt.query)
+			if tt.variables != "" {
+				body += `,"variables":` + tt.variables
+			}
+			rr := ta.do(t, http.MethodPost, "/v1/graphql", body+"}", tt.header)
+			expectStatus(t, rr, tt.want)
+
+			var resp graphQLResponse
+			if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			if tt.limit == "" {
+				if len(resp.Errors) > 0 {
+					t.Errorf("got errors %+v", resp.Errors)
+				}
+				return
+			}
+
+			if resp.Data != nil || len(resp.Errors) != 1 {
+				t.Fatalf("got data %s and errors %+v, want one error", resp.Data, resp.Errors)
+			}
+			ext := resp.Errors[0].Extensions
+			if ext["code"] != codeLimitExceeded || ext["limit"] != tt.limit || ext["actual"] != tt.actual {
+				t.Errorf("got extensions %v, want %s limit exceeded with %v", ext, tt.limit, tt.actual)
+			}
+		})
+	}
+
+	// fragments spread twice at every level are measured once each, yet
+	// count every time they are spread: 2^30 aliases, and a complexity
+	// that saturates instead of overflowing
+	var query strings.Builder
+	query.WriteString(`{ list { ...f0 } }`)
+	for i := 0; i < 30; i++ {
+		next := "...f" + strconv.Itoa(i+1)
+		if i == 29 {
+			next = "x: genres { id }"
+		}
+		fmt.Fprintf(&query, " fragment f%d on Movie { genres { movies { %s %s } } }", i, next, next)
+	}
+	doc := parseQuery(t, query.String())
+	start := time.Now()
+	stats := measure(ta.schema, doc, doc.Definitions[0].(*ast.OperationDefinition), nil)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("took %s to measure doubly spread fragments", elapsed)
+	}
+	if stats.complexity != maxMeasure || stats.aliases != 1<<30 {
+		t.Errorf("got %+v, want complexity saturated and 2^30 aliases", stats)
+	}
+	start = time.Now()
+	rr := ta.graphQL(t, query.String(), nil)
+	expectStatus(t, rr, http.StatusBadRequest)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("took %s to reject doubly spread fragments", elapsed)
+	}
+
+	// a zero limit is not enforced
+	ta.config.graphql = queryLimits{}
+	rr = ta.graphQL(t, `{ a: genres { movies { genres { id } } } b: list(limit: 100) { id } c: genres { id } }`, nil)
+	expectStatus(t, rr, http.StatusOK)
+}
+
+// parseQuery parses a document for measure
+func parseQuery(t *testing.T, query string) *ast.Document {
+	t.Helper()
+	doc, err := parser.Parse(parser.ParseParams{Source: query})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
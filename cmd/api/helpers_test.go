@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cmd-ctrl-q/go-movies-server/models"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testApp is an application backed by in-memory models
+type testApp struct {
+	*application
+	store   *models.MemoryModel
+	handler http.Handler
+}
+
+func newTestApp(t *testing.T) *testApp {
+	t.Helper()
+
+	var cfg config
+	cfg.env = "test"
+	cfg.jwt.secret = "test-secret"
+	cfg.jwt.issuer = "test.local"
+	cfg.jwt.audience = "test.local"
+	cfg.jwt.ttl = 15 * time.Minute
+	cfg.jwt.refreshTTL = time.Hour
+	cfg.jwt.alg = "HS256"
+	cfg.password = passwordPolicy{minLength: 8, requireDigit: true}
+	cfg.login.loginPolicy = loginPolicy{
+		freeAttempts:    2,
+		baseDelay:       time.Minute,
+		lockoutAttempts: 5,
+		lockout:         15 * time.Minute,
+		window:          15 * time.Minute,
+	}
+
+	keys, err := loadSigningKeys(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := models.NewMemoryModel()
+	app := &application{
+		config: cfg,
+		logger: log.New(io.Discard, "", 0),
+		models: models.NewMemoryModels(store),
+		keys:   keys,
+		// posters come from an outside api, left out of the tests
+		lookupPoster: func(movie models.Movie) models.Movie { return movie },
+	}
+	app.logins = &loginLimiter{store: app.models.Attempts, policy: cfg.login.loginPolicy}
+	app.schema, err = app.graphQLSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testApp{application: app, store: store, handler: app.routes()}
+}
+
+// addUser stores a user. Hashes use the minimum bcrypt cost to keep tests fast
+func (ta *testApp) addUser(t *testing.T, email, password, role string) *models.User {
+	t.Helper()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := models.User{Email: email, Password: string(hash), Role: role}
+	user.ID, err = ta.store.InsertUser(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &user
+}
+
+// tokens signs in a user without going through /v1/signin
+func (ta *testApp) tokens(t *testing.T, user *models.User) *tokenPair {
+	t.Helper()
+
+	familyID, err := randomToken(16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := ta.issueTokens(user, familyID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tokens
+}
+
+// bearer returns the authorization header for a user
+func (ta *testApp) bearer(t *testing.T, user *models.User) http.Header {
+	t.Helper()
+	return http.Header{"Authorization": {"Bearer " + ta.tokens(t, user).Token}}
+}
+
+func (ta *testApp) addGenre(t *testing.T, name string) int {
+	t.Helper()
+
+	id, err := ta.store.InsertGenre(models.Genre{GenreName: name})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func (ta *testApp) addMovie(t *testing.T, title string, year, rating int, genreIDs ...int) int {
+	t.Helper()
+
+	movie := models.Movie{
+		Title:       title,
+		Description: "About " + title,
+		Year:        year,
+		ReleaseDate: time.Date(year, 1, 1, 0, 0, 0, 0, time.UTC),
+		Runtime:     100,
+		Rating:      rating,
+		MPAARating:  "PG",
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+		Poster:      "/poster.jpg",
+	}
+	id, err := ta.store.InsertMovie(movie, genreIDs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+// do sends a request through the routes and returns the recorded response
+func (ta *testApp) do(t *testing.T, method, path, body string, header http.Header) *httptest.ResponseRecorder {
+	t.Helper()
+
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	r.RemoteAddr = "192.0.2.1:1234"
+	if body != "" {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range header {
+		r.Header[k] = v
+	}
+
+	rr := httptest.NewRecorder()
+	ta.handler.ServeHTTP(rr, r)
+	return rr
+}
+
+// decode unmarshals a response body, failing the test on bad json
+func decode(t *testing.T, rr *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+
+	err := json.Unmarshal(rr.Body.Bytes(), v)
+	if err != nil {
+		t.Fatalf("decoding %q: %v", rr.Body.String(), err)
+	}
+}
+
+// expectStatus fails the test when the response has another status
+func expectStatus(t *testing.T, rr *httptest.ResponseRecorder, want int) {
+	t.Helper()
+
+	if rr.Code != want {
+		t.Fatalf("got status %d, want %d: %s", rr.Code, want, rr.Body.String())
+	}
+}
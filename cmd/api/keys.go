@@ -0,0 +1,253 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/pascaldekloe/jwt"
+)
+
+// signingKeys signs tokens with the configured key and checks tokens against
+// every key still accepted, so old keys can stay valid during a rotation
+type signingKeys struct {
+	alg     string
+	kid     string
+	secret  []byte
+	rsaKey  *rsa.PrivateKey
+	edKey   ed25519.PrivateKey
+	checker jwt.KeyRegister
+	jwks    []jwk
+}
+
+// jwk is a public key as published at /.well-known/jwks.json
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// loadSigningKeys reads the signing key and any extra verification keys
+// named in the config
+func loadSigningKeys(cfg config) (*signingKeys, error) {
+	keys := &signingKeys{alg: cfg.jwt.alg}
+
+	switch cfg.jwt.alg {
+	case jwt.HS256:
+		if cfg.jwt.secret == "" {
+			return nil, errors.New("GO_MOVIES_JWT must be set for HS256 tokens")
+		}
+		keys.secret = []byte(cfg.jwt.secret)
+		keys.checker.Secrets = append(keys.checker.Secrets, keys.secret)
+		return keys, nil
+
+	case jwt.RS256, jwt.EdDSA:
+		if cfg.jwt.keyFile == "" {
+			return nil, fmt.Errorf("a private key file is required for %s tokens", cfg.jwt.alg)
+		}
+
+	default:
+		return nil, fmt.Errorf("unsupported jwt algorithm %q", cfg.jwt.alg)
+	}
+
+	priv, err := readPEMKeys(cfg.jwt.keyFile)
+	if err != nil {
+		return nil, err
+	}
+	if len(priv) != 1 {
+		return nil, fmt.Errorf("%s: want exactly one private key, got %d", cfg.jwt.keyFile, len(priv))
+	}
+
+	switch key := priv[0].(type) {
+	case *rsa.PrivateKey:
+		if cfg.jwt.alg != jwt.RS256 {
+			return nil, fmt.Errorf("%s: RSA key given for %s tokens", cfg.jwt.keyFile, cfg.jwt.alg)
+		}
+		keys.rsaKey = key
+		keys.kid, err = keys.add(&key.PublicKey)
+	case ed25519.PrivateKey:
+		if cfg.jwt.alg != jwt.EdDSA {
+			return nil, fmt.Errorf("%s: Ed25519 key given for %s tokens", cfg.jwt.keyFile, cfg.jwt.alg)
+		}
+		keys.edKey = key
+		keys.kid, err = keys.add(key.Public())
+	default:
+		return nil, fmt.Errorf("%s: not a private key", cfg.jwt.keyFile)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// keys from before a rotation, still trusted until their tokens expire
+	for _, file := range cfg.jwt.verifyKeyFiles {
+		pub, err := readPEMKeys(file)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range pub {
+			if signer, ok := key.(crypto.Signer); ok {
+				key = signer.Public()
+			}
+			_, err = keys.add(key)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", file, err)
+			}
+		}
+	}
+
+	return keys, nil
+}
+
+// add trusts a public key and returns its key id
+func (k *signingKeys) add(key crypto.PublicKey) (string, error) {
+	var j jwk
+
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		j = jwk{
+			Kty: "RSA",
+			Alg: jwt.RS256,
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}
+		j.Kid = thumbprint(`{"e":"` + j.E + `","kty":"RSA","n":"` + j.N + `"}`)
+		k.checker.RSAs = append(k.checker.RSAs, key)
+		k.checker.RSAIDs = append(k.checker.RSAIDs, j.Kid)
+	case ed25519.PublicKey:
+		j = jwk{
+			Kty: "OKP",
+			Alg: jwt.EdDSA,
+			Crv: "Ed25519",
+			X:   base64.RawURLEncoding.EncodeToString(key),
+		}
+		j.Kid = thumbprint(`{"crv":"Ed25519","kty":"OKP","x":"` + j.X + `"}`)
+		k.checker.EdDSAs = append(k.checker.EdDSAs, key)
+		k.checker.EdDSAIDs = append(k.checker.EdDSAIDs, j.Kid)
+	default:
+		return "", fmt.Errorf("unsupported key type %T", key)
+	}
+
+	j.Use = "sig"
+	k.jwks = append(k.jwks, j)
+
+	return j.Kid, nil
+}
+
+// sign creates a token with the current key
+func (k *signingKeys) sign(claims *jwt.Claims) ([]byte, error) {
+	switch k.alg {
+	case jwt.RS256:
+		return claims.RSASign(jwt.RS256, k.rsaKey, k.header())
+	case jwt.EdDSA:
+		return claims.EdDSASign(k.edKey, k.header())
+	default:
+		return claims.HMACSign(jwt.HS256, k.secret)
+	}
+}
+
+// check verifies the signature of a token against all trusted keys
+func (k *signingKeys) check(token []byte) (*jwt.Claims, error) {
+	return k.checker.Check(token)
+}
+
+// header names the signing key so verifiers can pick it without trial
+func (k *signingKeys) header() json.RawMessage {
+	return json.RawMessage(`{"kid":"` + k.kid + `"}`)
+}
+
+// thumbprint is the RFC 7638 key id of a canonical jwk
+func thumbprint(canonical string) string {
+	sum := sha256.Sum256([]byte(canonical))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// readPEMKeys parses every key in a PEM file
+func readPEMKeys(file string) ([]interface{}, error) {
+	text, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []interface{}
+	for {
+		block, rest := pem.Decode(text)
+		if block == nil {
+			break
+		}
+		text = rest
+
+		var key interface{}
+		switch block.Type {
+		case "PUBLIC KEY":
+			key, err = x509.ParsePKIXPublicKey(block.Bytes)
+		case "PRIVATE KEY":
+			key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		case "RSA PRIVATE KEY":
+			key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		case "RSA PUBLIC KEY":
+			key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+		default:
+			return nil, fmt.Errorf("%s: unknown PEM type %q", file, block.Type)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		keys = append(keys, key)
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s: no PEM keys found", file)
+	}
+
+	return keys, nil
+}
+
+// splitList turns a comma separated flag value into its non-empty parts
+func splitList(s string) []string {
+	var list []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			list = append(list, part)
+		}
+	}
+	return list
+}
+
+func (app *application) jwksHandler(w http.ResponseWriter, r *http.Request) {
+	set := struct {
+		Keys []jwk `json:"keys"`
+	}{
+		Keys: app.keys.jwks,
+	}
+	if set.Keys == nil {
+		set.Keys = []jwk{}
+	}
+
+	b, err := json.Marshal(set)
+	if err != nil {
+		app.logger.Println(err)
+		app.errorJSON(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.WriteHeader(http.StatusOK)
+	w.Write(b)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pascaldekloe/jwt"
+)
+
+// writeKey writes a private key to a PEM file in dir
+func writeKey(t *testing.T, dir, name string, key crypto.PrivateKey) string {
+	t.Helper()
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, name)
+	err = os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestSigningKeys(t *testing.T) {
+	dir := t.TempDir()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	rsaFile := writeKey(t, dir, "rsa.pem", rsaKey)
+	edFile := writeKey(t, dir, "ed.pem", edKey)
+	otherFile := writeKey(t, dir, "other.pem", otherKey)
+
+	for _, tt := range []struct{ alg, file string }{{jwt.RS256, rsaFile}, {jwt.EdDSA, edFile}} {
+		t.Run(tt.alg, func(t *testing.T) {
+			var cfg config
+			cfg.jwt.alg = tt.alg
+			cfg.jwt.keyFile = tt.file
+			keys, err := loadSigningKeys(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			token, err := keys.sign(&jwt.Claims{Registered: jwt.Registered{Subject: "7"}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			claims, err := keys.check(token)
+			if err != nil || claims.Subject != "7" {
+				t.Fatalf("got %+v, %v checking a token of our own", claims, err)
+			}
+			if claims.KeyID != keys.kid || len(keys.jwks) != 1 || keys.jwks[0].Kid != keys.kid {
+				t.Errorf("got kid %q, want %q published in %+v", claims.KeyID, keys.kid, keys.jwks)
+			}
+
+			// tokens signed with a key that is not trusted fail
+			cfg.jwt.alg = jwt.EdDSA
+			cfg.jwt.keyFile = otherFile
+			other, _ := loadSigningKeys(cfg)
+			token, _ = other.sign(&jwt.Claims{})
+			if _, err := keys.check(token); err == nil {
+				t.Error("checked a token signed with an unknown key")
+			}
+		})
+	}
+
+	// during a rotation the old key still verifies its tokens
+	var cfg config
+	cfg.jwt.alg = jwt.EdDSA
+	cfg.jwt.keyFile = edFile
+	old, _ := loadSigningKeys(cfg)
+	token, _ := old.sign(&jwt.Claims{})
+
+	cfg.jwt.keyFile = otherFile
+	cfg.jwt.verifyKeyFiles = []string{edFile}
+	rotated, err := loadSigningKeys(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rotated.check(token); err != nil {
+		t.Errorf("got %v checking a token signed before the rotation", err)
+	}
+
+	app := testTokenApp(t)
+	app.keys = rotated
+	rr := httptest.NewRecorder()
+	app.jwksHandler(rr, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&set); err != nil {
+		t.Fatal(err)
+	}
+	if len(set.Keys) != 2 || set.Keys[0].Kid != rotated.kid || set.Keys[1].Kid != old.kid {
+		t.Errorf("got jwks %+v, want the new key and the old one", set.Keys)
+	}
+}
+
+func TestLoadSigningKeysErrors(t *testing.T) {
+	dir := t.TempDir()
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	edFile := writeKey(t, dir, "ed.pem", edKey)
+
+	tests := []struct {
+		name, alg, secret, file string
+	}{
+		{"HS256 without a secret", jwt.HS256, "", ""},
+		{"RS256 without a key", jwt.RS256, "", ""},
+		{"key for another algorithm", jwt.RS256, "", edFile},
+		{"missing key file", jwt.EdDSA, "", filepath.Join(dir, "missing.pem")},
+		{"unknown algorithm", "HS512", "secret", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var cfg config
+			cfg.jwt.alg = tt.alg
+			cfg.jwt.secret = tt.secret
+			cfg.jwt.keyFile = tt.file
+			if _, err := loadSigningKeys(cfg); err == nil {
+				t.Error("got no error")
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	"github.com/cmd-ctrl-q/go-movies-server/models"
+)
+
+var loadersKey contextKey = "loaders"
+
+// loader batches lookups by id. While a GraphQL query resolves one level
+// of the result, load only queues ids and hands back thunks; the first
+// thunk called fetches every queued id in one go, so a list of n movies
+// costs one query for their genres rather than n
+type loader struct {
+	fetch func(ids []int) (map[int]interface{}, error)
+
+	mu      sync.Mutex
+	queued  []int
+	results map[int]interface{}
+	errs    map[int]error
+}
+
+func newLoader(fetch func(ids []int) (map[int]interface{}, error)) *loader {
+	return &loader{
+		fetch:   fetch,
+		results: make(map[int]interface{}),
+		errs:    make(map[int]error),
+	}
+}
+
+// load queues id and returns a thunk that resolves to its result
+func (l *loader) load(id int) func() (interface{}, error) {
+	l.mu.Lock()
+	if _, ok := l.results[id]; !ok {
+		l.queued = append(l.queued, id)
+	}
+	l.mu.Unlock()
+
+	return func() (interface{}, error) {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		if len(l.queued) > 0 {
+			ids := l.queued
+			l.queued = nil
+
+			results, err := l.fetch(ids)
+			for _, id := range ids {
+				l.results[id] = results[id]
+				l.errs[id] = err
+			}
+		}
+
+		return l.results[id], l.errs[id]
+	}
+}
+
+// loaders are the batch loaders of one GraphQL request. They cache what
+// they fetch, so they must not outlive it
+type loaders struct {
+	genresByMovie *loader
+	moviesByGenre *loader
+}
+
+func newLoaders(m models.Models) *loaders {
+	return &loaders{
+		genresByMovie: newLoader(func(ids []int) (map[int]interface{}, error) {
+			genres, err := m.Genres.MovieGenres(ids)
+			results := make(map[int]interface{}, len(genres))
+			for id, g := range genres {
+				results[id] = g
+			}
+			return results, err
+		}),
+		moviesByGenre: newLoader(func(ids []int) (map[int]interface{}, error) {
+			movies, err := m.Movies.GenreMovies(ids)
+			results := make(map[int]interface{}, len(movies))
+			for id, m := range movies {
+				results[id] = m
+			}
+			return results, err
+		}),
+	}
+}
+
+// withLoaders returns a copy of ctx carrying new loaders backed by m
+func withLoaders(ctx context.Context, m models.Models) context.Context {
+	return context.WithValue(ctx, loadersKey, newLoaders(m.WithContext(ctx)))
+}
+
+func loadersFromContext(ctx context.Context) *loaders {
+	l, _ := ctx.Value(loadersKey).(*loaders)
+	return l
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cmd-ctrl-q/go-movies-server/models"
+)
+
+// loginLimiter slows down repeated failed signins per ip address and per
+// account, doubling the wait after every failure and locking out after many.
+// The counts live in a models.MemoryAttempts, or in postgres so several
+// instances can share them
+type loginLimiter struct {
+	store  models.LoginAttemptStore
+	policy loginPolicy
+}
+
+// loginPolicy is the throttling configuration
+type loginPolicy struct {
+	freeAttempts    int           // failures allowed before any delay
+	baseDelay       time.Duration // delay after the first throttled failure
+	lockoutAttempts int           // failures that trigger a full lockout
+	lockout         time.Duration // lockout length, also the longest delay
+	window          time.Duration // failures older than this are forgotten
+}
+
+// blockedUntil returns until when a key is blocked after its nth failure
+func (p loginPolicy) blockedUntil(failures int, now time.Time) time.Time {
+	if failures <= p.freeAttempts {
+		return time.Time{}
+	}
+	if failures >= p.lockoutAttempts {
+		return now.Add(p.lockout)
+	}
+
+	delay := float64(p.baseDelay) * math.Pow(2, float64(failures-p.freeAttempts-1))
+	if delay > float64(p.lockout) {
+		delay = float64(p.lockout)
+	}
+	return now.Add(time.Duration(delay))
+}
+
+// pruneInterval is how often stale failed signin counts are deleted
+const pruneInterval = time.Hour
+
+// reserve counts a signin as failed against every key before its password
+// is checked, so concurrent guesses cannot all get past a block. It
+// returns how long the caller must wait when any key is blocked, or zero.
+// A key that is not blocked stays counted even when another one is
+func (l *loginLimiter) reserve(keys ...string) (time.Duration, error) {
+	since := time.Now().Add(-l.policy.window)
+	block := func(failures int) time.Time {
+		return l.policy.blockedUntil(failures, time.Now())
+	}
+
+	var wait time.Duration
+	for _, key := range keys {
+		attempt, ok, err := l.store.ReserveLoginAttempt(key, since, block)
+		if err != nil {
+			return 0, err
+		}
+		if d := time.Until(attempt.BlockedUntil); !ok && d > wait {
+			wait = d
+		}
+	}
+	return wait, nil
+}
+
+// succeed forgets the failures of every key, including the one reserved
+// for the signin that succeeded
+func (l *loginLimiter) succeed(keys ...string) error {
+	for _, key := range keys {
+		err := l.store.ClearLoginAttempts(key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pruneLogins deletes failed signin counts that are past the window and
+// not blocked, every interval until the process exits. Run it in its own
+// goroutine
+func (app *application) pruneLogins(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		n, err := app.logins.store.PruneLoginAttempts(time.Now().Add(-app.logins.policy.window))
+		if err != nil {
+			app.logger.Println("error pruning login attempts:", err)
+		} else if n > 0 {
+			app.logger.Printf("pruned %d login attempts", n)
+		}
+		<-ticker.C
+	}
+}
+
+// loginKeys returns the ip and account keys a signin is tracked under
+func loginKeys(r *http.Request, email string) (ipKey, accountKey string) {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	return "ip:" + ip, "email:" + strings.ToLower(strings.TrimSpace(email))
+}
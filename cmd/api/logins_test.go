@@ -0,0 +1,94 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cmd-ctrl-q/go-movies-server/models"
+)
+
+var testLoginPolicy = loginPolicy{
+	freeAttempts:    3,
+	baseDelay:       time.Second,
+	lockoutAttempts: 8,
+	lockout:         10 * time.Second,
+	window:          time.Minute,
+}
+
+func TestBlockedUntil(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		failures int
+		wait     time.Duration
+	}{
+		{1, 0},
+		{3, 0},
+		{4, time.Second},
+		{5, 2 * time.Second},
+		{6, 4 * time.Second},
+		{7, 8 * time.Second},
+		{8, 10 * time.Second},
+		{20, 10 * time.Second},
+	}
+
+	for _, tt := range tests {
+		until := testLoginPolicy.blockedUntil(tt.failures, now)
+		var wait time.Duration
+		if !until.IsZero() {
+			wait = until.Sub(now)
+		}
+		if wait != tt.wait {
+			t.Errorf("%d failures: got wait %v, want %v", tt.failures, wait, tt.wait)
+		}
+	}
+}
+
+func TestLoginLimiter(t *testing.T) {
+	l := &loginLimiter{store: models.NewMemoryAttempts(testLoginPolicy.window), policy: testLoginPolicy}
+
+	// concurrent signins are counted before their passwords are checked, so
+	// only the free attempts and the first throttled one get through
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	passed := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			wait, err := l.reserve("ip:10.0.0.1")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if wait == 0 {
+				mu.Lock()
+				passed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if want := testLoginPolicy.freeAttempts + 1; passed != want {
+		t.Errorf("%d concurrent signins passed, want %d", passed, want)
+	}
+
+	// a successful signin clears every key it was counted under
+	for i := 0; i < testLoginPolicy.freeAttempts; i++ {
+		l.reserve("ip:10.0.0.2", "email:a@example.com")
+	}
+	err := l.succeed("ip:10.0.0.2", "email:a@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"ip:10.0.0.2", "email:a@example.com"} {
+		for i := 0; i < testLoginPolicy.freeAttempts; i++ {
+			wait, _ := l.reserve(key)
+			if wait != 0 {
+				t.Fatalf("%s: signin %d throttled after a success", key, i+1)
+			}
+		}
+	}
+}
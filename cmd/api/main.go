@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"github.com/cmd-ctrl-q/go-movies-server/models"
+	"github.com/graphql-go/graphql"
 	_ "github.com/lib/pq"
 )
 
@@ -20,11 +21,26 @@ type config struct {
 	port int
 	env  string
 	db   struct {
-		dsn string // db connection string
+		dsn     string // db connection string
+		migrate bool   // apply pending migrations at startup
 	}
 	jwt struct {
-		secret string
+		secret         string
+		issuer         string
+		audience       string
+		ttl            time.Duration // access token lifetime
+		refreshTTL     time.Duration
+		alg            string // HS256, RS256 or EdDSA
+		keyFile        string // PEM private key for RS256 and EdDSA
+		verifyKeyFiles []string
 	}
+	password  passwordPolicy
+	trashDays int // days before trashed movies are purged, 0 keeps them
+	login     struct {
+		store string // memory or postgres
+		loginPolicy
+	}
+	graphql queryLimits
 }
 
 type AppStatus struct {
@@ -38,6 +54,11 @@ type application struct {
 	config config
 	logger *log.Logger
 	models models.Models
+	keys   *signingKeys
+	logins *loginLimiter
+	// lookupPoster fills in the poster of a movie that has none
+	lookupPoster func(models.Movie) models.Movie
+	schema       graphql.Schema
 }
 
 func main() {
@@ -47,9 +68,33 @@ func main() {
 	flag.IntVar(&cfg.port, "port", 4000, "Server port to listen on")
 	flag.StringVar(&cfg.env, "env", "development", "Application environment (development|production)")
 	flag.StringVar(&cfg.db.dsn, "dsn", "postgres://plutonium@localhost/go_movies?sslmode=disable", "Postgres connection string")
+	flag.BoolVar(&cfg.db.migrate, "migrate", false, "Apply pending schema migrations before starting")
+	flag.IntVar(&cfg.trashDays, "trash-days", 30, "Days a deleted movie stays in the trash before it is purged (0 keeps it forever)")
+	flag.IntVar(&cfg.password.minLength, "password-min-length", 8, "Minimum password length")
+	flag.BoolVar(&cfg.password.requireUpper, "password-require-upper", false, "Require an uppercase letter in passwords")
+	flag.BoolVar(&cfg.password.requireLower, "password-require-lower", false, "Require a lowercase letter in passwords")
+	flag.BoolVar(&cfg.password.requireDigit, "password-require-digit", true, "Require a digit in passwords")
+	flag.BoolVar(&cfg.password.requireSymbol, "password-require-symbol", false, "Require a symbol in passwords")
+	flag.StringVar(&cfg.jwt.issuer, "jwt-issuer", "mydomain.com", "Issuer of signed tokens")
+	flag.StringVar(&cfg.jwt.audience, "jwt-audience", "mydomain.com", "Audience of signed tokens")
+	flag.DurationVar(&cfg.jwt.ttl, "jwt-ttl", 15*time.Minute, "Lifetime of access tokens")
+	flag.DurationVar(&cfg.jwt.refreshTTL, "jwt-refresh-ttl", 30*24*time.Hour, "Lifetime of refresh tokens")
+	flag.StringVar(&cfg.jwt.alg, "jwt-alg", "HS256", "Token signing algorithm (HS256|RS256|EdDSA)")
+	flag.StringVar(&cfg.jwt.keyFile, "jwt-key", "", "PEM private key file for RS256 or EdDSA signing")
+	flag.StringVar(&cfg.login.store, "login-store", "memory", "Where failed signins are tracked (memory|postgres)")
+	flag.IntVar(&cfg.login.freeAttempts, "login-free-attempts", 5, "Failed signins allowed before throttling")
+	flag.DurationVar(&cfg.login.baseDelay, "login-delay", time.Second, "Wait after the first throttled signin, doubled per failure")
+	flag.IntVar(&cfg.login.lockoutAttempts, "login-lockout-attempts", 10, "Failed signins that lock out an address or account")
+	flag.DurationVar(&cfg.login.lockout, "login-lockout", 15*time.Minute, "Length of a signin lockout")
+	flag.DurationVar(&cfg.login.window, "login-window", 15*time.Minute, "How long failed signins are remembered")
+	flag.IntVar(&cfg.graphql.maxDepth, "graphql-max-depth", 8, "Deepest GraphQL query allowed (0 for no limit)")
+	flag.IntVar(&cfg.graphql.maxComplexity, "graphql-max-complexity", 1000, "Most complex GraphQL query allowed, by field costs (0 for no limit)")
+	flag.IntVar(&cfg.graphql.maxAliases, "graphql-max-aliases", 15, "Most aliased fields allowed in a GraphQL query (0 for no limit)")
+	verifyKeys := flag.String("jwt-verify-keys", "", "Comma separated PEM public key files still accepted during a key rotation")
 	flag.Parse()
 
 	cfg.jwt.secret = os.Getenv("GO_MOVIES_JWT")
+	cfg.jwt.verifyKeyFiles = splitList(*verifyKeys)
 
 	// log.Ldate|log.Ltime add date and time to logger
 	logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
@@ -60,10 +105,64 @@ func main() {
 	}
 	defer db.Close()
 
+	// go run ./cmd/api [flags] migrate up|down [n]|status
+	// go run ./cmd/api [flags] seed fixtures/movies.json
+	switch flag.Arg(0) {
+	case "":
+	case "migrate":
+		err = runMigrate(db, logger, flag.Args()[1:])
+		if err != nil {
+			logger.Fatalln(err)
+		}
+		return
+	case "seed":
+		err = runSeed(db, logger, flag.Args()[1:])
+		if err != nil {
+			logger.Fatalln(err)
+		}
+		return
+	default:
+		logger.Fatalln("unknown command", flag.Arg(0))
+	}
+
+	if cfg.db.migrate {
+		err = runMigrate(db, logger, []string{"up"})
+		if err != nil {
+			logger.Fatalln(err)
+		}
+	}
+
+	keys, err := loadSigningKeys(cfg)
+	if err != nil {
+		logger.Fatalln(err)
+	}
+
 	app := &application{
-		config: cfg,
-		logger: logger,
-		models: models.NewModels(db),
+		config:       cfg,
+		logger:       logger,
+		models:       models.NewModels(db),
+		keys:         keys,
+		lookupPoster: getPoster,
+	}
+
+	app.schema, err = app.graphQLSchema()
+	if err != nil {
+		logger.Fatalln(err)
+	}
+
+	switch cfg.login.store {
+	case "memory":
+		app.logins = &loginLimiter{store: models.NewMemoryAttempts(cfg.login.window), policy: cfg.login.loginPolicy}
+	case "postgres":
+		app.logins = &loginLimiter{store: app.models.Attempts, policy: cfg.login.loginPolicy}
+	default:
+		logger.Fatalln("unknown login store", cfg.login.store)
+	}
+
+	go app.pruneLogins(pruneInterval)
+
+	if cfg.trashDays > 0 {
+		go app.purgeTrash(time.Duration(cfg.trashDays)*24*time.Hour, purgeInterval)
 	}
 
 	srv := &http.Server{
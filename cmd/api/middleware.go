@@ -1,19 +1,56 @@
 package main
 
 import (
+	"context"
+	"database/sql"
 	"errors"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
 
-	"github.com/pascaldekloe/jwt"
+	"github.com/cmd-ctrl-q/go-movies-server/models"
 )
 
+type contextKey string
+
+var principalKey contextKey = "principal"
+
+// principal is the caller authenticated by checkToken, either a signed in
+// user or an api key acting for the user who created it
+type principal struct {
+	UserID    int
+	Role      string
+	SessionID string
+	APIKeyID  int
+	Scopes    []string
+}
+
+// hasRole reports whether the caller may act with the given role
+func (p *principal) hasRole(role string) bool {
+	if p.APIKeyID == 0 {
+		return models.RoleAtLeast(p.Role, role)
+	}
+
+	for _, scope := range p.Scopes {
+		if models.RoleAtLeast(scope, role) {
+			return true
+		}
+	}
+	return false
+}
+
+// principalFromContext returns the caller authenticated by checkToken
+func principalFromContext(ctx context.Context) (*principal, bool) {
+	p, ok := ctx.Value(principalKey).(*principal)
+	return p, ok
+}
+
 func (app *application) enableCORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*") // allow all requests
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type,Authorization")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type,Authorization,X-API-Key,If-Match")
+		w.Header().Set("Access-Control-Expose-Headers", "ETag")
 		next.ServeHTTP(w, r)
 	})
 }
@@ -21,61 +58,151 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 func (app *application) checkToken(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Add("Vary", "Authorization")
+		w.Header().Add("Vary", "X-API-Key")
 
-		// get token; authorization value from header
-		authHeader := r.Header.Get("Authorization")
-
-		// if authHeader == "" {
-		// 	// could set an anonymous user
-		// }
-
-		// split the header by spaces
-		headerParts := strings.Split(authHeader, " ")
-		if len(headerParts) != 2 {
-			app.errorJSON(w, http.StatusBadRequest, errors.New("invalid auth header"))
+		p, status, err := app.authenticate(r)
+		if err != nil {
+			app.errorJSON(w, status, err)
 			return
 		}
 
-		if headerParts[0] != "Bearer" {
-			app.errorJSON(w, http.StatusUnauthorized, errors.New("unauthorized - no bearer"))
-			return
-		}
+		ctx := context.WithValue(r.Context(), principalKey, p)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
 
-		// get token
-		token := headerParts[1]
+// authenticate returns the caller named by the X-API-Key or Authorization
+// header, or the status and error to reject the request with
+func (app *application) authenticate(r *http.Request) (*principal, int, error) {
+	// machine clients send an api key instead of a token
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return app.checkAPIKey(key)
+	}
 
-		// do hmac check
-		claims, err := jwt.HMACCheck([]byte(token), []byte(app.config.jwt.secret))
-		if err != nil {
-			app.errorJSON(w, http.StatusForbidden, errors.New("unauthorized - failed hmac check"))
-			return
-		}
+	// get token; authorization value from header
+	authHeader := r.Header.Get("Authorization")
 
-		// is token still valid at this time
-		if !claims.Valid(time.Now()) {
-			app.errorJSON(w, http.StatusForbidden, errors.New("unauthorized - token expired"))
-			return
-		}
+	// if authHeader == "" {
+	// 	// could set an anonymous user
+	// }
 
-		// check if audience is acceptable
-		if !claims.AcceptAudience("mydomain.com") {
-			app.errorJSON(w, http.StatusForbidden, errors.New("unauthorized - invalid audience"))
-			return
-		}
+	// split the header by spaces
+	headerParts := strings.Split(authHeader, " ")
+	if len(headerParts) != 2 {
+		return nil, http.StatusBadRequest, errors.New("invalid auth header")
+	}
 
-		// check issuer is your domain
-		if claims.Issuer != "mydomain.com" {
-			app.errorJSON(w, http.StatusForbidden, errors.New("unauthorized - invalid issuer"))
-			return
-		}
+	if headerParts[0] != "Bearer" {
+		return nil, http.StatusUnauthorized, errors.New("unauthorized - no bearer")
+	}
 
-		// get user id from token
-		_, err = strconv.ParseInt(claims.Subject, 10, 64) // 64 bit
-		if err != nil {
-			app.errorJSON(w, http.StatusForbidden, errors.New("unauthorized"))
-			return
+	// get token
+	token := headerParts[1]
+
+	// check signature against the trusted keys
+	claims, err := app.keys.check([]byte(token))
+	if err != nil {
+		return nil, http.StatusForbidden, errors.New("unauthorized - failed signature check")
+	}
+
+	// is token still valid at this time
+	if !claims.Valid(time.Now()) {
+		return nil, http.StatusForbidden, errors.New("unauthorized - token expired")
+	}
+
+	// check if audience is acceptable
+	if !claims.AcceptAudience(app.config.jwt.audience) {
+		return nil, http.StatusForbidden, errors.New("unauthorized - invalid audience")
+	}
+
+	// check issuer is your domain
+	if claims.Issuer != app.config.jwt.issuer {
+		return nil, http.StatusForbidden, errors.New("unauthorized - invalid issuer")
+	}
+
+	// get user id from token
+	userID, err := strconv.ParseInt(claims.Subject, 10, 64) // 64 bit
+	if err != nil {
+		return nil, http.StatusForbidden, errors.New("unauthorized")
+	}
+
+	// get role from token
+	role, _ := claims.String("role")
+	if !models.ValidRole(role) {
+		return nil, http.StatusForbidden, errors.New("unauthorized - invalid role")
+	}
+
+	// check the session has not been signed out
+	sid, _ := claims.String("sid")
+	revoked, err := app.models.Tokens.SessionRevoked(sid)
+	if err != nil {
+		app.logger.Println("error checking session")
+		return nil, http.StatusInternalServerError, err
+	}
+	if revoked {
+		return nil, http.StatusUnauthorized, errors.New("unauthorized - session revoked")
+	}
+
+	p := &principal{
+		UserID:    int(userID),
+		Role:      role,
+		SessionID: sid,
+	}
+
+	return p, http.StatusOK, nil
+}
+
+// checkAPIKey returns the principal for an api key, or the status and
+// error to reject the request with
+func (app *application) checkAPIKey(key string) (*principal, int, error) {
+	apiKey, err := app.models.APIKeys.GetAPIKeyByHash(hashToken(key))
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			app.logger.Println("error getting api key from db")
+			return nil, http.StatusInternalServerError, err
 		}
+		return nil, http.StatusUnauthorized, errors.New("unauthorized - invalid api key")
+	}
 
-		next.ServeHTTP(w, r)
-	})
+	if apiKey.RevokedAt != nil {
+		return nil, http.StatusUnauthorized, errors.New("unauthorized - api key revoked")
+	}
+
+	if time.Now().After(apiKey.ExpiresAt) {
+		return nil, http.StatusUnauthorized, errors.New("unauthorized - api key expired")
+	}
+
+	err = app.models.APIKeys.TouchAPIKey(apiKey.ID)
+	if err != nil {
+		app.logger.Println("error updating api key last use:", err)
+	}
+
+	p := &principal{
+		UserID:   apiKey.CreatedBy,
+		APIKeyID: apiKey.ID,
+		Scopes:   apiKey.Scopes,
+	}
+
+	return p, http.StatusOK, nil
+}
+
+// requireRole only lets through callers holding at least the given role.
+// It must be chained after checkToken
+func (app *application) requireRole(role string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p, ok := principalFromContext(r.Context())
+			if !ok {
+				app.errorJSON(w, http.StatusUnauthorized, errors.New("unauthorized"))
+				return
+			}
+
+			if !p.hasRole(role) {
+				app.errorJSON(w, http.StatusForbidden, errors.New("forbidden - requires "+role+" role"))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
 }
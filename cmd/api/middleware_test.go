@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cmd-ctrl-q/go-movies-server/models"
+	"github.com/pascaldekloe/jwt"
+)
+
+const testSecret = "test secret"
+
+// testTokenApp returns an application checking tokens signed with testSecret
+func testTokenApp(t *testing.T) *application {
+	t.Helper()
+
+	app := &application{logger: log.New(io.Discard, "", 0)}
+	app.config.jwt.alg = jwt.HS256
+	app.config.jwt.secret = testSecret
+	app.config.jwt.issuer = "mydomain.com"
+	app.config.jwt.audience = "mydomain.com"
+
+	keys, err := loadSigningKeys(app.config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.keys = keys
+	return app
+}
+
+// signTestToken signs the claims Signin would give a user, changed by edit
+func signTestToken(t *testing.T, secret string, edit func(*jwt.Claims)) string {
+	t.Helper()
+
+	now := time.Now()
+	var claims jwt.Claims
+	claims.Subject = "7"
+	claims.Issued = jwt.NewNumericTime(now)
+	claims.NotBefore = jwt.NewNumericTime(now)
+	claims.Expires = jwt.NewNumericTime(now.Add(time.Hour))
+	claims.Issuer = "mydomain.com"
+	claims.Audiences = []string{"mydomain.com"}
+	claims.Set = map[string]interface{}{"role": models.RoleEditor}
+	if edit != nil {
+		edit(&claims)
+	}
+
+	token, err := claims.HMACSign(jwt.HS256, []byte(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(token)
+}
+
+// tokens that pass these checks are looked up by session, which
+// TestCheckTokenRoute covers
+func TestCheckToken(t *testing.T) {
+	app := testTokenApp(t)
+	handler := app.checkToken(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		name   string
+		header string
+		want   int
+	}{
+		{"no header", "", http.StatusBadRequest},
+		{"not bearer", "Basic " + signTestToken(t, testSecret, nil), http.StatusUnauthorized},
+		{"other secret", "Bearer " + signTestToken(t, "other secret", nil), http.StatusForbidden},
+		{"expired", "Bearer " + signTestToken(t, testSecret, func(c *jwt.Claims) {
+			c.Expires = jwt.NewNumericTime(time.Now().Add(-time.Minute))
+		}), http.StatusForbidden},
+		{"other issuer", "Bearer " + signTestToken(t, testSecret, func(c *jwt.Claims) { c.Issuer = "example.com" }), http.StatusForbidden},
+		{"unknown role", "Bearer " + signTestToken(t, testSecret, func(c *jwt.Claims) { c.Set["role"] = "root" }), http.StatusForbidden},
+		{"no role", "Bearer " + signTestToken(t, testSecret, func(c *jwt.Claims) { c.Set = nil }), http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, r)
+
+			if rr.Code != tt.want {
+				t.Errorf("got status %d, want %d: %s", rr.Code, tt.want, rr.Body)
+			}
+		})
+	}
+}
+
+func TestRequireRole(t *testing.T) {
+	app := testTokenApp(t)
+	handler := app.requireRole(models.RoleEditor)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		name string
+		p    *principal
+		want int
+	}{
+		{"not signed in", nil, http.StatusUnauthorized},
+		{"viewer", &principal{UserID: 1, Role: models.RoleViewer}, http.StatusForbidden},
+		{"editor", &principal{UserID: 1, Role: models.RoleEditor}, http.StatusOK},
+		{"admin", &principal{UserID: 1, Role: models.RoleAdmin}, http.StatusOK},
+		{"unknown role", &principal{UserID: 1, Role: "root"}, http.StatusForbidden},
+		// api keys act with their scopes, not the role of their creator
+		{"key scoped to viewer", &principal{UserID: 1, Role: models.RoleAdmin, APIKeyID: 2, Scopes: []string{models.RoleViewer}}, http.StatusForbidden},
+		{"key scoped to editor", &principal{UserID: 1, APIKeyID: 2, Scopes: []string{models.RoleViewer, models.RoleEditor}}, http.StatusOK},
+		{"key without scopes", &principal{UserID: 1, APIKeyID: 2}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.p != nil {
+				r = r.WithContext(context.WithValue(r.Context(), principalKey, tt.p))
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, r)
+
+			if rr.Code != tt.want {
+				t.Errorf("got status %d, want %d", rr.Code, tt.want)
+			}
+		})
+	}
+}
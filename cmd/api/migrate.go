@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/cmd-ctrl-q/go-movies-server/migrations"
+)
+
+// runMigrate handles the migrate subcommand:
+//
+//	migrate up         apply every pending migration
+//	migrate down [n]   roll back the latest n migrations, 1 by default
+//	migrate status     list migrations and when they were applied
+func runMigrate(db *sql.DB, logger *log.Logger, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: migrate up|down [n]|status")
+	}
+
+	ctx := context.Background()
+
+	switch args[0] {
+	case "up":
+		applied, err := migrations.Up(ctx, db)
+		for _, m := range applied {
+			logger.Printf("applied %04d_%s", m.Version, m.Name)
+		}
+		if err == nil && len(applied) == 0 {
+			logger.Println("schema is up to date")
+		}
+		return err
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			var err error
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return fmt.Errorf("invalid number of steps %q", args[1])
+			}
+		}
+
+		reverted, err := migrations.Down(ctx, db, steps)
+		for _, m := range reverted {
+			logger.Printf("rolled back %04d_%s", m.Version, m.Name)
+		}
+		return err
+
+	case "status":
+		all, err := migrations.Status(ctx, db)
+		if err != nil {
+			return err
+		}
+		for _, m := range all {
+			applied := "pending"
+			if m.AppliedAt != nil {
+				applied = m.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			logger.Printf("%04d_%-24s %s", m.Version, m.Name, applied)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("unknown migrate command %q", args[0])
+}
@@ -1,14 +1,18 @@
 package main
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/cmd-ctrl-q/go-movies-server/models"
@@ -32,13 +36,18 @@ func (app *application) getOneMovie(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	movie, err := app.models.DB.Get(id)
+	movie, err := app.models.Movies.Get(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, http.StatusNotFound, errors.New("movie not found"))
+		return
+	}
 	if err != nil {
 		app.logger.Println("error getting a movie from db")
 		app.errorJSON(w, http.StatusInternalServerError, err)
 		return
 	}
 
+	w.Header().Set("ETag", etag(movie.Version))
 	err = app.writeJSON(w, http.StatusOK, movie, "movie")
 	if err != nil {
 		app.logger.Println(errors.New("error marshaling data"))
@@ -47,15 +56,62 @@ func (app *application) getOneMovie(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
 func (app *application) getAllMovies(w http.ResponseWriter, r *http.Request) {
-	movies, err := app.models.DB.All()
+	filter, err := movieFilter(r.URL.Query())
+	if err != nil {
+		app.errorJSON(w, http.StatusBadRequest, err)
+		return
+	}
+
+	movies, meta, err := app.models.Movies.List(filter)
 	if err != nil {
+		if errors.Is(err, models.ErrInvalidCursor) {
+			app.errorJSON(w, http.StatusBadRequest, err)
+			return
+		}
 		app.logger.Println("error getting movies from db")
 		app.errorJSON(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, movies, "movies")
+	err = app.writeEnvelope(w, http.StatusOK, envelope{"movies": movies, "metadata": meta})
+	if err != nil {
+		app.logger.Println("error marshalling data")
+		app.errorJSON(w, http.StatusInternalServerError, err)
+		return
+	}
+}
+
+func (app *application) searchMovies(w http.ResponseWriter, r *http.Request) {
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if q == "" {
+		app.errorJSON(w, http.StatusBadRequest, errors.New("q is required"))
+		return
+	}
+
+	limit := defaultPageSize
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxPageSize {
+			app.errorJSON(w, http.StatusBadRequest, fmt.Errorf("limit must be between 1 and %d", maxPageSize))
+			return
+		}
+		limit = n
+	}
+
+	results, err := app.models.Movies.Search(q, limit)
+	if err != nil {
+		app.logger.Println("error searching movies in db")
+		app.errorJSON(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, results, "results")
 	if err != nil {
 		app.logger.Println("error marshalling data")
 		app.errorJSON(w, http.StatusInternalServerError, err)
@@ -63,6 +119,25 @@ func (app *application) getAllMovies(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// trashMovie moves a movie to the trash for the caller authenticated in ctx
+func (app *application) trashMovie(ctx context.Context, id int) error {
+	return app.models.WithTx(ctx, func(tx models.Models) error {
+		before, err := tx.Movies.Get(id)
+		if err != nil {
+			return err
+		}
+		err = tx.Movies.DeleteMovie(id)
+		if err != nil {
+			return err
+		}
+		after, err := tx.Movies.GetTrashed(id)
+		if err != nil {
+			return err
+		}
+		return app.audit(ctx, tx, "movie", "delete", id, auditMovie(before), auditMovie(after))
+	})
+}
+
 func (app *application) deleteMovie(w http.ResponseWriter, r *http.Request) {
 	// get movie id
 	params := httprouter.ParamsFromContext(r.Context())
@@ -73,8 +148,11 @@ func (app *application) deleteMovie(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// delete movie from db
-	err = app.models.DB.DeleteMovie(id)
+	err = app.trashMovie(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, http.StatusNotFound, errors.New("movie not found"))
+		return
+	}
 	if err != nil {
 		app.logger.Println("error deleting a movie")
 		app.errorJSON(w, http.StatusInternalServerError, err)
@@ -94,14 +172,109 @@ func (app *application) deleteMovie(w http.ResponseWriter, r *http.Request) {
 }
 
 type MoviePayload struct {
-	ID          string `json:"id"`
-	Title       string `json:"title"`
-	Description string `json:"description"`
-	Year        string `json:"year"`
-	ReleaseDate string `json:"release_date"`
-	Runtime     string `json:"runtime"`
-	Rating      string `json:"rating"`
-	MPAARating  string `json:"mpaa_rating"`
+	ID          string      `json:"id"`
+	Title       string      `json:"title"`
+	Description string      `json:"description"`
+	Year        string      `json:"year"`
+	ReleaseDate string      `json:"release_date"`
+	Runtime     string      `json:"runtime"`
+	Rating      string      `json:"rating"`
+	MPAARating  string      `json:"mpaa_rating"`
+	GenreIDs    []int       `json:"genre_ids"` // replaces the movie's genres when present
+	Version     json.Number `json:"version"`   // the version being edited, when If-Match is not sent
+}
+
+// errMissingVersion is returned by editVersion when an update names no version
+var errMissingVersion = errors.New("send the version being edited in an If-Match header or the version field")
+
+// etag returns the entity tag of a movie version
+func etag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// editVersion returns the movie version an update is based on, taken from
+// the If-Match header or the version in the payload, and whether it came
+// from the header. If-Match: * matches the current version
+func editVersion(r *http.Request, version json.Number, current int) (int, bool, error) {
+	var header, body int
+
+	if v := strings.TrimSpace(r.Header.Get("If-Match")); v != "" {
+		if v == "*" {
+			header = current
+		} else {
+			n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(v, `"`), `"`))
+			if err != nil || !strings.HasPrefix(v, `"`) || !strings.HasSuffix(v, `"`) {
+				return 0, false, fmt.Errorf("If-Match must be a single strong entity tag, got %s", v)
+			}
+			header = n
+		}
+	}
+
+	if version != "" {
+		n, err := strconv.Atoi(version.String())
+		if err != nil {
+			return 0, false, errors.New("version must be an integer")
+		}
+		body = n
+	}
+
+	switch {
+	case header == 0 && body == 0:
+		return 0, false, errMissingVersion
+	case header != 0 && body != 0 && header != body:
+		return 0, false, errors.New("If-Match and version disagree")
+	case header != 0:
+		return header, true, nil
+	}
+	return body, false, nil
+}
+
+// saveMovie inserts a movie when its id is 0 and updates it otherwise,
+// writing its audit entry and revision in the same transaction. before is
+// the audit record of the movie being updated. A movie without a poster
+// gets one looked up by title
+func (app *application) saveMovie(ctx context.Context, movie models.Movie, genreIDs []int, before interface{}) (*models.Movie, error) {
+	if movie.Poster == "" {
+		movie = app.lookupPoster(movie)
+	}
+
+	var saved *models.Movie
+	err := app.models.WithTx(ctx, func(tx models.Models) error {
+		var err error
+		action := "update"
+
+		// check if movie should be inserted or updated into db
+		if movie.ID == 0 {
+			action = "create"
+			movie.ID, err = tx.Movies.InsertMovie(movie, genreIDs)
+		} else {
+			err = baseline(tx, movie.ID)
+			if err != nil {
+				return err
+			}
+			err = tx.Movies.UpdateMovie(movie, genreIDs)
+		}
+		if err != nil {
+			return err
+		}
+
+		saved, err = tx.Movies.Get(movie.ID)
+		if err != nil {
+			return err
+		}
+		err = app.audit(ctx, tx, "movie", action, movie.ID, before, auditMovie(saved))
+		if err != nil {
+			return err
+		}
+
+		_, err = app.revise(ctx, tx, movie.ID, action, nil)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return saved, nil
 }
 
 func (app *application) editMovie(w http.ResponseWriter, r *http.Request) {
@@ -116,6 +289,10 @@ func (app *application) editMovie(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var movie models.Movie
+	var before interface{}
+
+	// a stale If-Match fails its precondition, a stale version field conflicts
+	conflict := http.StatusConflict
 
 	// movie already exists in db
 	if payload.ID != "0" {
@@ -125,13 +302,38 @@ func (app *application) editMovie(w http.ResponseWriter, r *http.Request) {
 			app.errorJSON(w, http.StatusBadRequest, err)
 			return
 		}
-		m, err := app.models.DB.Get(id)
+		m, err := app.models.Movies.Get(id)
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, http.StatusNotFound, errors.New("movie not found"))
+			return
+		}
 		if err != nil {
 			app.logger.Println("error getting movie from db")
 			app.errorJSON(w, http.StatusInternalServerError, err)
+			return
 		}
+
+		version, fromHeader, err := editVersion(r, payload.Version, m.Version)
+		if errors.Is(err, errMissingVersion) {
+			app.errorJSON(w, http.StatusPreconditionRequired, err)
+			return
+		}
+		if err != nil {
+			app.errorJSON(w, http.StatusBadRequest, err)
+			return
+		}
+		if fromHeader {
+			conflict = http.StatusPreconditionFailed
+		}
+		if version != m.Version {
+			w.Header().Set("ETag", etag(m.Version))
+			app.errorJSON(w, conflict, models.ErrVersionConflict)
+			return
+		}
+
 		movie = *m
 		movie.UpdatedAt = time.Now()
+		before = auditMovie(m)
 	}
 
 	movie.ID, err = strconv.Atoi(payload.ID)
@@ -165,28 +367,25 @@ func (app *application) editMovie(w http.ResponseWriter, r *http.Request) {
 	movie.CreatedAt = time.Now()
 	movie.UpdatedAt = time.Now()
 
-	if movie.Poster == "" {
-		movie = getPoster(movie)
-	}
-
-	// check if movie should be inserted or updated into db
-	if movie.ID == 0 {
-		// store in db
-		err = app.models.DB.InsertMovie(movie)
-		if err != nil {
-			app.logger.Println("error inserting movie to database")
-			app.errorJSON(w, http.StatusInternalServerError, err)
-			return
-		}
-	} else {
-		err = app.models.DB.UpdateMovie(movie)
-		if err != nil {
-			app.logger.Println("error updating movie in database")
-			app.errorJSON(w, http.StatusInternalServerError, err)
-			return
-		}
+	saved, err := app.saveMovie(r.Context(), movie, payload.GenreIDs, before)
+	switch {
+	case errors.Is(err, models.ErrUnknownGenre):
+		app.errorJSON(w, http.StatusBadRequest, err)
+		return
+	case errors.Is(err, models.ErrVersionConflict):
+		// changed between the check above and the update
+		app.errorJSON(w, conflict, err)
+		return
+	case errors.Is(err, sql.ErrNoRows):
+		app.errorJSON(w, http.StatusNotFound, errors.New("movie not found"))
+		return
+	case err != nil:
+		app.logger.Println("error saving movie to database")
+		app.errorJSON(w, http.StatusInternalServerError, err)
+		return
 	}
 
+	w.Header().Set("ETag", etag(saved.Version))
 	ok := jsonResponse{
 		OK:      true,
 		Message: "Movie edited successfully",
@@ -201,7 +400,7 @@ func (app *application) editMovie(w http.ResponseWriter, r *http.Request) {
 
 func (app *application) getAllGenres(w http.ResponseWriter, r *http.Request) {
 
-	genres, err := app.models.DB.GenresAll()
+	genres, err := app.models.Genres.GenresAll()
 	if err != nil {
 		app.logger.Println(err)
 		app.errorJSON(w, http.StatusInternalServerError, err)
@@ -226,7 +425,7 @@ func (app *application) getAllMoviesByGenre(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	movies, err := app.models.DB.All(genreID)
+	movies, err := app.models.Movies.All(genreID)
 	if err != nil {
 		app.logger.Println("error getting movies from db")
 		app.errorJSON(w, http.StatusInternalServerError, err)
@@ -241,6 +440,65 @@ func (app *application) getAllMoviesByGenre(w http.ResponseWriter, r *http.Reque
 	}
 }
 
+// movieFilter reads the paging, sorting and filtering parameters of
+// GET /v1/movies
+func movieFilter(qs url.Values) (models.MovieFilter, error) {
+	f := models.MovieFilter{
+		Limit:       defaultPageSize,
+		Cursor:      qs.Get("cursor"),
+		Sort:        qs.Get("sort"),
+		MPAARatings: splitList(qs.Get("mpaa_rating")),
+	}
+
+	ints := []struct {
+		name string
+		dest *int
+	}{
+		{"limit", &f.Limit},
+		{"offset", &f.Offset},
+		{"year_from", &f.YearFrom},
+		{"year_to", &f.YearTo},
+		{"min_rating", &f.MinRating},
+	}
+	for _, p := range ints {
+		if v := qs.Get(p.name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return f, fmt.Errorf("%s must be a non-negative integer", p.name)
+			}
+			*p.dest = n
+		}
+	}
+
+	if f.Limit < 1 || f.Limit > maxPageSize {
+		return f, fmt.Errorf("limit must be between 1 and %d", maxPageSize)
+	}
+
+	if f.Sort != "" {
+		if _, ok := models.SortFields[f.Sort]; !ok {
+			return f, fmt.Errorf("cannot sort by %q", f.Sort)
+		}
+	}
+
+	switch qs.Get("direction") {
+	case "", "asc":
+	case "desc":
+		f.Desc = true
+	default:
+		return f, errors.New("direction must be asc or desc")
+	}
+
+	for _, v := range splitList(qs.Get("genres")) {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			return f, errors.New("genres must be a comma separated list of genre ids")
+		}
+		f.GenreIDs = append(f.GenreIDs, id)
+	}
+
+	return f, nil
+}
+
 func getPoster(movie models.Movie) models.Movie {
 	type TheMovieDB struct {
 		Page    int `json:"page"`
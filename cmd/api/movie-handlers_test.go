@@ -0,0 +1,359 @@
+package main
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/cmd-ctrl-q/go-movies-server/models"
+)
+
+type moviesResponse struct {
+	Movies   []models.Movie  `json:"movies"`
+	Metadata models.Metadata `json:"metadata"`
+}
+
+func TestGetOneMovie(t *testing.T) {
+	ta := newTestApp(t)
+	genreID := ta.addGenre(t, "Drama")
+	id := ta.addMovie(t, "Casablanca", 1942, 5, genreID)
+
+	rr := ta.do(t, http.MethodGet, "/v1/movie/"+itoa(id), "", nil)
+	expectStatus(t, rr, http.StatusOK)
+
+	var resp struct {
+		Movie models.Movie `json:"movie"`
+	}
+	decode(t, rr, &resp)
+	if resp.Movie.Title != "Casablanca" || len(resp.Movie.MovieGenre) != 1 {
+		t.Errorf("got %+v", resp.Movie)
+	}
+
+	rr = ta.do(t, http.MethodGet, "/v1/movie/abc", "", nil)
+	expectStatus(t, rr, http.StatusBadRequest)
+
+	rr = ta.do(t, http.MethodGet, "/v1/movie/999", "", nil)
+	expectStatus(t, rr, http.StatusNotFound)
+}
+
+func TestGetAllMovies(t *testing.T) {
+	ta := newTestApp(t)
+	drama := ta.addGenre(t, "Drama")
+	crime := ta.addGenre(t, "Crime")
+	ta.addMovie(t, "Casablanca", 1942, 5, drama)
+	ta.addMovie(t, "Heat", 1995, 4, crime)
+	ta.addMovie(t, "The Godfather", 1972, 5, drama, crime)
+	ta.addMovie(t, "Airplane!", 1980, 3)
+
+	titles := func(resp moviesResponse) []string {
+		var titles []string
+		for _, m := range resp.Movies {
+			titles = append(titles, m.Title)
+		}
+		return titles
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		{"default", "", []string{"Airplane!", "Casablanca", "Heat", "The Godfather"}},
+		{"limit", "?limit=2", []string{"Airplane!", "Casablanca"}},
+		{"offset", "?limit=2&offset=2", []string{"Heat", "The Godfather"}},
+		{"sort desc", "?sort=year&direction=desc", []string{"Heat", "Airplane!", "The Godfather", "Casablanca"}},
+		{"year range", "?year_from=1970&year_to=1990", []string{"Airplane!", "The Godfather"}},
+		{"min rating", "?min_rating=5", []string{"Casablanca", "The Godfather"}},
+		{"genres", "?genres=" + itoa(crime), []string{"Heat", "The Godfather"}},
+		{"mpaa", "?mpaa_rating=R", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := ta.do(t, http.MethodGet, "/v1/movies"+tt.query, "", nil)
+			expectStatus(t, rr, http.StatusOK)
+
+			var resp moviesResponse
+			decode(t, rr, &resp)
+			if got := titles(resp); !equalStrings(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// walk every page with cursors
+	var seen []string
+	path := "/v1/movies?limit=3&sort=rating"
+	for page := 0; page < 3; page++ {
+		rr := ta.do(t, http.MethodGet, path, "", nil)
+		expectStatus(t, rr, http.StatusOK)
+
+		var resp moviesResponse
+		decode(t, rr, &resp)
+		if resp.Metadata.Total != 4 {
+			t.Errorf("got total %d, want 4", resp.Metadata.Total)
+		}
+		seen = append(seen, titles(resp)...)
+		if resp.Metadata.NextCursor == "" {
+			break
+		}
+		path = "/v1/movies?limit=3&sort=rating&cursor=" + resp.Metadata.NextCursor
+	}
+	if want := []string{"Airplane!", "Heat", "Casablanca", "The Godfather"}; !equalStrings(seen, want) {
+		t.Errorf("paged through %v, want %v", seen, want)
+	}
+
+	// cursors holding values that do not fit their sort field
+	forged := func(sort, value string) string {
+		c := `{"s":"` + sort + ` asc","v":"` + value + `","id":1}`
+		return "?sort=" + sort + "&cursor=" + base64.RawURLEncoding.EncodeToString([]byte(c))
+	}
+
+	for _, query := range []string{"?limit=0", "?limit=101", "?sort=poster", "?direction=up", "?year_from=x", "?genres=a", "?cursor=bogus",
+		forged("year", "abc"), forged("runtime", "99999999999"), forged("release_date", "1995-13-45"), forged("title", `\u0000`)} {
+		rr := ta.do(t, http.MethodGet, "/v1/movies"+query, "", nil)
+		expectStatus(t, rr, http.StatusBadRequest)
+	}
+}
+
+func TestGetAllMoviesByGenre(t *testing.T) {
+	ta := newTestApp(t)
+	drama := ta.addGenre(t, "Drama")
+	ta.addMovie(t, "Casablanca", 1942, 5, drama)
+	ta.addMovie(t, "Heat", 1995, 4)
+
+	rr := ta.do(t, http.MethodGet, "/v1/movies/"+itoa(drama), "", nil)
+	expectStatus(t, rr, http.StatusOK)
+
+	var resp moviesResponse
+	decode(t, rr, &resp)
+	if len(resp.Movies) != 1 || resp.Movies[0].Title != "Casablanca" {
+		t.Errorf("got %+v", resp.Movies)
+	}
+
+	rr = ta.do(t, http.MethodGet, "/v1/movies/drama", "", nil)
+	expectStatus(t, rr, http.StatusBadRequest)
+}
+
+func TestSearchMovies(t *testing.T) {
+	ta := newTestApp(t)
+	ta.addMovie(t, "Casablanca", 1942, 5)
+	ta.addMovie(t, "Heat", 1995, 4)
+
+	rr := ta.do(t, http.MethodGet, "/v1/search?q=casa", "", nil)
+	expectStatus(t, rr, http.StatusOK)
+
+	var resp struct {
+		Results []models.SearchResult `json:"results"`
+	}
+	decode(t, rr, &resp)
+	if len(resp.Results) != 1 || resp.Results[0].Title != "Casablanca" {
+		t.Errorf("got %+v", resp.Results)
+	}
+
+	// snippets are html, so titles are escaped
+	ta.addMovie(t, "Casablanca <i>Redux</i>", 2020, 3)
+	rr = ta.do(t, http.MethodGet, "/v1/search?q=redux", "", nil)
+	expectStatus(t, rr, http.StatusOK)
+	decode(t, rr, &resp)
+	if len(resp.Results) != 1 || resp.Results[0].TitleSnippet != "Casablanca &lt;i&gt;Redux&lt;/i&gt;" {
+		t.Errorf("got %+v, want an escaped title snippet", resp.Results)
+	}
+
+	rr = ta.do(t, http.MethodGet, "/v1/search", "", nil)
+	expectStatus(t, rr, http.StatusBadRequest)
+}
+
+func TestGetAllGenres(t *testing.T) {
+	ta := newTestApp(t)
+	ta.addGenre(t, "Drama")
+	ta.addGenre(t, "Comedy")
+
+	rr := ta.do(t, http.MethodGet, "/v1/genres", "", nil)
+	expectStatus(t, rr, http.StatusOK)
+
+	var resp struct {
+		Genres []models.Genre `json:"genres"`
+	}
+	decode(t, rr, &resp)
+	if len(resp.Genres) != 2 || resp.Genres[0].GenreName != "Comedy" {
+		t.Errorf("got %+v", resp.Genres)
+	}
+}
+
+func TestEditMovie(t *testing.T) {
+	ta := newTestApp(t)
+	viewer := ta.bearer(t, ta.addUser(t, "viewer@example.com", "password1", models.RoleViewer))
+	editor := ta.bearer(t, ta.addUser(t, "editor@example.com", "password1", models.RoleEditor))
+	drama := ta.addGenre(t, "Drama")
+	crime := ta.addGenre(t, "Crime")
+
+	create := `{"id":"0","title":"Heat","description":"Cops and robbers","release_date":"1995-12-15",
+		"runtime":"170","rating":"5","mpaa_rating":"R","genre_ids":[` + itoa(crime) + `]}`
+
+	rr := ta.do(t, http.MethodPost, "/v1/admin/editmovie", create, nil)
+	expectStatus(t, rr, http.StatusBadRequest)
+
+	rr = ta.do(t, http.MethodPost, "/v1/admin/editmovie", create, viewer)
+	expectStatus(t, rr, http.StatusForbidden)
+
+	rr = ta.do(t, http.MethodPost, "/v1/admin/editmovie", create, editor)
+	expectStatus(t, rr, http.StatusOK)
+
+	movies, err := ta.store.All()
+	if err != nil || len(movies) != 1 {
+		t.Fatalf("got %v movies, %v", len(movies), err)
+	}
+	movie := movies[0]
+	if movie.Year != 1995 || movie.Runtime != 170 || len(movie.MovieGenre) != 1 {
+		t.Errorf("got %+v", movie)
+	}
+
+	update := `{"id":"` + itoa(movie.ID) + `","title":"Heat","release_date":"1995-12-15",
+		"runtime":"171","rating":"5","mpaa_rating":"R","genre_ids":[` + itoa(drama) + `,` + itoa(crime) + `],"version":1}`
+	rr = ta.do(t, http.MethodPost, "/v1/admin/editmovie", update, editor)
+	expectStatus(t, rr, http.StatusOK)
+
+	updated, _ := ta.store.Get(movie.ID)
+	if updated.Runtime != 171 || len(updated.MovieGenre) != 2 {
+		t.Errorf("got %+v", updated)
+	}
+
+	unknown := `{"id":"0","title":"Ronin","release_date":"1998-09-25","runtime":"122","rating":"4","genre_ids":[999]}`
+	rr = ta.do(t, http.MethodPost, "/v1/admin/editmovie", unknown, editor)
+	expectStatus(t, rr, http.StatusBadRequest)
+
+	bad := `{"id":"0","title":"Ronin","release_date":"yesterday","runtime":"122","rating":"4"}`
+	rr = ta.do(t, http.MethodPost, "/v1/admin/editmovie", bad, editor)
+	expectStatus(t, rr, http.StatusBadRequest)
+}
+
+func TestEditMovieVersion(t *testing.T) {
+	ta := newTestApp(t)
+	editor := ta.bearer(t, ta.addUser(t, "editor@example.com", "password1", models.RoleEditor))
+	id := ta.addMovie(t, "Heat", 1995, 4)
+
+	rr := ta.do(t, http.MethodGet, "/v1/movie/"+itoa(id), "", nil)
+	expectStatus(t, rr, http.StatusOK)
+	if got := rr.Header().Get("ETag"); got != `"1"` {
+		t.Fatalf(`got ETag %s, want "1"`, got)
+	}
+
+	edit := func(rating, version, ifMatch string) *httptest.ResponseRecorder {
+		t.Helper()
+		body := `{"id":"` + itoa(id) + `","title":"Heat","release_date":"1995-12-15","runtime":"170","rating":"` + rating + `"`
+		if version != "" {
+			body += `,"version":` + version
+		}
+		header := http.Header{"Authorization": editor["Authorization"]}
+		if ifMatch != "" {
+			header.Set("If-Match", ifMatch)
+		}
+		return ta.do(t, http.MethodPost, "/v1/admin/editmovie", body+"}", header)
+	}
+
+	rr = edit("5", "", "")
+	expectStatus(t, rr, http.StatusPreconditionRequired)
+
+	// the first editor saves and gets the new entity tag
+	rr = edit("5", "", `"1"`)
+	expectStatus(t, rr, http.StatusOK)
+	if got := rr.Header().Get("ETag"); got != `"2"` {
+		t.Errorf(`got ETag %s after saving, want "2"`, got)
+	}
+
+	// a second editor still holding version 1 is turned away either way
+	rr = edit("3", "", `"1"`)
+	expectStatus(t, rr, http.StatusPreconditionFailed)
+	if got := rr.Header().Get("ETag"); got != `"2"` {
+		t.Errorf(`got ETag %s on conflict, want the current "2"`, got)
+	}
+	rr = edit("3", "1", "")
+	expectStatus(t, rr, http.StatusConflict)
+
+	movie, _ := ta.store.Get(id)
+	if movie.Rating != 5 || movie.Version != 2 {
+		t.Errorf("got rating %d version %d, want the first edit kept", movie.Rating, movie.Version)
+	}
+
+	rr = edit("3", "2", "")
+	expectStatus(t, rr, http.StatusOK)
+	rr = edit("4", "", "*")
+	expectStatus(t, rr, http.StatusOK)
+
+	for _, bad := range [][2]string{{"", `W/"4"`}, {"", "4"}, {"3", `"4"`}, {`"x"`, ""}} {
+		rr = edit("4", bad[0], bad[1])
+		expectStatus(t, rr, http.StatusBadRequest)
+	}
+}
+
+func TestDeleteMovie(t *testing.T) {
+	ta := newTestApp(t)
+	editor := ta.bearer(t, ta.addUser(t, "editor@example.com", "password1", models.RoleEditor))
+	admin := ta.bearer(t, ta.addUser(t, "admin@example.com", "password1", models.RoleAdmin))
+	id := ta.addMovie(t, "Heat", 1995, 4)
+
+	rr := ta.do(t, http.MethodGet, "/v1/admin/deletemovie/"+itoa(id), "", editor)
+	expectStatus(t, rr, http.StatusForbidden)
+
+	rr = ta.do(t, http.MethodGet, "/v1/admin/deletemovie/"+itoa(id), "", admin)
+	expectStatus(t, rr, http.StatusOK)
+
+	rr = ta.do(t, http.MethodGet, "/v1/movie/"+itoa(id), "", nil)
+	expectStatus(t, rr, http.StatusNotFound)
+
+	rr = ta.do(t, http.MethodGet, "/v1/admin/deletemovie/"+itoa(id), "", admin)
+	expectStatus(t, rr, http.StatusNotFound)
+
+	rr = ta.do(t, http.MethodGet, "/v1/admin/deletemovie/abc", "", admin)
+	expectStatus(t, rr, http.StatusBadRequest)
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestMovieFilter(t *testing.T) {
+	f, err := movieFilter(url.Values{})
+	if err != nil || f.Limit != defaultPageSize || f.Sort != "" || f.Desc {
+		t.Errorf("got %+v, %v without parameters", f, err)
+	}
+
+	qs, _ := url.ParseQuery("limit=5&offset=10&sort=year&direction=desc&year_from=1970&year_to=1979&min_rating=3&mpaa_rating=PG,R&genres=1,2")
+	f, err = movieFilter(qs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := models.MovieFilter{
+		Limit:       5,
+		Offset:      10,
+		Sort:        "year",
+		Desc:        true,
+		YearFrom:    1970,
+		YearTo:      1979,
+		MinRating:   3,
+		MPAARatings: []string{"PG", "R"},
+		GenreIDs:    []int{1, 2},
+	}
+	if !reflect.DeepEqual(f, want) {
+		t.Errorf("got %+v, want %+v", f, want)
+	}
+
+	for _, query := range []string{"limit=0", "limit=101", "offset=-1", "sort=poster", "direction=up", "year_from=x", "genres=a"} {
+		qs, _ := url.ParseQuery(query)
+		if _, err := movieFilter(qs); err == nil {
+			t.Errorf("%s: no error", query)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"unicode"
+)
+
+// bcrypt ignores everything past 72 bytes
+const maxPasswordBytes = 72
+
+// passwordPolicy holds the rules a new password must satisfy
+type passwordPolicy struct {
+	minLength     int
+	requireUpper  bool
+	requireLower  bool
+	requireDigit  bool
+	requireSymbol bool
+}
+
+// validate returns an error describing the first rule the password breaks
+func (p passwordPolicy) validate(password string) error {
+	if len([]rune(password)) < p.minLength {
+		return fmt.Errorf("password must be at least %d characters", p.minLength)
+	}
+	if len(password) > maxPasswordBytes {
+		return fmt.Errorf("password must be at most %d bytes", maxPasswordBytes)
+	}
+
+	var upper, lower, digit, symbol bool
+	for _, c := range password {
+		switch {
+		case unicode.IsUpper(c):
+			upper = true
+		case unicode.IsLower(c):
+			lower = true
+		case unicode.IsDigit(c):
+			digit = true
+		case unicode.IsPunct(c) || unicode.IsSymbol(c):
+			symbol = true
+		}
+	}
+
+	if p.requireUpper && !upper {
+		return errors.New("password must contain an uppercase letter")
+	}
+	if p.requireLower && !lower {
+		return errors.New("password must contain a lowercase letter")
+	}
+	if p.requireDigit && !digit {
+		return errors.New("password must contain a digit")
+	}
+	if p.requireSymbol && !symbol {
+		return errors.New("password must contain a symbol")
+	}
+
+	return nil
+}
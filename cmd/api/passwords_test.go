@@ -0,0 +1,38 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPasswordPolicy(t *testing.T) {
+	strict := passwordPolicy{minLength: 8, requireUpper: true, requireLower: true, requireDigit: true, requireSymbol: true}
+
+	tests := []struct {
+		name     string
+		policy   passwordPolicy
+		password string
+		valid    bool
+	}{
+		{"long enough", passwordPolicy{minLength: 8}, "abcdefgh", true},
+		{"too short", passwordPolicy{minLength: 8}, "abcdefg", false},
+		{"length in characters", passwordPolicy{minLength: 8}, "ééééééé", false},
+		{"longer than bcrypt reads", passwordPolicy{minLength: 8}, strings.Repeat("a", maxPasswordBytes+1), false},
+		{"meets every rule", strict, "Abcdef1!", true},
+		{"no uppercase", strict, "abcdef1!", false},
+		{"no lowercase", strict, "ABCDEF1!", false},
+		{"no digit", strict, "Abcdefg!", false},
+		{"no symbol", strict, "Abcdefg1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.policy.validate(tt.password)
+			if tt.valid && err != nil {
+				t.Errorf("got %v, want the password accepted", err)
+			}
+			if !tt.valid && err == nil {
+				t.Error("got the password accepted, want an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cmd-ctrl-q/go-movies-server/models"
+	"github.com/julienschmidt/httprouter"
+)
+
+type RollbackPayload struct {
+	Revision int         `json:"revision"`
+	Version  json.Number `json:"version"` // the version being rolled back, when If-Match is not sent
+}
+
+// revisionDiff is the difference between two revisions of a movie
+type revisionDiff struct {
+	MovieID int                           `json:"movie_id"`
+	From    int                           `json:"from"`
+	To      int                           `json:"to"`
+	Changes map[string]models.FieldChange `json:"changes"`
+}
+
+// revise snapshots a movie as its next revision, made by the caller
+// authenticated in ctx
+func (app *application) revise(ctx context.Context, tx models.Models, movieID int, action string, rolledBackTo *int) (*models.MovieRevision, error) {
+	p, ok := principalFromContext(ctx)
+	if !ok {
+		return nil, errors.New("revise: no principal in request context")
+	}
+
+	return tx.Revisions.SnapshotMovie(models.MovieRevision{
+		MovieID:      movieID,
+		Action:       action,
+		RolledBackTo: rolledBackTo,
+		CreatedBy:    p.UserID,
+		CreatedAt:    time.Now(),
+	})
+}
+
+// baseline snapshots a movie that has no revisions yet, such as one
+// created before revisions were kept, so that its current state can be
+// rolled back to. The author of a baseline is unknown
+func baseline(tx models.Models, movieID int) error {
+	revisions, err := tx.Revisions.MovieRevisions(movieID)
+	if err != nil || len(revisions) > 0 {
+		return err
+	}
+
+	_, err = tx.Revisions.SnapshotMovie(models.MovieRevision{
+		MovieID:   movieID,
+		Action:    "baseline",
+		CreatedAt: time.Now(),
+	})
+	return err
+}
+
+// movieID reads the :id url parameter and checks the movie exists,
+// writing an error response when it does not
+func (app *application) movieID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	movie, ok := app.pathMovie(w, r)
+	if !ok {
+		return 0, false
+	}
+	return movie.ID, true
+}
+
+// pathMovie gets the movie named by the :id url parameter, writing an
+// error response when there is none
+func (app *application) pathMovie(w http.ResponseWriter, r *http.Request) (*models.Movie, bool) {
+	params := httprouter.ParamsFromContext(r.Context())
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil {
+		app.logger.Println("error converting string id to int")
+		app.errorJSON(w, http.StatusBadRequest, err)
+		return nil, false
+	}
+
+	movie, err := app.models.Movies.Get(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, http.StatusNotFound, errors.New("movie not found"))
+		return nil, false
+	}
+	if err != nil {
+		app.logger.Println("error getting movie from db")
+		app.errorJSON(w, http.StatusInternalServerError, err)
+		return nil, false
+	}
+
+	return movie, true
+}
+
+func (app *application) getMovieRevisions(w http.ResponseWriter, r *http.Request) {
+	id, ok := app.movieID(w, r)
+	if !ok {
+		return
+	}
+
+	revisions, err := app.models.Revisions.MovieRevisions(id)
+	if err != nil {
+		app.logger.Println("error getting movie revisions")
+		app.errorJSON(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, revisions, "revisions")
+	if err != nil {
+		app.logger.Println("error marshalling json response")
+		app.errorJSON(w, http.StatusInternalServerError, err)
+		return
+	}
+}
+
+// diffMovieRevisions compares the revisions given by ?from= and ?to=
+func (app *application) diffMovieRevisions(w http.ResponseWriter, r *http.Request) {
+	id, ok := app.movieID(w, r)
+	if !ok {
+		return
+	}
+
+	var revisions [2]*models.MovieRevision
+	for i, name := range []string{"from", "to"} {
+		n, err := strconv.Atoi(r.URL.Query().Get(name))
+		if err != nil {
+			app.errorJSON(w, http.StatusBadRequest, fmt.Errorf("%s must be a revision number", name))
+			return
+		}
+
+		revisions[i], err = app.models.Revisions.GetMovieRevision(id, n)
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, http.StatusNotFound, fmt.Errorf("movie has no revision %d", n))
+			return
+		}
+		if err != nil {
+			app.logger.Println("error getting movie revision")
+			app.errorJSON(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	changes, err := diff(revisions[0].Movie, revisions[1].Movie)
+	if err != nil {
+		app.errorJSON(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	d := revisionDiff{
+		MovieID: id,
+		From:    revisions[0].Revision,
+		To:      revisions[1].Revision,
+		Changes: changes,
+	}
+
+	err = app.writeJSON(w, http.StatusOK, d, "diff")
+	if err != nil {
+		app.logger.Println("error marshalling json response")
+		app.errorJSON(w, http.StatusInternalServerError, err)
+		return
+	}
+}
+
+// rollbackMovie restores a movie to an earlier revision, recording the
+// result as a new revision. Like editMovie, it needs the version being
+// rolled back, and answers a stale one with 412 or 409
+func (app *application) rollbackMovie(w http.ResponseWriter, r *http.Request) {
+	current, ok := app.pathMovie(w, r)
+	if !ok {
+		return
+	}
+	id := current.ID
+
+	var payload RollbackPayload
+	err := json.NewDecoder(r.Body).Decode(&payload)
+	if err != nil {
+		app.logger.Println("error decoding rollback:", err)
+		app.errorJSON(w, http.StatusBadRequest, err)
+		return
+	}
+
+	version, fromHeader, err := editVersion(r, payload.Version, current.Version)
+	if errors.Is(err, errMissingVersion) {
+		app.errorJSON(w, http.StatusPreconditionRequired, err)
+		return
+	}
+	if err != nil {
+		app.errorJSON(w, http.StatusBadRequest, err)
+		return
+	}
+	conflict := http.StatusConflict
+	if fromHeader {
+		conflict = http.StatusPreconditionFailed
+	}
+	if version != current.Version {
+		w.Header().Set("ETag", etag(current.Version))
+		app.errorJSON(w, conflict, models.ErrVersionConflict)
+		return
+	}
+
+	var revision *models.MovieRevision
+	var after *models.Movie
+	err = app.models.WithTx(r.Context(), func(tx models.Models) error {
+		target, err := tx.Revisions.GetMovieRevision(id, payload.Revision)
+		if err != nil {
+			return err
+		}
+
+		before, err := tx.Movies.Get(id)
+		if err != nil {
+			return err
+		}
+
+		movie := *before
+		target.Movie.Apply(&movie)
+		movie.UpdatedAt = time.Now()
+		// fails with ErrVersionConflict if the movie changed since the check above
+		movie.Version = version
+
+		// a snapshot without genres clears them
+		genreIDs := append([]int{}, target.Movie.GenreIDs...)
+		err = tx.Movies.UpdateMovie(movie, genreIDs)
+		if err != nil {
+			return err
+		}
+
+		after, err = tx.Movies.Get(id)
+		if err != nil {
+			return err
+		}
+		err = app.audit(r.Context(), tx, "movie", "rollback", id, auditMovie(before), auditMovie(after))
+		if err != nil {
+			return err
+		}
+
+		revision, err = app.revise(r.Context(), tx, id, "rollback", &target.Revision)
+		return err
+	})
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		app.errorJSON(w, http.StatusNotFound, fmt.Errorf("movie has no revision %d", payload.Revision))
+		return
+	case errors.Is(err, models.ErrUnknownGenre):
+		app.errorJSON(w, http.StatusConflict, fmt.Errorf("revision %d refers to a deleted genre: %w", payload.Revision, err))
+		return
+	case errors.Is(err, models.ErrVersionConflict):
+		app.errorJSON(w, conflict, err)
+		return
+	case err != nil:
+		app.logger.Println("error rolling back movie")
+		app.errorJSON(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("ETag", etag(after.Version))
+	err = app.writeJSON(w, http.StatusOK, revision, "revision")
+	if err != nil {
+		app.logger.Println("error marshalling json response")
+		app.errorJSON(w, http.StatusInternalServerError, err)
+		return
+	}
+}
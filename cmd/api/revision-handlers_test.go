@@ -0,0 +1,163 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/cmd-ctrl-q/go-movies-server/models"
+)
+
+func TestMovieRevisions(t *testing.T) {
+	ta := newTestApp(t)
+	viewer := ta.bearer(t, ta.addUser(t, "viewer@example.com", "password1", models.RoleViewer))
+	editorUser := ta.addUser(t, "editor@example.com", "password1", models.RoleEditor)
+	editor := ta.bearer(t, editorUser)
+	drama := ta.addGenre(t, "Drama")
+	crime := ta.addGenre(t, "Crime")
+
+	// a movie from before revisions were kept gets a baseline on its first edit
+	id := ta.addMovie(t, "Heat", 1995, 3, drama)
+	edit := func(rating, genreID string) {
+		t.Helper()
+		body := `{"id":"` + itoa(id) + `","title":"Heat","release_date":"1995-12-15","runtime":"170",
+			"rating":"` + rating + `","mpaa_rating":"R","genre_ids":[` + genreID + `]}`
+		movie, _ := ta.store.Get(id)
+		header := http.Header{"Authorization": editor["Authorization"], "If-Match": {etag(movie.Version)}}
+		rr := ta.do(t, http.MethodPost, "/v1/admin/editmovie", body, header)
+		expectStatus(t, rr, http.StatusOK)
+	}
+	edit("4", itoa(drama))
+	edit("5", itoa(crime))
+
+	path := "/v1/admin/movies/" + itoa(id)
+
+	rr := ta.do(t, http.MethodGet, path+"/revisions", "", viewer)
+	expectStatus(t, rr, http.StatusForbidden)
+
+	rr = ta.do(t, http.MethodGet, path+"/revisions", "", editor)
+	expectStatus(t, rr, http.StatusOK)
+	var list struct {
+		Revisions []*models.MovieRevision `json:"revisions"`
+	}
+	decode(t, rr, &list)
+	if len(list.Revisions) != 3 {
+		t.Fatalf("got %d revisions, want 3", len(list.Revisions))
+	}
+	for i, want := range []string{"baseline", "update", "update"} {
+		rev := list.Revisions[i]
+		if rev.Revision != i+1 || rev.Action != want {
+			t.Errorf("revision %d: got %d %s, want %s", i+1, rev.Revision, rev.Action, want)
+		}
+	}
+	if list.Revisions[0].CreatedBy != 0 || list.Revisions[1].CreatedBy != editorUser.ID {
+		t.Errorf("got authors %d and %d", list.Revisions[0].CreatedBy, list.Revisions[1].CreatedBy)
+	}
+
+	rr = ta.do(t, http.MethodGet, path+"/revisions/diff?from=1&to=3", "", editor)
+	expectStatus(t, rr, http.StatusOK)
+	var d struct {
+		Diff revisionDiff `json:"diff"`
+	}
+	decode(t, rr, &d)
+	if _, ok := d.Diff.Changes["title"]; ok ||
+		string(d.Diff.Changes["rating"].Before) != "3" || string(d.Diff.Changes["rating"].After) != "5" ||
+		string(d.Diff.Changes["genre_ids"].After) != "["+itoa(crime)+"]" {
+		t.Errorf("got diff %+v", d.Diff)
+	}
+
+	rr = ta.do(t, http.MethodGet, path+"/revisions/diff?from=1&to=9", "", editor)
+	expectStatus(t, rr, http.StatusNotFound)
+	rr = ta.do(t, http.MethodGet, path+"/revisions/diff?from=1", "", editor)
+	expectStatus(t, rr, http.StatusBadRequest)
+
+	// a rollback needs the version it replaces, like any other edit
+	rr = ta.do(t, http.MethodPost, path+"/rollback", `{"revision":1}`, editor)
+	expectStatus(t, rr, http.StatusPreconditionRequired)
+	stale := http.Header{"Authorization": editor["Authorization"], "If-Match": {etag(1)}}
+	rr = ta.do(t, http.MethodPost, path+"/rollback", `{"revision":1}`, stale)
+	expectStatus(t, rr, http.StatusPreconditionFailed)
+	if got := rr.Header().Get("ETag"); got != etag(3) {
+		t.Errorf("got ETag %s for a stale rollback, want %s", got, etag(3))
+	}
+	rr = ta.do(t, http.MethodPost, path+"/rollback", `{"revision":1,"version":2}`, editor)
+	expectStatus(t, rr, http.StatusConflict)
+
+	// rolling back restores the snapshot and becomes revision 4
+	rr = ta.do(t, http.MethodPost, path+"/rollback", `{"revision":1,"version":3}`, editor)
+	expectStatus(t, rr, http.StatusOK)
+	if got := rr.Header().Get("ETag"); got != etag(4) {
+		t.Errorf("got ETag %s after rolling back, want %s", got, etag(4))
+	}
+	var rolledBack struct {
+		Revision models.MovieRevision `json:"revision"`
+	}
+	decode(t, rr, &rolledBack)
+	rev := rolledBack.Revision
+	if rev.Revision != 4 || rev.Action != "rollback" || rev.RolledBackTo == nil || *rev.RolledBackTo != 1 {
+		t.Errorf("got rollback revision %+v", rev)
+	}
+
+	movie, _ := ta.store.Get(id)
+	if movie.Rating != 3 || movie.Runtime != 100 || len(movie.MovieGenre) != 1 {
+		t.Errorf("got %+v after rollback, want the baseline", movie)
+	}
+	for _, name := range movie.MovieGenre {
+		if name != "Drama" {
+			t.Errorf("got genre %s after rollback, want Drama", name)
+		}
+	}
+
+	entries, _ := ta.store.AuditLog(models.AuditFilter{Entity: "movie", Limit: 1})
+	if len(entries) != 1 || entries[0].Action != "rollback" {
+		t.Errorf("got audit %+v, want the rollback", entries)
+	}
+
+	rr = ta.do(t, http.MethodPost, path+"/rollback", `{"revision":9,"version":4}`, editor)
+	expectStatus(t, rr, http.StatusNotFound)
+	rr = ta.do(t, http.MethodPost, "/v1/admin/movies/999/rollback", `{"revision":1,"version":1}`, editor)
+	expectStatus(t, rr, http.StatusNotFound)
+
+	// a revision linked to a genre deleted since cannot be restored
+	err := ta.store.DeleteGenre(crime, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr = ta.do(t, http.MethodPost, path+"/rollback", `{"revision":3,"version":4}`, editor)
+	expectStatus(t, rr, http.StatusConflict)
+}
+
+func TestGenreCascadeRevisions(t *testing.T) {
+	ta := newTestApp(t)
+	admin := ta.bearer(t, ta.addUser(t, "admin@example.com", "password1", models.RoleAdmin))
+	drama := ta.addGenre(t, "Drama")
+	crime := ta.addGenre(t, "Crime")
+	heat := ta.addMovie(t, "Heat", 1995, 4, drama, crime)
+	tenet := ta.addMovie(t, "Tenet", 2020, 3, crime)
+
+	rr := ta.do(t, http.MethodGet, "/v1/admin/deletegenre/"+itoa(drama)+"?cascade=true", "", admin)
+	expectStatus(t, rr, http.StatusOK)
+
+	// the movies taken out of the genre can be rolled back to before it
+	revisions, err := ta.store.MovieRevisions(heat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 2 || revisions[0].Action != "baseline" || revisions[1].Action != "update" {
+		t.Fatalf("got revisions %+v, want a baseline and an update", revisions)
+	}
+	if len(revisions[0].Movie.GenreIDs) != 2 || len(revisions[1].Movie.GenreIDs) != 1 {
+		t.Errorf("got genres %v then %v, want two then one", revisions[0].Movie.GenreIDs, revisions[1].Movie.GenreIDs)
+	}
+
+	if revisions, _ := ta.store.MovieRevisions(tenet); len(revisions) != 0 {
+		t.Errorf("got revisions %+v for a movie not in the genre", revisions)
+	}
+
+	// and they get a new version, so edits based on the old one conflict
+	for id, want := range map[int]int{heat: 2, tenet: 1} {
+		movie, _ := ta.store.Get(id)
+		if movie.Version != want {
+			t.Errorf("movie %d: got version %d, want %d", id, movie.Version, want)
+		}
+	}
+}
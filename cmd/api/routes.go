@@ -4,20 +4,17 @@ import (
 	"context"
 	"net/http"
 
+	"github.com/cmd-ctrl-q/go-movies-server/models"
 	"github.com/julienschmidt/httprouter"
 	"github.com/justinas/alice"
 )
 
-type paramString string
-
-var paramKey paramString = "params"
-
 // wrapper for wrapping middleware
 // adds the necessary fields from context back to httprouter.Handle
 func (app *application) wrap(next http.Handler) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
-		// get necessary values from context
-		ctx := context.WithValue(r.Context(), paramKey, params)
+		// store params where httprouter.ParamsFromContext looks for them
+		ctx := context.WithValue(r.Context(), httprouter.ParamsKey, params)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	}
 }
@@ -27,23 +24,52 @@ func (app *application) routes() http.Handler {
 
 	// chain middleware
 	secure := alice.New(app.checkToken)
+	editor := secure.Append(app.requireRole(models.RoleEditor))
+	admin := secure.Append(app.requireRole(models.RoleAdmin))
 
 	router.HandlerFunc(http.MethodGet, "/status", app.statusHandler)
+	router.HandlerFunc(http.MethodGet, "/.well-known/jwks.json", app.jwksHandler)
 
+	router.HandlerFunc(http.MethodGet, "/v1/graphql", app.moviesGraphQL)
 	router.HandlerFunc(http.MethodPost, "/v1/graphql", app.moviesGraphQL)
 
 	router.HandlerFunc(http.MethodPost, "/v1/signin", app.Signin)
+	router.HandlerFunc(http.MethodPost, "/v1/signup", app.Signup)
+	router.HandlerFunc(http.MethodPost, "/v1/token/refresh", app.Refresh)
+
+	router.POST("/v1/signout", app.wrap(secure.ThenFunc(app.Signout)))
+
+	router.POST("/v1/account/password", app.wrap(secure.ThenFunc(app.changePassword)))
 
 	router.HandlerFunc(http.MethodGet, "/v1/movie/:id", app.getOneMovie)
 	router.HandlerFunc(http.MethodGet, "/v1/movies", app.getAllMovies)
 	router.HandlerFunc(http.MethodGet, "/v1/movies/:genre_id", app.getAllMoviesByGenre)
+	router.HandlerFunc(http.MethodGet, "/v1/search", app.searchMovies)
 
 	router.HandlerFunc(http.MethodGet, "/v1/genres", app.getAllGenres)
 
 	// secure the function using the middleware wrap function and alice package
-	router.POST("/v1/admin/editmovie", app.wrap(secure.ThenFunc(app.editMovie)))
+	router.POST("/v1/admin/editmovie", app.wrap(editor.ThenFunc(app.editMovie)))
+
+	router.GET("/v1/admin/movies/:id/revisions", app.wrap(editor.ThenFunc(app.getMovieRevisions)))
+	router.GET("/v1/admin/movies/:id/revisions/diff", app.wrap(editor.ThenFunc(app.diffMovieRevisions)))
+	router.POST("/v1/admin/movies/:id/rollback", app.wrap(editor.ThenFunc(app.rollbackMovie)))
+
+	router.GET("/v1/admin/deletemovie/:id", app.wrap(admin.ThenFunc(app.deleteMovie)))
+
+	router.GET("/v1/admin/trash", app.wrap(admin.ThenFunc(app.getTrash)))
+	router.POST("/v1/admin/trash/:id/restore", app.wrap(admin.ThenFunc(app.restoreMovie)))
+	router.POST("/v1/admin/trash/:id/purge", app.wrap(admin.ThenFunc(app.purgeMovie)))
+
+	router.POST("/v1/admin/editgenre", app.wrap(admin.ThenFunc(app.editGenre)))
+
+	router.GET("/v1/admin/deletegenre/:id", app.wrap(admin.ThenFunc(app.deleteGenre)))
+
+	router.GET("/v1/admin/audit", app.wrap(admin.ThenFunc(app.getAuditLog)))
 
-	router.GET("/v1/admin/deletemovie/:id", app.wrap(secure.ThenFunc(app.deleteMovie)))
+	router.GET("/v1/admin/apikeys", app.wrap(admin.ThenFunc(app.getAllAPIKeys)))
+	router.POST("/v1/admin/apikeys", app.wrap(admin.ThenFunc(app.createAPIKey)))
+	router.POST("/v1/admin/apikeys/:id/revoke", app.wrap(admin.ThenFunc(app.revokeAPIKey)))
 
 	return app.enableCORS(router)
 }
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/cmd-ctrl-q/go-movies-server/models"
+)
+
+// TestRoutes checks every route in routes() is served and guarded as expected
+func TestRoutes(t *testing.T) {
+	ta := newTestApp(t)
+	admin := ta.addUser(t, "admin@example.com", "password1", models.RoleAdmin)
+	auth := ta.bearer(t, admin)
+	genreID := ta.addGenre(t, "Drama")
+	movieID := ta.addMovie(t, "Casablanca", 1942, 5, genreID)
+
+	tests := []struct {
+		method string
+		path   string
+		body   string
+		secure bool
+		want   int
+	}{
+		{http.MethodGet, "/status", "", false, http.StatusOK},
+		{http.MethodGet, "/.well-known/jwks.json", "", false, http.StatusOK},
+		{http.MethodGet, "/v1/graphql?query=" + url.QueryEscape("{ list { id } }"), "", false, http.StatusOK},
+		{http.MethodPost, "/v1/graphql", `{"query":"{ list { id } }"}`, false, http.StatusOK},
+		{http.MethodPost, "/v1/signin", `{"email":"admin@example.com","password":"password1"}`, false, http.StatusOK},
+		{http.MethodPost, "/v1/signup", `{"email":"new@example.com","password":"password1"}`, false, http.StatusCreated},
+		{http.MethodPost, "/v1/token/refresh", `{"refresh_token":"unknown"}`, false, http.StatusUnauthorized},
+		{http.MethodPost, "/v1/account/password", `{"current_password":"password1","new_password":"password2"}`, true, http.StatusOK},
+		{http.MethodGet, "/v1/movie/" + itoa(movieID), "", false, http.StatusOK},
+		{http.MethodGet, "/v1/movies", "", false, http.StatusOK},
+		{http.MethodGet, "/v1/movies/" + itoa(genreID), "", false, http.StatusOK},
+		{http.MethodGet, "/v1/search?q=casa", "", false, http.StatusOK},
+		{http.MethodGet, "/v1/genres", "", false, http.StatusOK},
+		{http.MethodPost, "/v1/admin/editmovie", `{"id":"0","title":"Heat","release_date":"1995-12-15","runtime":"170","rating":"5","mpaa_rating":"R"}`, true, http.StatusOK},
+		{http.MethodPost, "/v1/admin/editgenre", `{"id":0,"genre_name":"Comedy"}`, true, http.StatusCreated},
+		{http.MethodGet, "/v1/admin/deletegenre/" + itoa(genreID) + "?cascade=true", "", true, http.StatusOK},
+		{http.MethodGet, "/v1/admin/movies/" + itoa(movieID) + "/revisions", "", true, http.StatusOK},
+		{http.MethodGet, "/v1/admin/movies/" + itoa(movieID) + "/revisions/diff?from=1&to=9", "", true, http.StatusNotFound},
+		{http.MethodPost, "/v1/admin/movies/" + itoa(movieID) + "/rollback", `{"revision":9,"version":2}`, true, http.StatusNotFound},
+		{http.MethodGet, "/v1/admin/deletemovie/" + itoa(movieID), "", true, http.StatusOK},
+		{http.MethodGet, "/v1/admin/trash", "", true, http.StatusOK},
+		{http.MethodPost, "/v1/admin/trash/" + itoa(movieID) + "/restore", "", true, http.StatusOK},
+		{http.MethodPost, "/v1/admin/trash/" + itoa(movieID) + "/purge", "", true, http.StatusNotFound},
+		{http.MethodGet, "/v1/admin/audit", "", true, http.StatusOK},
+		{http.MethodGet, "/v1/admin/apikeys", "", true, http.StatusOK},
+		{http.MethodPost, "/v1/admin/apikeys", `{"name":"ingest","scopes":["editor"],"expires_at":"2999-01-01T00:00:00Z"}`, true, http.StatusCreated},
+		{http.MethodPost, "/v1/admin/apikeys/999/revoke", "", true, http.StatusNotFound},
+		{http.MethodPost, "/v1/signout", "", true, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			if tt.secure {
+				rr := ta.do(t, tt.method, tt.path, tt.body, nil)
+				if rr.Code == http.StatusOK || rr.Code == http.StatusCreated {
+					t.Fatalf("served without a token: %d", rr.Code)
+				}
+			}
+
+			var header http.Header
+			if tt.secure {
+				header = auth
+			}
+			rr := ta.do(t, tt.method, tt.path, tt.body, header)
+			expectStatus(t, rr, tt.want)
+		})
+	}
+}
+
+func TestStatus(t *testing.T) {
+	ta := newTestApp(t)
+
+	rr := ta.do(t, http.MethodGet, "/status", "", nil)
+	expectStatus(t, rr, http.StatusOK)
+
+	var status AppStatus
+	decode(t, rr, &status)
+	if status.Status != "Available" || status.Version != version {
+		t.Errorf("got status %+v", status)
+	}
+
+	if got := rr.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("got Access-Control-Allow-Origin %q, want *", got)
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	ta := newTestApp(t)
+
+	rr := ta.do(t, http.MethodGet, "/.well-known/jwks.json", "", nil)
+	expectStatus(t, rr, http.StatusOK)
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	decode(t, rr, &set)
+	// HS256 secrets are never published
+	if len(set.Keys) != 0 {
+		t.Errorf("got %d keys, want none", len(set.Keys))
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+
+	"github.com/cmd-ctrl-q/go-movies-server/models"
+)
+
+// runSeed handles the seed subcommand, loading each fixture file given:
+//
+//	seed fixtures/movies.json [more.yaml ...]
+func runSeed(db *sql.DB, logger *log.Logger, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: seed file.json|file.yaml ...")
+	}
+
+	m := &models.DBModel{DB: db}
+	for _, path := range args {
+		fixture, err := models.ReadFixture(path)
+		if err != nil {
+			return err
+		}
+
+		stats, err := m.Seed(fixture)
+		if err != nil {
+			return err
+		}
+
+		logger.Printf("%s: %d genres created, %d updated; %d movies created, %d updated",
+			path, stats.GenresCreated, stats.GenresUpdated, stats.MoviesCreated, stats.MoviesUpdated)
+	}
+
+	return nil
+}
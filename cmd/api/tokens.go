@@ -1,10 +1,17 @@
 package main
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/cmd-ctrl-q/go-movies-server/models"
@@ -12,17 +19,26 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
-var validUser = models.User{
-	ID:       10,
-	Email:    "me@here.com",
-	Password: createHash("password"),
-}
+// dummyHash is compared against when the email is unknown, so a signin
+// for a missing user costs the same bcrypt work as a wrong password
+var dummyHash, _ = createHash("not a real password")
 
 type Credentials struct {
 	Username string `json:"email"`
 	Password string `json:"password"`
 }
 
+type RefreshPayload struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// tokenPair is sent to clients on signin and refresh
+type tokenPair struct {
+	Token        string    `json:"token"`
+	RefreshToken string    `json:"refresh_token"`
+	Expires      time.Time `json:"expires"`
+}
+
 func (app *application) Signin(w http.ResponseWriter, r *http.Request) {
 	var creds Credentials
 
@@ -33,30 +49,56 @@ func (app *application) Signin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// get username and password, query db and check if there is a valid user.
-	// and check the hashed password against the hash in the db.
-	// mock this process:
-	hashedPassword := validUser.Password
+	// refuse throttled addresses and accounts before doing any bcrypt work.
+	// the attempt counts as failed until the password checks out
+	ipKey, accountKey := loginKeys(r, creds.Username)
+	wait, err := app.logins.reserve(ipKey, accountKey)
+	if err != nil {
+		app.logger.Println("error checking login attempts")
+		app.errorJSON(w, http.StatusInternalServerError, err)
+		return
+	}
+	if wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		app.errorJSON(w, http.StatusTooManyRequests, errors.New("too many failed signins, try again later"))
+		return
+	}
+
+	// look up the user and check the password against the hash in the db.
+	// unknown emails still run a bcrypt comparison against dummyHash
+	user, err := app.models.Users.GetUserByEmail(creds.Username)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		app.logger.Println("error getting user from db")
+		app.errorJSON(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	hashedPassword := dummyHash
+	if user != nil {
+		hashedPassword = user.Password
+	}
 
 	err = bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(creds.Password))
-	if err != nil {
+	if err != nil || user == nil {
 		app.logger.Println("unauthorized user at signin")
 		app.errorJSON(w, http.StatusUnauthorized, errors.New("unauthorized"))
 		return
 	}
 
-	// create and add claims
-	// user is valid
-	var claims jwt.Claims
-	claims.Subject = fmt.Sprintf("%d", validUser.ID)                    // claim subject
-	claims.Issued = jwt.NewNumericTime(time.Now())                      // when was claim issued
-	claims.NotBefore = jwt.NewNumericTime(time.Now())                   // not valid before now
-	claims.Expires = jwt.NewNumericTime(time.Now().Add(24 * time.Hour)) // when does it expire (24 hours)
-	claims.Issuer = "mydomain.com"
-	claims.Audiences = []string{"mydomain.com"} // who can see this
+	err = app.logins.succeed(ipKey, accountKey)
+	if err != nil {
+		app.logger.Println("error clearing failed signins:", err)
+	}
 
-	// create token
-	jwtBytes, err := claims.HMACSign(jwt.HS256, []byte(app.config.jwt.secret))
+	// user is valid, start a new session
+	familyID, err := randomToken(16)
+	if err != nil {
+		app.logger.Println("error creating session id")
+		app.errorJSON(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	tokens, err := app.issueTokens(user, familyID)
 	if err != nil {
 		app.logger.Println("error signing")
 		app.errorJSON(w, http.StatusUnauthorized, errors.New("error signing"))
@@ -64,10 +106,162 @@ func (app *application) Signin(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// write to client
-	app.writeJSON(w, http.StatusOK, string(jwtBytes), "response")
+	app.writeJSON(w, http.StatusOK, tokens, "response")
+}
+
+// Refresh swaps a refresh token for a new access and refresh token.
+// Each refresh token works once; presenting a used one revokes the session
+func (app *application) Refresh(w http.ResponseWriter, r *http.Request) {
+	var payload RefreshPayload
+
+	err := json.NewDecoder(r.Body).Decode(&payload)
+	if err != nil || payload.RefreshToken == "" {
+		app.errorJSON(w, http.StatusBadRequest, errors.New("refresh_token is required"))
+		return
+	}
+
+	token, err := app.models.Tokens.GetRefreshToken(hashToken(payload.RefreshToken))
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			app.logger.Println("error getting refresh token from db")
+		}
+		app.errorJSON(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return
+	}
+
+	if token.RevokedAt.Valid || time.Now().After(token.ExpiresAt) {
+		app.errorJSON(w, http.StatusUnauthorized, errors.New("unauthorized - refresh token expired"))
+		return
+	}
+
+	fresh, err := app.models.Tokens.UseRefreshToken(token.ID)
+	if err != nil {
+		app.logger.Println("error marking refresh token used")
+		app.errorJSON(w, http.StatusInternalServerError, err)
+		return
+	}
+	if !fresh {
+		// a used token came back, so it was stolen or replayed: end the session
+		app.logger.Println("refresh token reuse detected for session", token.FamilyID)
+		err = app.models.Tokens.RevokeTokenFamily(token.FamilyID)
+		if err != nil {
+			app.logger.Println("error revoking session:", err)
+		}
+		app.errorJSON(w, http.StatusUnauthorized, errors.New("unauthorized - refresh token reused"))
+		return
+	}
+
+	user, err := app.models.Users.GetUser(token.UserID)
+	if err != nil {
+		app.logger.Println("error getting user from db")
+		app.errorJSON(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return
+	}
+
+	tokens, err := app.issueTokens(user, token.FamilyID)
+	if err != nil {
+		app.logger.Println("error signing")
+		app.errorJSON(w, http.StatusUnauthorized, errors.New("error signing"))
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, tokens, "response")
+}
+
+// Signout revokes the session of the access token used to call it
+func (app *application) Signout(w http.ResponseWriter, r *http.Request) {
+	p, ok := principalFromContext(r.Context())
+	if !ok {
+		app.errorJSON(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return
+	}
+
+	err := app.models.Tokens.RevokeTokenFamily(p.SessionID)
+	if err != nil {
+		app.logger.Println("error revoking session")
+		app.errorJSON(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	resp := jsonResponse{
+		OK:      true,
+		Message: "Signed out",
+	}
+
+	err = app.writeJSON(w, http.StatusOK, resp, "response")
+	if err != nil {
+		app.errorJSON(w, http.StatusInternalServerError, err)
+		return
+	}
+}
+
+// issueTokens signs an access token and stores a new refresh token
+// for the given session
+func (app *application) issueTokens(user *models.User, familyID string) (*tokenPair, error) {
+	now := time.Now()
+	expires := now.Add(app.config.jwt.ttl)
+
+	// create and add claims
+	var claims jwt.Claims
+	claims.Subject = fmt.Sprintf("%d", user.ID)  // claim subject
+	claims.Issued = jwt.NewNumericTime(now)      // when was claim issued
+	claims.NotBefore = jwt.NewNumericTime(now)   // not valid before now
+	claims.Expires = jwt.NewNumericTime(expires) // when does it expire
+	claims.Issuer = app.config.jwt.issuer
+	claims.Audiences = []string{app.config.jwt.audience} // who can see this
+	claims.Set = map[string]interface{}{
+		"role": user.Role, // checked by requireRole
+		"sid":  familyID,  // checked against revoked sessions
+	}
+
+	// create token
+	jwtBytes, err := app.keys.sign(&claims)
+	if err != nil {
+		return nil, err
+	}
+
+	refresh, err := randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	err = app.models.Tokens.InsertRefreshToken(models.RefreshToken{
+		UserID:    user.ID,
+		FamilyID:  familyID,
+		TokenHash: hashToken(refresh),
+		ExpiresAt: now.Add(app.config.jwt.refreshTTL),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &tokenPair{
+		Token:        string(jwtBytes),
+		RefreshToken: refresh,
+		Expires:      expires,
+	}, nil
+}
+
+// randomToken returns n random bytes encoded for use in urls and json
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken returns the form a refresh token is stored in
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
 }
 
-func createHash(password string) string {
-	hash, _ := bcrypt.GenerateFromPassword([]byte(password), 12)
-	return string(hash)
+func createHash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
 }
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/cmd-ctrl-q/go-movies-server/models"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func itoa(n int) string {
+	return strconv.Itoa(n)
+}
+
+func TestSignin(t *testing.T) {
+	ta := newTestApp(t)
+	ta.addUser(t, "me@example.com", "password1", models.RoleViewer)
+
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"valid", `{"email":"me@example.com","password":"password1"}`, http.StatusOK},
+		{"email case", `{"email":"ME@example.com","password":"password1"}`, http.StatusOK},
+		{"wrong password", `{"email":"me@example.com","password":"wrong"}`, http.StatusUnauthorized},
+		{"unknown email", `{"email":"who@example.com","password":"password1"}`, http.StatusUnauthorized},
+		{"bad json", `{`, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := ta.do(t, http.MethodPost, "/v1/signin", tt.body, nil)
+			expectStatus(t, rr, tt.want)
+
+			if tt.want != http.StatusOK {
+				return
+			}
+			var resp struct {
+				Response tokenPair `json:"response"`
+			}
+			decode(t, rr, &resp)
+			if resp.Response.Token == "" || resp.Response.RefreshToken == "" {
+				t.Errorf("got tokens %+v", resp.Response)
+			}
+		})
+	}
+}
+
+func TestSigninSameErrorForUnknownEmail(t *testing.T) {
+	ta := newTestApp(t)
+	ta.addUser(t, "me@example.com", "password1", models.RoleViewer)
+
+	wrong := ta.do(t, http.MethodPost, "/v1/signin", `{"email":"me@example.com","password":"nope"}`, nil)
+	unknown := ta.do(t, http.MethodPost, "/v1/signin", `{"email":"who@example.com","password":"nope"}`, nil)
+
+	if wrong.Code != unknown.Code || wrong.Body.String() != unknown.Body.String() {
+		t.Errorf("wrong password got %d %s, unknown email got %d %s",
+			wrong.Code, wrong.Body, unknown.Code, unknown.Body)
+	}
+}
+
+func TestSigninThrottling(t *testing.T) {
+	ta := newTestApp(t)
+	ta.addUser(t, "me@example.com", "password1", models.RoleViewer)
+
+	bad := `{"email":"me@example.com","password":"wrong"}`
+	for i := 0; i < ta.config.login.freeAttempts+1; i++ {
+		rr := ta.do(t, http.MethodPost, "/v1/signin", bad, nil)
+		expectStatus(t, rr, http.StatusUnauthorized)
+	}
+
+	// even the right password is refused while throttled
+	rr := ta.do(t, http.MethodPost, "/v1/signin", `{"email":"me@example.com","password":"password1"}`, nil)
+	expectStatus(t, rr, http.StatusTooManyRequests)
+
+	retry, err := strconv.Atoi(rr.Header().Get("Retry-After"))
+	if err != nil || retry < 1 {
+		t.Errorf("got Retry-After %q", rr.Header().Get("Retry-After"))
+	}
+}
+
+func TestLoginPolicy(t *testing.T) {
+	p := newTestApp(t).config.login.loginPolicy
+	now := time.Now()
+
+	tests := []struct {
+		failures int
+		want     string
+	}{
+		{1, "0s"},
+		{2, "0s"},
+		{3, "1m0s"},
+		{4, "2m0s"},
+		{5, "15m0s"},
+		{9, "15m0s"},
+	}
+
+	for _, tt := range tests {
+		until := p.blockedUntil(tt.failures, now)
+		got := "0s"
+		if !until.IsZero() {
+			got = until.Sub(now).String()
+		}
+		if got != tt.want {
+			t.Errorf("%d failures: got block %s, want %s", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	ta := newTestApp(t)
+	user := ta.addUser(t, "me@example.com", "password1", models.RoleViewer)
+	first := ta.tokens(t, user)
+
+	rr := ta.do(t, http.MethodPost, "/v1/token/refresh", `{"refresh_token":"`+first.RefreshToken+`"}`, nil)
+	expectStatus(t, rr, http.StatusOK)
+
+	var resp struct {
+		Response tokenPair `json:"response"`
+	}
+	decode(t, rr, &resp)
+	second := resp.Response
+	if second.RefreshToken == first.RefreshToken {
+		t.Fatal("refresh token was not rotated")
+	}
+
+	// replaying the first token revokes the whole session
+	rr = ta.do(t, http.MethodPost, "/v1/token/refresh", `{"refresh_token":"`+first.RefreshToken+`"}`, nil)
+	expectStatus(t, rr, http.StatusUnauthorized)
+
+	rr = ta.do(t, http.MethodPost, "/v1/token/refresh", `{"refresh_token":"`+second.RefreshToken+`"}`, nil)
+	expectStatus(t, rr, http.StatusUnauthorized)
+
+	rr = ta.do(t, http.MethodPost, "/v1/signout", "", http.Header{"Authorization": {"Bearer " + second.Token}})
+	expectStatus(t, rr, http.StatusUnauthorized)
+
+	rr = ta.do(t, http.MethodPost, "/v1/token/refresh", `{}`, nil)
+	expectStatus(t, rr, http.StatusBadRequest)
+}
+
+func TestSignout(t *testing.T) {
+	ta := newTestApp(t)
+	user := ta.addUser(t, "me@example.com", "password1", models.RoleViewer)
+	tokens := ta.tokens(t, user)
+	auth := http.Header{"Authorization": {"Bearer " + tokens.Token}}
+
+	rr := ta.do(t, http.MethodPost, "/v1/signout", "", auth)
+	expectStatus(t, rr, http.StatusOK)
+
+	// the access token dies with its session
+	rr = ta.do(t, http.MethodPost, "/v1/signout", "", auth)
+	expectStatus(t, rr, http.StatusUnauthorized)
+
+	rr = ta.do(t, http.MethodPost, "/v1/token/refresh", `{"refresh_token":"`+tokens.RefreshToken+`"}`, nil)
+	expectStatus(t, rr, http.StatusUnauthorized)
+}
+
+// TestCheckTokenRoute checks tokens on a real route, where a valid token
+// also needs its session to be live
+func TestCheckTokenRoute(t *testing.T) {
+	ta := newTestApp(t)
+	user := ta.addUser(t, "me@example.com", "password1", models.RoleViewer)
+	good := ta.tokens(t, user).Token
+
+	other := newTestApp(t)
+	other.config.jwt.issuer = "elsewhere"
+	foreign := other.tokens(t, other.addUser(t, "me@example.com", "password1", models.RoleViewer)).Token
+
+	tests := []struct {
+		name   string
+		header string
+		want   int
+	}{
+		{"valid", "Bearer " + good, http.StatusOK},
+		{"missing", "", http.StatusBadRequest},
+		{"not bearer", "Basic " + good, http.StatusUnauthorized},
+		{"bad signature", "Bearer " + good + "x", http.StatusForbidden},
+		{"other issuer", "Bearer " + foreign, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			if tt.header != "" {
+				header.Set("Authorization", tt.header)
+			}
+			rr := ta.do(t, http.MethodPost, "/v1/account/password",
+				`{"current_password":"password1","new_password":"password1"}`, header)
+			expectStatus(t, rr, tt.want)
+		})
+	}
+}
+
+// a signin for an unknown email must cost what a wrong password does
+func TestDummyHashCost(t *testing.T) {
+	hash, err := createHash("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := bcrypt.Cost([]byte(hash))
+
+	got, err := bcrypt.Cost([]byte(dummyHash))
+	if err != nil || got != want {
+		t.Errorf("got dummy hash cost %d, %v, want %d", got, err, want)
+	}
+}
+
+func TestRefreshTokenHash(t *testing.T) {
+	a, err := randomToken(32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := randomToken(32)
+	if a == b || len(a) != 43 {
+		t.Errorf("got tokens %q and %q, want two different 43 character tokens", a, b)
+	}
+
+	// only the hash is stored, and it must find the token again
+	if hashToken(a) != hashToken(a) || hashToken(a) == hashToken(b) || len(hashToken(a)) != 64 {
+		t.Errorf("got hashes %s and %s", hashToken(a), hashToken(b))
+	}
+}
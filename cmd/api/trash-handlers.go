@@ -0,0 +1,155 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cmd-ctrl-q/go-movies-server/models"
+	"github.com/julienschmidt/httprouter"
+)
+
+// purgeInterval is how often the trash is checked for expired movies
+const purgeInterval = time.Hour
+
+func (app *application) getTrash(w http.ResponseWriter, r *http.Request) {
+	movies, err := app.models.Movies.TrashedMovies()
+	if err != nil {
+		app.logger.Println("error getting trashed movies")
+		app.errorJSON(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, movies, "movies")
+	if err != nil {
+		app.logger.Println("error marshalling json response")
+		app.errorJSON(w, http.StatusInternalServerError, err)
+		return
+	}
+}
+
+func (app *application) restoreMovie(w http.ResponseWriter, r *http.Request) {
+	app.fromTrash(w, r, "restore", "movie restored")
+}
+
+func (app *application) purgeMovie(w http.ResponseWriter, r *http.Request) {
+	app.fromTrash(w, r, "purge", "movie purged")
+}
+
+// fromTrash restores or purges the movie named in the url
+func (app *application) fromTrash(w http.ResponseWriter, r *http.Request, action, message string) {
+	params := httprouter.ParamsFromContext(r.Context())
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil {
+		app.logger.Println("error converting string id to int")
+		app.errorJSON(w, http.StatusBadRequest, err)
+		return
+	}
+
+	err = app.models.WithTx(r.Context(), func(tx models.Models) error {
+		before, err := tx.Movies.GetTrashed(id)
+		if err != nil {
+			return err
+		}
+
+		var after *models.Movie
+		if action == "restore" {
+			err = tx.Movies.RestoreMovie(id)
+			if err != nil {
+				return err
+			}
+			after, err = tx.Movies.Get(id)
+		} else {
+			err = tx.Movies.PurgeMovie(id)
+		}
+		if err != nil {
+			return err
+		}
+
+		return app.audit(r.Context(), tx, "movie", action, id, auditMovie(before), auditMovie(after))
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, http.StatusNotFound, errors.New("no movie with that id in the trash"))
+		return
+	}
+	if err != nil {
+		app.logger.Println("error taking a movie out of the trash")
+		app.errorJSON(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	ok := jsonResponse{
+		OK:      true,
+		Message: message,
+	}
+
+	err = app.writeJSON(w, http.StatusOK, ok, "response")
+	if err != nil {
+		app.logger.Println("error marshalling json response")
+		app.errorJSON(w, http.StatusInternalServerError, err)
+		return
+	}
+}
+
+// purgeTrash calls purgeExpired every interval until the process exits.
+// Run it in its own goroutine
+func (app *application) purgeTrash(retention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		app.purgeExpired(retention)
+		<-ticker.C
+	}
+}
+
+// purgeExpired permanently deletes movies that have been in the trash
+// longer than the retention period. Each purge is audited as done by the
+// system user, in its own transaction
+func (app *application) purgeExpired(retention time.Duration) {
+	movies, err := app.models.Movies.TrashedMovies()
+	if err != nil {
+		app.logger.Println("error listing the trash:", err)
+		return
+	}
+
+	ctx := systemContext()
+	cutoff := time.Now().Add(-retention)
+	n := 0
+	for _, movie := range movies {
+		if !movie.DeletedAt.Before(cutoff) {
+			continue
+		}
+
+		err = app.models.WithTx(ctx, func(tx models.Models) error {
+			// it may have been restored, or trashed again, since the
+			// trash was listed
+			before, err := tx.Movies.GetTrashed(movie.ID)
+			if err != nil {
+				return err
+			}
+			if !before.DeletedAt.Before(cutoff) {
+				return sql.ErrNoRows
+			}
+
+			err = tx.Movies.PurgeMovie(movie.ID)
+			if err != nil {
+				return err
+			}
+			return app.audit(ctx, tx, "movie", "purge", movie.ID, auditMovie(before), nil)
+		})
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			app.logger.Println("error purging the trash:", err)
+			return
+		}
+		n++
+	}
+	if n > 0 {
+		app.logger.Printf("purged %d movies from the trash", n)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/cmd-ctrl-q/go-movies-server/models"
+)
+
+func TestTrash(t *testing.T) {
+	ta := newTestApp(t)
+	editor := ta.bearer(t, ta.addUser(t, "editor@example.com", "password1", models.RoleEditor))
+	admin := ta.bearer(t, ta.addUser(t, "admin@example.com", "password1", models.RoleAdmin))
+	drama := ta.addGenre(t, "Drama")
+	heat := ta.addMovie(t, "Heat", 1995, 4, drama)
+	ronin := ta.addMovie(t, "Ronin", 1998, 4)
+	ta.addMovie(t, "Casablanca", 1942, 5)
+
+	for _, id := range []int{heat, ronin} {
+		rr := ta.do(t, http.MethodGet, "/v1/admin/deletemovie/"+itoa(id), "", admin)
+		expectStatus(t, rr, http.StatusOK)
+	}
+
+	rr := ta.do(t, http.MethodGet, "/v1/admin/trash", "", editor)
+	expectStatus(t, rr, http.StatusForbidden)
+
+	rr = ta.do(t, http.MethodGet, "/v1/admin/trash", "", admin)
+	expectStatus(t, rr, http.StatusOK)
+	var trash struct {
+		Movies []*models.Movie `json:"movies"`
+	}
+	decode(t, rr, &trash)
+	if len(trash.Movies) != 2 || trash.Movies[0].DeletedAt == nil {
+		t.Fatalf("got trash %+v, want Heat and Ronin", trash.Movies)
+	}
+
+	// trashed movies are left out of listings and search
+	rr = ta.do(t, http.MethodGet, "/v1/movies", "", nil)
+	var list moviesResponse
+	decode(t, rr, &list)
+	if list.Metadata.Total != 1 {
+		t.Errorf("listed %d movies, want 1", list.Metadata.Total)
+	}
+	rr = ta.do(t, http.MethodGet, "/v1/search?q=heat", "", nil)
+	var search struct {
+		Results []*models.SearchResult `json:"results"`
+	}
+	decode(t, rr, &search)
+	if len(search.Results) != 0 {
+		t.Errorf("search found %d trashed movies", len(search.Results))
+	}
+
+	// a restored movie comes back with its genres
+	rr = ta.do(t, http.MethodPost, "/v1/admin/trash/"+itoa(heat)+"/restore", "", admin)
+	expectStatus(t, rr, http.StatusOK)
+	movie, err := ta.store.Get(heat)
+	if err != nil || len(movie.MovieGenre) != 1 {
+		t.Fatalf("got %+v, %v after restoring", movie, err)
+	}
+	rr = ta.do(t, http.MethodPost, "/v1/admin/trash/"+itoa(heat)+"/restore", "", admin)
+	expectStatus(t, rr, http.StatusNotFound)
+
+	// only movies in the trash can be purged
+	rr = ta.do(t, http.MethodPost, "/v1/admin/trash/"+itoa(heat)+"/purge", "", admin)
+	expectStatus(t, rr, http.StatusNotFound)
+	rr = ta.do(t, http.MethodPost, "/v1/admin/trash/"+itoa(ronin)+"/purge", "", admin)
+	expectStatus(t, rr, http.StatusOK)
+	trashed, _ := ta.store.TrashedMovies()
+	if len(trashed) != 0 {
+		t.Errorf("got %d trashed movies after purging, want 0", len(trashed))
+	}
+}
+
+func TestPurgeExpired(t *testing.T) {
+	ta := newTestApp(t)
+	id := ta.addMovie(t, "Heat", 1995, 4)
+	ta.addMovie(t, "Ronin", 1998, 4)
+
+	err := ta.store.DeleteMovie(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// movies trashed within the retention period are kept
+	ta.purgeExpired(time.Hour)
+	if trashed, _ := ta.store.TrashedMovies(); len(trashed) != 1 {
+		t.Fatalf("got %d trashed movies, want Heat kept", len(trashed))
+	}
+
+	time.Sleep(time.Millisecond)
+	ta.purgeExpired(time.Millisecond)
+	if trashed, _ := ta.store.TrashedMovies(); len(trashed) != 0 {
+		t.Errorf("got %d trashed movies, want Heat purged", len(trashed))
+	}
+	if movies, _ := ta.store.All(); len(movies) != 1 {
+		t.Errorf("got %d movies, want Ronin left", len(movies))
+	}
+
+	entries, _ := ta.store.AuditLog(models.AuditFilter{Entity: "movie", EntityID: id, Limit: 10})
+	if len(entries) != 1 || entries[0].Action != "purge" || entries[0].UserID != systemUserID {
+		t.Errorf("got audit %+v, want a purge by the system user", entries)
+	}
+}
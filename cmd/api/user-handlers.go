@@ -0,0 +1,147 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/mail"
+	"strings"
+
+	"github.com/cmd-ctrl-q/go-movies-server/models"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type PasswordPayload struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+func (app *application) Signup(w http.ResponseWriter, r *http.Request) {
+	var creds Credentials
+
+	err := json.NewDecoder(r.Body).Decode(&creds)
+	if err != nil {
+		app.logger.Println("error decoding signup:", err)
+		app.errorJSON(w, http.StatusBadRequest, err)
+		return
+	}
+
+	email := strings.TrimSpace(creds.Username)
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		app.errorJSON(w, http.StatusBadRequest, errors.New("invalid email address"))
+		return
+	}
+
+	err = app.config.password.validate(creds.Password)
+	if err != nil {
+		app.errorJSON(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// check the email is not taken
+	_, err = app.models.Users.GetUserByEmail(email)
+	if err == nil {
+		app.errorJSON(w, http.StatusConflict, models.ErrDuplicateEmail)
+		return
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		app.logger.Println("error getting user from db")
+		app.errorJSON(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	hash, err := createHash(creds.Password)
+	if err != nil {
+		app.logger.Println("error hashing password")
+		app.errorJSON(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	user := models.User{
+		Email:    email,
+		Password: hash,
+		Role:     models.RoleViewer,
+	}
+
+	user.ID, err = app.models.Users.InsertUser(user)
+	if errors.Is(err, models.ErrDuplicateEmail) {
+		// another signup took the email since the check above
+		app.errorJSON(w, http.StatusConflict, err)
+		return
+	}
+	if err != nil {
+		app.logger.Println("error inserting user to database")
+		app.errorJSON(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, user, "user")
+	if err != nil {
+		app.logger.Println("error marshalling json response")
+		app.errorJSON(w, http.StatusInternalServerError, err)
+		return
+	}
+}
+
+func (app *application) changePassword(w http.ResponseWriter, r *http.Request) {
+	var payload PasswordPayload
+
+	err := json.NewDecoder(r.Body).Decode(&payload)
+	if err != nil {
+		app.logger.Println("error decoding password change:", err)
+		app.errorJSON(w, http.StatusBadRequest, err)
+		return
+	}
+
+	p, found := principalFromContext(r.Context())
+	if !found {
+		app.errorJSON(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return
+	}
+
+	user, err := app.models.Users.GetUser(p.UserID)
+	if err != nil {
+		app.logger.Println("error getting user from db")
+		app.errorJSON(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(payload.CurrentPassword))
+	if err != nil {
+		app.errorJSON(w, http.StatusForbidden, errors.New("current password is incorrect"))
+		return
+	}
+
+	err = app.config.password.validate(payload.NewPassword)
+	if err != nil {
+		app.errorJSON(w, http.StatusBadRequest, err)
+		return
+	}
+
+	hash, err := createHash(payload.NewPassword)
+	if err != nil {
+		app.logger.Println("error hashing password")
+		app.errorJSON(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	err = app.models.Users.UpdatePassword(user.ID, hash)
+	if err != nil {
+		app.logger.Println("error updating password in database")
+		app.errorJSON(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	ok := jsonResponse{
+		OK:      true,
+		Message: "Password changed successfully",
+	}
+
+	err = app.writeJSON(w, http.StatusOK, ok, "response")
+	if err != nil {
+		app.errorJSON(w, http.StatusInternalServerError, err)
+		return
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/cmd-ctrl-q/go-movies-server/models"
+)
+
+func TestSignup(t *testing.T) {
+	ta := newTestApp(t)
+	ta.addUser(t, "taken@example.com", "password1", models.RoleViewer)
+
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"valid", `{"email":"new@example.com","password":"password1"}`, http.StatusCreated},
+		{"taken", `{"email":"TAKEN@example.com","password":"password1"}`, http.StatusConflict},
+		{"bad email", `{"email":"not an email","password":"password1"}`, http.StatusBadRequest},
+		{"short password", `{"email":"short@example.com","password":"pass1"}`, http.StatusBadRequest},
+		{"no digit", `{"email":"digit@example.com","password":"password"}`, http.StatusBadRequest},
+		{"bad json", `{`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := ta.do(t, http.MethodPost, "/v1/signup", tt.body, nil)
+			expectStatus(t, rr, tt.want)
+		})
+	}
+
+	user, err := ta.store.GetUserByEmail("new@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Role != models.RoleViewer || user.Password == "password1" {
+		t.Errorf("got role %q, password stored in the clear: %v", user.Role, user.Password == "password1")
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	ta := newTestApp(t)
+	user := ta.addUser(t, "me@example.com", "password1", models.RoleViewer)
+	auth := ta.bearer(t, user)
+
+	rr := ta.do(t, http.MethodPost, "/v1/account/password",
+		`{"current_password":"wrong","new_password":"password2"}`, auth)
+	expectStatus(t, rr, http.StatusForbidden)
+
+	rr = ta.do(t, http.MethodPost, "/v1/account/password",
+		`{"current_password":"password1","new_password":"weak"}`, auth)
+	expectStatus(t, rr, http.StatusBadRequest)
+
+	rr = ta.do(t, http.MethodPost, "/v1/account/password",
+		`{"current_password":"password1","new_password":"password2"}`, auth)
+	expectStatus(t, rr, http.StatusOK)
+
+	rr = ta.do(t, http.MethodPost, "/v1/signin", `{"email":"me@example.com","password":"password2"}`, nil)
+	expectStatus(t, rr, http.StatusOK)
+}
@@ -5,13 +5,16 @@ import (
 	"net/http"
 )
 
-func (app *application) writeJSON(w http.ResponseWriter, status int, data interface{}, wrap string) error {
-	wrapper := make(map[string]interface{})
+// envelope holds several top level fields of a json response
+type envelope map[string]interface{}
 
+func (app *application) writeJSON(w http.ResponseWriter, status int, data interface{}, wrap string) error {
 	// wrap data
-	wrapper[wrap] = data
+	return app.writeEnvelope(w, status, envelope{wrap: data})
+}
 
-	js, err := json.Marshal(wrapper)
+func (app *application) writeEnvelope(w http.ResponseWriter, status int, env envelope) error {
+	js, err := json.Marshal(env)
 	if err != nil {
 		return err
 	}
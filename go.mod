@@ -3,10 +3,11 @@ module github.com/cmd-ctrl-q/go-movies-server
 go 1.16
 
 require (
-	github.com/graphql-go/graphql v0.7.9 // indirect
+	github.com/graphql-go/graphql v0.7.9
 	github.com/julienschmidt/httprouter v1.3.0
 	github.com/justinas/alice v1.2.0
 	github.com/lib/pq v1.10.0
 	github.com/pascaldekloe/jwt v1.10.0
 	golang.org/x/crypto v0.0.0-20210711020723-a769d52b0f97
+	gopkg.in/yaml.v3 v3.0.1
 )
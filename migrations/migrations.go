@@ -0,0 +1,255 @@
+// Package migrations holds the versioned database schema and applies it.
+// Each version is a pair of files in sql/, NNNN_name.up.sql and
+// NNNN_name.down.sql, and applied versions are recorded in schema_migrations
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed sql/*.sql
+var files embed.FS
+
+// lockID is the postgres advisory lock held while migrating, so two
+// servers starting at once do not apply the same version twice
+const lockID = 7262104553
+
+// Migration is one version of the schema
+type Migration struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	AppliedAt *time.Time `json:"applied_at,omitempty"`
+	up        string
+	down      string
+}
+
+// All returns every embedded migration in version order
+func All() ([]*Migration, error) {
+	return load(files)
+}
+
+func load(fsys fs.FS) ([]*Migration, error) {
+	paths, err := fs.Glob(fsys, "sql/*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, path := range paths {
+		base := strings.TrimPrefix(path, "sql/")
+
+		var direction string
+		switch {
+		case strings.HasSuffix(base, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(base, ".down.sql"):
+			direction = "down"
+		default:
+			return nil, fmt.Errorf("migration %s is neither .up.sql nor .down.sql", base)
+		}
+
+		parts := strings.SplitN(strings.TrimSuffix(base, "."+direction+".sql"), "_", 2)
+		version, err := strconv.Atoi(parts[0])
+		if err != nil || len(parts) != 2 || version <= 0 {
+			return nil, fmt.Errorf("migration %s is not named NNNN_name", base)
+		}
+
+		body, err := fs.ReadFile(fsys, path)
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: parts[1]}
+			byVersion[version] = m
+		}
+		if m.Name != parts[1] {
+			return nil, fmt.Errorf("migration %d is named both %s and %s", version, m.Name, parts[1])
+		}
+
+		if direction == "up" {
+			m.up = string(body)
+		} else {
+			m.down = string(body)
+		}
+	}
+
+	var migrations []*Migration
+	for _, m := range byVersion {
+		if m.up == "" || m.down == "" {
+			return nil, fmt.Errorf("migration %04d_%s needs both an up and a down file", m.Version, m.Name)
+		}
+		migrations = append(migrations, m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
+
+// Up applies every pending migration and returns the ones it applied
+func Up(ctx context.Context, db *sql.DB) ([]*Migration, error) {
+	var applied []*Migration
+
+	err := withLock(ctx, db, func(conn *sql.Conn) error {
+		migrations, err := status(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, m := range migrations {
+			if m.AppliedAt != nil {
+				continue
+			}
+			err = apply(ctx, conn, m, m.up,
+				"insert into schema_migrations (version, name, applied_at) values ($1, $2, now())", m.Version, m.Name)
+			if err != nil {
+				return err
+			}
+			now := time.Now()
+			m.AppliedAt = &now
+			applied = append(applied, m)
+		}
+		return nil
+	})
+
+	return applied, err
+}
+
+// Down rolls back the latest steps applied migrations, newest first, and
+// returns the ones it rolled back
+func Down(ctx context.Context, db *sql.DB, steps int) ([]*Migration, error) {
+	var reverted []*Migration
+
+	err := withLock(ctx, db, func(conn *sql.Conn) error {
+		migrations, err := status(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(migrations) - 1; i >= 0 && len(reverted) < steps; i-- {
+			m := migrations[i]
+			if m.AppliedAt == nil {
+				continue
+			}
+			err = apply(ctx, conn, m, m.down, "delete from schema_migrations where version = $1", m.Version)
+			if err != nil {
+				return err
+			}
+			m.AppliedAt = nil
+			reverted = append(reverted, m)
+		}
+		return nil
+	})
+
+	return reverted, err
+}
+
+// Status returns every migration, with AppliedAt set on the applied ones
+func Status(ctx context.Context, db *sql.DB) ([]*Migration, error) {
+	var migrations []*Migration
+
+	err := withLock(ctx, db, func(conn *sql.Conn) error {
+		var err error
+		migrations, err = status(ctx, conn)
+		return err
+	})
+
+	return migrations, err
+}
+
+// withLock runs fn on a single connection holding the migration lock,
+// creating schema_migrations first if needed
+func withLock(ctx context.Context, db *sql.DB, fn func(conn *sql.Conn) error) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.ExecContext(ctx, "select pg_advisory_lock($1)", lockID)
+	if err != nil {
+		return err
+	}
+	defer conn.ExecContext(context.Background(), "select pg_advisory_unlock($1)", lockID)
+
+	_, err = conn.ExecContext(ctx, `
+		create table if not exists schema_migrations (
+			version bigint primary key,
+			name text not null,
+			applied_at timestamp not null default now()
+		)`)
+	if err != nil {
+		return err
+	}
+
+	return fn(conn)
+}
+
+// status loads the embedded migrations and marks the applied ones. A
+// version applied in the database but missing from the binary is an error,
+// since the binary could not roll it back
+func status(ctx context.Context, conn *sql.Conn) ([]*Migration, error) {
+	migrations, err := All()
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, m := range migrations {
+		byVersion[m.Version] = m
+	}
+
+	rows, err := conn.QueryContext(ctx, "select version, applied_at from schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		err = rows.Scan(&version, &appliedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			return nil, fmt.Errorf("database has migration %d, which this build does not know", version)
+		}
+		m.AppliedAt = &appliedAt
+	}
+
+	return migrations, rows.Err()
+}
+
+// apply runs one migration's sql and its schema_migrations bookkeeping
+// in a transaction
+func apply(ctx context.Context, conn *sql.Conn, m *Migration, body, record string, args ...interface{}) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, body)
+	if err != nil {
+		return fmt.Errorf("migration %04d_%s: %w", m.Version, m.Name, err)
+	}
+
+	_, err = tx.ExecContext(ctx, record, args...)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
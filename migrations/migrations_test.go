@@ -0,0 +1,32 @@
+package migrations
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestAll(t *testing.T) {
+	all, err := All()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, m := range all {
+		if m.Version != i+1 {
+			t.Errorf("migration %04d_%s: want version %d, versions must have no gaps", m.Version, m.Name, i+1)
+		}
+	}
+}
+
+func TestLoadRejectsUnpairedFiles(t *testing.T) {
+	fsys := fstest.MapFS{
+		"sql/0001_first.up.sql":   {Data: []byte("select 1")},
+		"sql/0001_first.down.sql": {Data: []byte("select 1")},
+		"sql/0002_second.up.sql":  {Data: []byte("select 1")},
+	}
+
+	_, err := load(fsys)
+	if err == nil {
+		t.Fatal("want an error for a migration without a down file")
+	}
+}
@@ -0,0 +1,34 @@
+-- "if not exists" lets databases created before migrations adopt them
+create table if not exists genres (
+	id serial primary key,
+	genre_name text not null,
+	created_at timestamp not null default now(),
+	updated_at timestamp not null default now()
+);
+
+create unique index if not exists genres_genre_name_key on genres (lower(genre_name));
+
+create table if not exists movies (
+	id serial primary key,
+	title text not null,
+	description text not null,
+	year integer not null,
+	release_date date not null,
+	runtime integer not null,
+	rating integer not null,
+	mpaa_rating text not null,
+	created_at timestamp not null default now(),
+	updated_at timestamp not null default now(),
+	poster text
+);
+
+create table if not exists movies_genres (
+	id serial primary key,
+	movie_id integer not null references movies (id) on delete cascade,
+	genre_id integer not null references genres (id),
+	created_at timestamp not null default now(),
+	updated_at timestamp not null default now()
+);
+
+create index if not exists movies_genres_movie_id_idx on movies_genres (movie_id);
+create index if not exists movies_genres_genre_id_idx on movies_genres (genre_id);
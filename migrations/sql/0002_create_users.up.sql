@@ -0,0 +1,11 @@
+-- "if not exists" lets databases created before migrations adopt them
+create table if not exists users (
+	id serial primary key,
+	email text not null,
+	password text not null,
+	role text not null default 'viewer' check (role in ('viewer', 'editor', 'admin')),
+	created_at timestamp not null default now(),
+	updated_at timestamp not null default now()
+);
+
+create unique index if not exists users_email_key on users (lower(email));
@@ -0,0 +1,12 @@
+create table if not exists refresh_tokens (
+	id serial primary key,
+	user_id integer not null references users (id) on delete cascade,
+	family_id text not null,
+	token_hash text not null unique,
+	expires_at timestamp not null,
+	used_at timestamp,
+	revoked_at timestamp,
+	created_at timestamp not null default now()
+);
+
+create index if not exists refresh_tokens_family_id_idx on refresh_tokens (family_id);
@@ -0,0 +1,6 @@
+create table if not exists login_attempts (
+	key text primary key,
+	failures integer not null default 0,
+	blocked_until timestamp,
+	updated_at timestamp not null default now()
+);
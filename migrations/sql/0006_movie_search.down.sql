@@ -0,0 +1,2 @@
+drop index if exists movies_title_trgm_idx;
+drop index if exists movies_search_idx;
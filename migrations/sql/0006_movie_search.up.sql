@@ -0,0 +1,8 @@
+create extension if not exists pg_trgm;
+
+-- must stay in step with searchDocument in models/movies-search.go
+create index if not exists movies_search_idx on movies using gin (
+	(setweight(to_tsvector('english', title), 'A') || setweight(to_tsvector('english', coalesce(description, '')), 'B'))
+);
+
+create index if not exists movies_title_trgm_idx on movies using gin (title gin_trgm_ops);
@@ -0,0 +1,5 @@
+-- movies still in the trash are deleted for good, as they would otherwise reappear
+delete from movies_genres where movie_id in (select id from movies where deleted_at is not null);
+delete from movies where deleted_at is not null;
+
+alter table movies drop column deleted_at;
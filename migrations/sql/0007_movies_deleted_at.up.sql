@@ -0,0 +1,3 @@
+alter table movies add column deleted_at timestamp;
+
+create index movies_deleted_at_idx on movies (deleted_at) where deleted_at is not null;
@@ -0,0 +1,15 @@
+-- no foreign keys, so the history outlives the users, keys and rows it mentions
+create table audit_log (
+	id serial primary key,
+	user_id integer not null,
+	api_key_id integer,
+	action text not null,
+	entity text not null,
+	entity_id integer not null,
+	changes jsonb not null default '{}',
+	created_at timestamp not null default now()
+);
+
+create index audit_log_entity_idx on audit_log (entity, entity_id, created_at);
+create index audit_log_user_id_idx on audit_log (user_id, created_at);
+create index audit_log_created_at_idx on audit_log (created_at);
@@ -0,0 +1,11 @@
+create table movie_revisions (
+	id serial primary key,
+	movie_id integer not null references movies (id) on delete cascade,
+	revision integer not null,
+	action text not null,
+	rolled_back_to integer,
+	snapshot jsonb not null,
+	created_by integer not null,
+	created_at timestamp not null default now(),
+	unique (movie_id, revision)
+);
@@ -0,0 +1,2 @@
+-- bumped by every change, so editors can detect concurrent edits
+alter table movies add column version integer not null default 1;
@@ -0,0 +1,183 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// InsertAPIKey stores a new api key and returns its id
+func (m *DBModel) InsertAPIKey(key APIKey) (int, error) {
+	ctx, cancel := m.timeout()
+	defer cancel()
+
+	stmt := `
+		insert into api_keys (name, prefix, key_hash, scopes, expires_at, created_by, created_at) 
+			values ($1, $2, $3, $4, $5, $6, $7)
+		returning id
+	`
+
+	var id int
+	err := m.DB.QueryRowContext(ctx, stmt,
+		key.Name,
+		key.Prefix,
+		key.KeyHash,
+		pq.Array(key.Scopes),
+		key.ExpiresAt,
+		key.CreatedBy,
+		time.Now(),
+	).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// GetAPIKeyByHash returns the api key with the given hash and error, if any
+func (m DBModel) GetAPIKeyByHash(hash string) (*APIKey, error) {
+	ctx, cancel := m.timeout()
+	defer cancel()
+
+	query := `
+		select 
+			id, name, prefix, key_hash, scopes, expires_at, revoked_at, last_used_at,
+			created_by, created_at
+		from 
+			api_keys 
+		where
+			key_hash = $1
+	`
+
+	return scanAPIKey(m.DB.QueryRowContext(ctx, query, hash))
+}
+
+// GetAPIKey returns one api key by id
+func (m DBModel) GetAPIKey(id int) (*APIKey, error) {
+	ctx, cancel := m.timeout()
+	defer cancel()
+
+	query := `
+		select 
+			id, name, prefix, key_hash, scopes, expires_at, revoked_at, last_used_at,
+			created_by, created_at
+		from 
+			api_keys 
+		where
+			id = $1
+	`
+
+	return scanAPIKey(m.DB.QueryRowContext(ctx, query, id))
+}
+
+// APIKeysAll returns all api keys, newest first
+func (m DBModel) APIKeysAll() ([]*APIKey, error) {
+	ctx, cancel := m.timeout()
+	defer cancel()
+
+	query := `
+		select 
+			id, name, prefix, key_hash, scopes, expires_at, revoked_at, last_used_at,
+			created_by, created_at
+		from 
+			api_keys 
+		order by
+			created_at desc
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []*APIKey
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+// RevokeAPIKey revokes an api key. sql.ErrNoRows is returned when
+// there is no active key with the given id
+func (m *DBModel) RevokeAPIKey(id int) error {
+	ctx, cancel := m.timeout()
+	defer cancel()
+
+	stmt := `
+		update 
+			api_keys 
+		set revoked_at = $1
+		where
+			id = $2 and revoked_at is null
+	`
+
+	res, err := m.DB.ExecContext(ctx, stmt, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// TouchAPIKey records that an api key was just used
+func (m *DBModel) TouchAPIKey(id int) error {
+	ctx, cancel := m.timeout()
+	defer cancel()
+
+	stmt := `
+		update 
+			api_keys 
+		set last_used_at = $1
+		where
+			id = $2
+	`
+
+	_, err := m.DB.ExecContext(ctx, stmt, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// scanner is implemented by both *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanAPIKey(row scanner) (*APIKey, error) {
+	var key APIKey
+
+	err := row.Scan(
+		&key.ID,
+		&key.Name,
+		&key.Prefix,
+		&key.KeyHash,
+		pq.Array(&key.Scopes),
+		&key.ExpiresAt,
+		&key.RevokedAt,
+		&key.LastUsedAt,
+		&key.CreatedBy,
+		&key.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &key, nil
+}
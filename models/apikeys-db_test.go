@@ -0,0 +1,66 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAPIKeys(t *testing.T) {
+	m := testDB(t)
+
+	userID, err := m.InsertUser(User{Email: "admin@example.com", Password: "hash", Role: RoleAdmin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expires := time.Now().UTC().Add(time.Hour).Truncate(time.Second)
+	id, err := m.InsertAPIKey(APIKey{
+		Name:      "ingest",
+		Prefix:    "gm_abcdef",
+		KeyHash:   "key hash",
+		Scopes:    []string{RoleEditor},
+		ExpiresAt: expires,
+		CreatedBy: userID,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := m.GetAPIKeyByHash("key hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.ID != id || len(key.Scopes) != 1 || key.Scopes[0] != RoleEditor || !key.ExpiresAt.Equal(expires) ||
+		key.RevokedAt != nil || key.LastUsedAt != nil || key.CreatedBy != userID {
+		t.Errorf("got %+v", key)
+	}
+	_, err = m.GetAPIKeyByHash("other hash")
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("got %v for an unknown key, want sql.ErrNoRows", err)
+	}
+
+	err = m.TouchAPIKey(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.RevokeAPIKey(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, _ = m.GetAPIKeyByHash("key hash")
+	if key.LastUsedAt == nil || key.RevokedAt == nil {
+		t.Errorf("got %+v, want it used and revoked", key)
+	}
+
+	// a key is revoked once
+	err = m.RevokeAPIKey(id)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("got %v revoking twice, want sql.ErrNoRows", err)
+	}
+
+	keys, err := m.APIKeysAll()
+	if err != nil || len(keys) != 1 {
+		t.Errorf("got %d keys, %v, want 1", len(keys), err)
+	}
+}
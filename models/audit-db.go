@@ -0,0 +1,120 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+)
+
+// InsertAudit records a change and returns the entry id
+func (m *DBModel) InsertAudit(entry AuditEntry) (int, error) {
+	ctx, cancel := m.timeout()
+	defer cancel()
+
+	changes, err := json.Marshal(entry.Changes)
+	if err != nil {
+		return 0, err
+	}
+
+	stmt := `
+		insert into audit_log (user_id, api_key_id, action, entity, entity_id, changes, created_at) 
+			values ($1, $2, $3, $4, $5, $6, $7)
+		returning id
+	`
+
+	var id int
+	err = m.DB.QueryRowContext(ctx, stmt,
+		entry.UserID,
+		entry.APIKeyID,
+		entry.Action,
+		entry.Entity,
+		entry.EntityID,
+		changes,
+		entry.CreatedAt,
+	).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// AuditLog returns the audit entries matching the filter, newest first
+func (m *DBModel) AuditLog(f AuditFilter) ([]*AuditEntry, error) {
+	ctx, cancel := m.timeout()
+	defer cancel()
+
+	var where []string
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if f.Entity != "" {
+		where = append(where, "entity = "+arg(f.Entity))
+	}
+	if f.EntityID > 0 {
+		where = append(where, "entity_id = "+arg(f.EntityID))
+	}
+	if f.UserID > 0 {
+		where = append(where, "user_id = "+arg(f.UserID))
+	}
+	if !f.From.IsZero() {
+		where = append(where, "created_at >= "+arg(f.From))
+	}
+	if !f.To.IsZero() {
+		where = append(where, "created_at < "+arg(f.To))
+	}
+
+	query := fmt.Sprintf(`
+		select 
+			id, user_id, api_key_id, action, entity, entity_id, changes, created_at
+		from 
+			audit_log 
+		%s
+		order by 
+			created_at desc, id desc
+		limit %s offset %s
+	`, whereClause(where), arg(f.Limit), arg(f.Offset))
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []*AuditEntry{}
+
+	for rows.Next() {
+		var entry AuditEntry
+		var apiKeyID sql.NullInt64
+		var changes []byte
+		err := rows.Scan(
+			&entry.ID,
+			&entry.UserID,
+			&apiKeyID,
+			&entry.Action,
+			&entry.Entity,
+			&entry.EntityID,
+			&changes,
+			&entry.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		if apiKeyID.Valid {
+			id := int(apiKeyID.Int64)
+			entry.APIKeyID = &id
+		}
+		err = json.Unmarshal(changes, &entry.Changes)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, &entry)
+	}
+
+	return entries, rows.Err()
+}
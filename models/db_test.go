@@ -0,0 +1,49 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/cmd-ctrl-q/go-movies-server/migrations"
+	_ "github.com/lib/pq"
+)
+
+// testDB connects to the postgres named by GO_MOVIES_TEST_DSN and migrates
+// a schema of its own to the latest version. The pool is held to a single
+// connection whose search_path starts with that schema, and the schema is
+// dropped afterwards, so nothing outside the test is touched
+func testDB(tb testing.TB) DBModel {
+	dsn := os.Getenv("GO_MOVIES_TEST_DSN")
+	if dsn == "" {
+		tb.Skip("GO_MOVIES_TEST_DSN not set")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+
+	schema := fmt.Sprintf("test_%d", time.Now().UnixNano())
+	tb.Cleanup(func() {
+		db.Exec("drop schema if exists " + schema + " cascade")
+		db.Close()
+	})
+
+	for _, stmt := range []string{"create schema " + schema, "set search_path to " + schema + ", public"} {
+		if _, err := db.Exec(stmt); err != nil {
+			tb.Fatal(err)
+		}
+	}
+
+	_, err = migrations.Up(context.Background(), db)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	return DBModel{DB: db}
+}
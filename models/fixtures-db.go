@@ -0,0 +1,208 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Seed upserts the genres and movies of a fixture, and the links between
+// them, in one transaction. Rows that already match are left untouched
+func (m *DBModel) Seed(f *Fixture) (SeedStats, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	var stats SeedStats
+	err := m.WithTx(ctx, func(tx DBModel) error {
+		stats = SeedStats{}
+		now := time.Now()
+
+		genreIDs := make(map[string]int)
+		for _, name := range f.genreNames() {
+			id, created, updated, err := tx.upsertGenre(ctx, name, now)
+			if err != nil {
+				return err
+			}
+			genreIDs[strings.ToLower(name)] = id
+			if created {
+				stats.GenresCreated++
+			} else if updated {
+				stats.GenresUpdated++
+			}
+		}
+
+		for _, fm := range f.Movies {
+			movie, err := fm.movie(now)
+			if err != nil {
+				return err
+			}
+
+			id, created, updated, err := tx.upsertMovie(ctx, movie)
+			if err != nil {
+				return err
+			}
+
+			if fm.Genres != nil {
+				ids := make([]int, 0, len(fm.Genres))
+				for _, name := range fm.Genres {
+					ids = append(ids, genreIDs[strings.ToLower(strings.TrimSpace(name))])
+				}
+
+				current, err := tx.movieGenreIDs(ctx, id)
+				if err != nil {
+					return err
+				}
+				if !sameInts(current, ids) {
+					err = tx.setMovieGenres(ctx, id, ids)
+					if err != nil {
+						return err
+					}
+					updated = true
+				}
+			}
+
+			if created {
+				stats.MoviesCreated++
+			} else if updated {
+				stats.MoviesUpdated++
+			}
+		}
+
+		return nil
+	})
+
+	return stats, err
+}
+
+// upsertGenre stores a genre by name. A genre differing only in case is
+// renamed to the given spelling
+func (m DBModel) upsertGenre(ctx context.Context, name string, now time.Time) (id int, created, updated bool, err error) {
+	stmt := `
+		insert into genres (genre_name, created_at, updated_at)
+			values ($1, $2, $2)
+		on conflict ((lower(genre_name))) do update
+			set genre_name = excluded.genre_name, updated_at = excluded.updated_at
+			where genres.genre_name <> excluded.genre_name
+		returning id, xmax = 0
+	`
+
+	err = m.DB.QueryRowContext(ctx, stmt, name, now).Scan(&id, &created)
+	if errors.Is(err, sql.ErrNoRows) {
+		// already stored as is
+		err = m.DB.QueryRowContext(ctx, `select id from genres where lower(genre_name) = lower($1)`, name).Scan(&id)
+		return id, false, false, err
+	}
+	if err != nil {
+		return 0, false, false, err
+	}
+
+	return id, created, !created, nil
+}
+
+// upsertMovie stores a movie by title, ignoring case, and year, updating
+// the other columns when they differ. Titles and years are not unique, so
+// when several movies match the oldest one is updated. Movies in the trash
+// are left there untouched. Call it inside WithTx
+func (m DBModel) upsertMovie(ctx context.Context, movie Movie) (id int, created, updated bool, err error) {
+	query := `
+		select 
+			id, title, description, release_date, runtime, rating, mpaa_rating, coalesce(poster, '')
+		from 
+			movies 
+		where
+			lower(title) = lower($1) and year = $2 and deleted_at is null
+		order by 
+			id
+		limit 1
+		for update
+	`
+
+	var stored Movie
+	err = m.DB.QueryRowContext(ctx, query, movie.Title, movie.Year).Scan(
+		&stored.ID,
+		&stored.Title,
+		&stored.Description,
+		&stored.ReleaseDate,
+		&stored.Runtime,
+		&stored.Rating,
+		&stored.MPAARating,
+		&stored.Poster,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		stmt := `
+			insert into movies (title, description, year, release_date, runtime,
+				rating, mpaa_rating, created_at, updated_at, poster)
+				values ($1, $2, $3, $4, $5, $6, $7, $8, $8, $9)
+			returning id
+		`
+
+		err = m.DB.QueryRowContext(ctx, stmt,
+			movie.Title,
+			movie.Description,
+			movie.Year,
+			movie.ReleaseDate,
+			movie.Runtime,
+			movie.Rating,
+			movie.MPAARating,
+			movie.CreatedAt,
+			movie.Poster,
+		).Scan(&id)
+		return id, err == nil, false, err
+	}
+	if err != nil {
+		return 0, false, false, err
+	}
+
+	if sameMovie(&stored, &movie) {
+		return stored.ID, false, false, nil
+	}
+
+	stmt := `
+		update 
+			movies 
+		set title = $1, description = $2, release_date = $3, runtime = $4,
+			rating = $5, mpaa_rating = $6, poster = $7, updated_at = $8,
+			version = version + 1
+		where
+			id = $9
+	`
+
+	_, err = m.DB.ExecContext(ctx, stmt,
+		movie.Title,
+		movie.Description,
+		movie.ReleaseDate,
+		movie.Runtime,
+		movie.Rating,
+		movie.MPAARating,
+		movie.Poster,
+		movie.UpdatedAt,
+		stored.ID,
+	)
+	if err != nil {
+		return 0, false, false, err
+	}
+
+	return stored.ID, false, true, nil
+}
+
+// movieGenreIDs returns the ids of the genres a movie is linked to
+func (m DBModel) movieGenreIDs(ctx context.Context, movieID int) ([]int, error) {
+	rows, err := m.DB.QueryContext(ctx, `select genre_id from movies_genres where movie_id = $1`, movieID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
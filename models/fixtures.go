@@ -0,0 +1,190 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Fixture is seed data for movies and genres. Genres are identified by
+// name, case-insensitively, and movies by title and year, so seeding the
+// same fixture twice changes nothing
+type Fixture struct {
+	Genres []string       `json:"genres" yaml:"genres"`
+	Movies []FixtureMovie `json:"movies" yaml:"movies"`
+}
+
+// FixtureMovie is a movie in a fixture. Genres lists genre names; a nil
+// list leaves the genres of an existing movie alone
+type FixtureMovie struct {
+	Title       string   `json:"title" yaml:"title"`
+	Description string   `json:"description" yaml:"description"`
+	Year        int      `json:"year" yaml:"year"`
+	ReleaseDate string   `json:"release_date" yaml:"release_date"`
+	Runtime     int      `json:"runtime" yaml:"runtime"`
+	Rating      int      `json:"rating" yaml:"rating"`
+	MPAARating  string   `json:"mpaa_rating" yaml:"mpaa_rating"`
+	Poster      string   `json:"poster" yaml:"poster"`
+	Genres      []string `json:"genres" yaml:"genres"`
+}
+
+// SeedStats counts what seeding a fixture changed
+type SeedStats struct {
+	GenresCreated int `json:"genres_created"`
+	GenresUpdated int `json:"genres_updated"`
+	MoviesCreated int `json:"movies_created"`
+	MoviesUpdated int `json:"movies_updated"`
+}
+
+// ReadFixture reads a .json, .yaml or .yml fixture file
+func ReadFixture(path string) (*Fixture, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".json":
+		return ParseFixture(data, "json")
+	case ".yaml", ".yml":
+		return ParseFixture(data, "yaml")
+	default:
+		return nil, fmt.Errorf("fixture %s: unknown format %q", path, ext)
+	}
+}
+
+// ParseFixture decodes a json or yaml fixture and checks it. Unknown fields
+// are rejected so that typos do not silently drop data
+func ParseFixture(data []byte, format string) (*Fixture, error) {
+	var f Fixture
+
+	switch format {
+	case "json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&f); err != nil {
+			return nil, err
+		}
+	case "yaml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(&f); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown fixture format %q", format)
+	}
+
+	if err := f.check(); err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// check rejects fixtures that cannot be seeded
+func (f *Fixture) check() error {
+	for _, name := range f.Genres {
+		if strings.TrimSpace(name) == "" {
+			return errors.New("fixture has a genre without a name")
+		}
+	}
+
+	for i, fm := range f.Movies {
+		if strings.TrimSpace(fm.Title) == "" {
+			return fmt.Errorf("fixture movie %d has no title", i+1)
+		}
+		if _, err := fm.movie(time.Now()); err != nil {
+			return fmt.Errorf("fixture movie %q: %w", fm.Title, err)
+		}
+		for _, name := range fm.Genres {
+			if strings.TrimSpace(name) == "" {
+				return fmt.Errorf("fixture movie %q has a genre without a name", fm.Title)
+			}
+		}
+	}
+
+	return nil
+}
+
+// genreNames returns every genre the fixture names, including those only
+// listed on movies, without case-insensitive duplicates
+func (f *Fixture) genreNames() []string {
+	seen := make(map[string]bool)
+	var names []string
+	add := func(name string) {
+		name = strings.TrimSpace(name)
+		if !seen[strings.ToLower(name)] {
+			seen[strings.ToLower(name)] = true
+			names = append(names, name)
+		}
+	}
+
+	for _, name := range f.Genres {
+		add(name)
+	}
+	for _, fm := range f.Movies {
+		for _, name := range fm.Genres {
+			add(name)
+		}
+	}
+	return names
+}
+
+// movie converts a fixture movie. The year defaults to the release year
+func (fm FixtureMovie) movie(now time.Time) (Movie, error) {
+	releaseDate, err := time.Parse("2006-01-02", fm.ReleaseDate)
+	if err != nil {
+		return Movie{}, fmt.Errorf("release_date must be YYYY-MM-DD: %w", err)
+	}
+
+	year := fm.Year
+	if year == 0 {
+		year = releaseDate.Year()
+	}
+
+	return Movie{
+		Title:       strings.TrimSpace(fm.Title),
+		Description: fm.Description,
+		Year:        year,
+		ReleaseDate: releaseDate,
+		Runtime:     fm.Runtime,
+		Rating:      fm.Rating,
+		MPAARating:  fm.MPAARating,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+		Poster:      fm.Poster,
+	}, nil
+}
+
+// sameMovie reports whether seeding movie over stored would change none
+// of the columns a fixture sets
+func sameMovie(stored, movie *Movie) bool {
+	return stored.Title == movie.Title && stored.Description == movie.Description &&
+		stored.ReleaseDate.Equal(movie.ReleaseDate) && stored.Runtime == movie.Runtime &&
+		stored.Rating == movie.Rating && stored.MPAARating == movie.MPAARating &&
+		stored.Poster == movie.Poster
+}
+
+// sameInts reports whether a and b hold the same ids, ignoring order and
+// duplicates
+func sameInts(a, b []int) bool {
+	set := make(map[int]bool)
+	for _, id := range a {
+		set[id] = true
+	}
+	other := make(map[int]bool)
+	for _, id := range b {
+		if !set[id] {
+			return false
+		}
+		other[id] = true
+	}
+	return len(set) == len(other)
+}
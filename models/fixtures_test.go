@@ -0,0 +1,179 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+const yamlFixture = `
+genres: [Drama]
+movies:
+  - title: The Godfather
+    description: A crime dynasty
+    release_date: "1972-03-24"
+    runtime: 175
+    rating: 5
+    mpaa_rating: R
+    genres: [drama, Crime]
+`
+
+const jsonFixture = `{
+	"genres": ["Drama"],
+	"movies": [{
+		"title": "The Godfather",
+		"description": "A crime dynasty",
+		"release_date": "1972-03-24",
+		"runtime": 175,
+		"rating": 5,
+		"mpaa_rating": "R",
+		"genres": ["drama", "Crime"]
+	}]
+}`
+
+func TestParseFixture(t *testing.T) {
+	fromYAML, err := ParseFixture([]byte(yamlFixture), "yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromJSON, err := ParseFixture([]byte(jsonFixture), "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(fromYAML, fromJSON) {
+		t.Errorf("yaml and json fixtures differ:\n%+v\n%+v", fromYAML, fromJSON)
+	}
+
+	bad := []string{
+		`{"movies": [{"title": "x", "release_date": "24/03/1972"}]}`,
+		`{"movies": [{"release_date": "1972-03-24"}]}`,
+		`{"movies": [{"title": "x", "release_date": "1972-03-24", "raiting": 5}]}`,
+	}
+	for _, data := range bad {
+		if _, err := ParseFixture([]byte(data), "json"); err == nil {
+			t.Errorf("want an error for %s", data)
+		}
+	}
+}
+
+func TestReadFixture(t *testing.T) {
+	f, err := ReadFixture("../fixtures/movies.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewMemoryModel()
+	stats, err := m.Seed(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.MoviesCreated != len(f.Movies) {
+		t.Errorf("created %d movies, want %d", stats.MoviesCreated, len(f.Movies))
+	}
+}
+
+func TestMemorySeed(t *testing.T) {
+	testSeed(t, NewMemoryModel())
+}
+
+func TestDBSeed(t *testing.T) {
+	m := testDB(t)
+	testSeed(t, &m)
+}
+
+// testSeed seeds yamlFixture into an empty store
+func testSeed(t *testing.T, m interface {
+	Seeder
+	MovieStore
+}) {
+	f, err := ParseFixture([]byte(yamlFixture), "yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := m.Seed(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := SeedStats{GenresCreated: 2, MoviesCreated: 1}
+	if stats != want {
+		t.Errorf("first seed: got %+v, want %+v", stats, want)
+	}
+
+	movies, _ := m.All()
+	if len(movies) != 1 || movies[0].Year != 1972 || len(movies[0].MovieGenre) != 2 {
+		t.Fatalf("got %+v, want The Godfather from 1972 in two genres", movies)
+	}
+
+	// seeding again changes nothing
+	stats, err = m.Seed(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats != (SeedStats{}) {
+		t.Errorf("second seed: got %+v, want no changes", stats)
+	}
+
+	// changed fields and genres update the movie in place
+	f.Movies[0].Rating = 4
+	f.Movies[0].Genres = []string{"Drama"}
+	stats, err = m.Seed(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats != (SeedStats{MoviesUpdated: 1}) {
+		t.Errorf("third seed: got %+v, want one movie updated", stats)
+	}
+
+	movie, _ := m.Get(movies[0].ID)
+	if movie.Rating != 4 || len(movie.MovieGenre) != 1 {
+		t.Errorf("got %+v, want rating 4 in one genre", movie)
+	}
+
+	// titles and years are not unique, and seeding updates the oldest match
+	// without touching movies that only share the title
+	remake := *movie
+	remake.Year = 1990
+	remakeID, err := m.InsertMovie(remake, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	twin := *movie
+	twinID, err := m.InsertMovie(twin, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.Movies[0].Rating = 3
+	stats, err = m.Seed(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats != (SeedStats{MoviesUpdated: 1}) {
+		t.Errorf("fourth seed: got %+v, want one movie updated", stats)
+	}
+	for id, want := range map[int]int{movie.ID: 3, remakeID: 4, twinID: 4} {
+		got, err := m.Get(id)
+		if err != nil || got.Rating != want {
+			t.Errorf("movie %d: got %+v, %v, want rating %d", id, got, err, want)
+		}
+	}
+
+	// movies in the trash are not matched, so seeding creates a new one
+	for _, id := range []int{movie.ID, twinID} {
+		err := m.DeleteMovie(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	stats, err = m.Seed(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats != (SeedStats{MoviesCreated: 1}) {
+		t.Errorf("fifth seed: got %+v, want one movie created", stats)
+	}
+	trashed, _ := m.TrashedMovies()
+	if len(trashed) != 2 {
+		t.Errorf("got %d trashed movies, want 2 left untouched", len(trashed))
+	}
+}
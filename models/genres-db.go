@@ -0,0 +1,245 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var (
+	// ErrDuplicateGenre is returned when a genre name is already taken
+	ErrDuplicateGenre = errors.New("a genre with that name already exists")
+	// ErrGenreInUse is returned when deleting a genre that movies still use
+	ErrGenreInUse = errors.New("genre is still assigned to movies")
+)
+
+// GetGenre returns one genre and error, if any
+func (m DBModel) GetGenre(id int) (*Genre, error) {
+	ctx, cancel := m.timeout()
+	defer cancel()
+
+	query := `
+		select 
+			id, genre_name, created_at, updated_at
+		from 
+			genres
+		where
+			id = $1
+	`
+
+	var genre Genre
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&genre.ID,
+		&genre.GenreName,
+		&genre.CreatedAt,
+		&genre.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &genre, nil
+}
+
+// MovieGenres returns the genres of each of the given movies, sorted by
+// name, with a single query. Every movie id gets an entry, empty when it
+// has no genres
+func (m DBModel) MovieGenres(movieIDs []int) (map[int][]*Genre, error) {
+	ctx, cancel := m.timeout()
+	defer cancel()
+
+	byMovie := make(map[int][]*Genre, len(movieIDs))
+	for _, id := range movieIDs {
+		byMovie[id] = []*Genre{}
+	}
+	if len(movieIDs) == 0 {
+		return byMovie, nil
+	}
+
+	query := `
+		select 
+			mg.movie_id, g.id, g.genre_name, g.created_at, g.updated_at
+		from 
+			movies_genres mg
+			join genres g on (g.id = mg.genre_id)
+		where
+			mg.movie_id = any($1)
+		order by
+			g.genre_name
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(movieIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var movieID int
+		var genre Genre
+		err := rows.Scan(
+			&movieID,
+			&genre.ID,
+			&genre.GenreName,
+			&genre.CreatedAt,
+			&genre.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		byMovie[movieID] = append(byMovie[movieID], &genre)
+	}
+
+	return byMovie, rows.Err()
+}
+
+// InsertGenre stores a new genre and returns its id
+func (m *DBModel) InsertGenre(genre Genre) (int, error) {
+	ctx, cancel := m.timeout()
+	defer cancel()
+
+	err := m.checkGenreName(ctx, genre.GenreName, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	stmt := `
+		insert into genres (genre_name, created_at, updated_at) 
+			values ($1, $2, $3)
+		returning id
+	`
+
+	var id int
+	err = m.DB.QueryRowContext(ctx, stmt, genre.GenreName, time.Now(), time.Now()).Scan(&id)
+	if err != nil {
+		return 0, genreError(err)
+	}
+
+	return id, nil
+}
+
+// UpdateGenre renames a genre. sql.ErrNoRows is returned when it does not exist
+func (m *DBModel) UpdateGenre(genre Genre) error {
+	ctx, cancel := m.timeout()
+	defer cancel()
+
+	err := m.checkGenreName(ctx, genre.GenreName, genre.ID)
+	if err != nil {
+		return err
+	}
+
+	stmt := `
+		update 
+			genres 
+		set genre_name = $1, updated_at = $2
+		where
+			id = $3
+	`
+
+	res, err := m.DB.ExecContext(ctx, stmt, genre.GenreName, time.Now(), genre.ID)
+	if err != nil {
+		return genreError(err)
+	}
+
+	return expectRow(res)
+}
+
+// DeleteGenre deletes a genre. Unless cascade is set, genres still linked
+// to movies are refused with ErrGenreInUse; with it the links go too and
+// the movies that had them count as updated.
+// sql.ErrNoRows is returned when the genre does not exist
+func (m *DBModel) DeleteGenre(id int, cascade bool) error {
+	ctx, cancel := m.timeout()
+	defer cancel()
+
+	return m.WithTx(ctx, func(tx DBModel) error {
+		if cascade {
+			stmt := `
+				update 
+					movies 
+				set updated_at = $1, version = version + 1
+				where
+					id in (select movie_id from movies_genres where genre_id = $2)
+			`
+
+			_, err := tx.DB.ExecContext(ctx, stmt, time.Now(), id)
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.DB.ExecContext(ctx, `delete from movies_genres where genre_id = $1`, id)
+			if err != nil {
+				return err
+			}
+		} else {
+			var used bool
+			err := tx.DB.QueryRowContext(ctx,
+				`select exists (select 1 from movies_genres where genre_id = $1)`, id).Scan(&used)
+			if err != nil {
+				return err
+			}
+			if used {
+				return ErrGenreInUse
+			}
+		}
+
+		res, err := tx.DB.ExecContext(ctx, `delete from genres where id = $1`, id)
+		if err != nil {
+			return genreError(err)
+		}
+
+		return expectRow(res)
+	})
+}
+
+// checkGenreName returns ErrDuplicateGenre when another genre already has
+// the name, ignoring case
+func (m DBModel) checkGenreName(ctx context.Context, name string, id int) error {
+	query := `
+		select exists (
+			select 1 from genres where lower(genre_name) = lower($1) and id <> $2
+		)
+	`
+
+	var taken bool
+	err := m.DB.QueryRowContext(ctx, query, strings.TrimSpace(name), id).Scan(&taken)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return ErrDuplicateGenre
+	}
+
+	return nil
+}
+
+// genreError maps constraint violations on genres to model errors,
+// for writes that race past checkGenreName
+func genreError(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code {
+		case "23505": // unique_violation
+			return ErrDuplicateGenre
+		case "23503": // foreign_key_violation
+			return ErrGenreInUse
+		}
+	}
+	return err
+}
+
+// expectRow returns sql.ErrNoRows when a statement changed nothing
+func expectRow(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+)
+
+func TestGenres(t *testing.T) {
+	m := benchDB(t)
+
+	id, err := m.InsertGenre(Genre{GenreName: "Western"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.InsertGenre(Genre{GenreName: "WESTERN"})
+	if !errors.Is(err, ErrDuplicateGenre) {
+		t.Errorf("got %v inserting a duplicate, want ErrDuplicateGenre", err)
+	}
+	err = m.UpdateGenre(Genre{ID: id, GenreName: "genre 1"})
+	if !errors.Is(err, ErrDuplicateGenre) {
+		t.Errorf("got %v renaming to a taken name, want ErrDuplicateGenre", err)
+	}
+	err = m.UpdateGenre(Genre{ID: 999, GenreName: "Noir"})
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("got %v renaming an unknown genre, want sql.ErrNoRows", err)
+	}
+
+	// unused genres are deleted outright
+	err = m.DeleteGenre(id, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.DeleteGenre(id, false)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("got %v deleting twice, want sql.ErrNoRows", err)
+	}
+
+	// genres in use need a cascade, which counts their movies as updated
+	err = m.DeleteGenre(1, false)
+	if !errors.Is(err, ErrGenreInUse) {
+		t.Fatalf("got %v, want ErrGenreInUse", err)
+	}
+	_, err = m.DB.ExecContext(context.Background(), "update movies set updated_at = '2000-01-01'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.DeleteGenre(1, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var links, stale, bumped int
+	m.DB.QueryRowContext(context.Background(), "select count(*) from movies_genres where genre_id = 1").Scan(&links)
+	m.DB.QueryRowContext(context.Background(), "select count(*) from movies where updated_at = '2000-01-01'").Scan(&stale)
+	m.DB.QueryRowContext(context.Background(), "select count(*) from movies where version = 2").Scan(&bumped)
+	if links != 0 {
+		t.Errorf("%d links left to the deleted genre", links)
+	}
+	if stale == 0 || stale == benchMovies {
+		t.Errorf("%d of %d movies left untouched, want only those without the genre", stale, benchMovies)
+	}
+	if bumped != benchMovies-stale {
+		t.Errorf("%d movies got a new version, want the %d updated", bumped, benchMovies-stale)
+	}
+}
@@ -0,0 +1,131 @@
+package models
+
+import (
+	"time"
+)
+
+// ReserveLoginAttempt checks and counts a signin for key in one step, so
+// concurrent signins cannot all slip past a block. A blocked key is
+// returned unchanged with ok false. Otherwise the signin is counted as a
+// failure up front, failures recorded before since are forgotten, the key
+// is blocked until block(failures), and ok is true. Clear the key with
+// ClearLoginAttempts when the signin succeeds
+func (m *DBModel) ReserveLoginAttempt(key string, since time.Time, block func(failures int) time.Time) (*LoginAttempt, bool, error) {
+	ctx, cancel := m.timeout()
+	defer cancel()
+
+	var attempt LoginAttempt
+	var ok bool
+
+	err := m.WithTx(ctx, func(tx DBModel) error {
+		now := time.Now()
+
+		_, err := tx.DB.ExecContext(ctx, `
+			insert into login_attempts (key, updated_at) 
+				values ($1, $2)
+			on conflict (key) do nothing
+		`, key, now)
+		if err != nil {
+			return err
+		}
+
+		// the row lock makes concurrent signins for key wait their turn
+		query := `
+			select 
+				key, failures, coalesce(blocked_until, 'epoch'), updated_at
+			from 
+				login_attempts 
+			where
+				key = $1
+			for update
+		`
+
+		err = tx.DB.QueryRowContext(ctx, query, key).Scan(
+			&attempt.Key,
+			&attempt.Failures,
+			&attempt.BlockedUntil,
+			&attempt.UpdatedAt,
+		)
+		if err != nil {
+			return err
+		}
+		if now.Before(attempt.BlockedUntil) {
+			return nil
+		}
+
+		if attempt.UpdatedAt.Before(since) {
+			attempt.Failures = 0
+		}
+		attempt.Failures++
+		attempt.UpdatedAt = now
+		attempt.BlockedUntil = block(attempt.Failures)
+
+		var blockedUntil interface{}
+		if !attempt.BlockedUntil.IsZero() {
+			blockedUntil = attempt.BlockedUntil
+		}
+
+		stmt := `
+			update 
+				login_attempts 
+			set failures = $1, blocked_until = $2, updated_at = $3
+			where
+				key = $4
+		`
+
+		_, err = tx.DB.ExecContext(ctx, stmt, attempt.Failures, blockedUntil, now, key)
+		if err != nil {
+			return err
+		}
+
+		ok = true
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	return &attempt, ok, nil
+}
+
+// ClearLoginAttempts forgets the failed signins of key
+func (m *DBModel) ClearLoginAttempts(key string) error {
+	ctx, cancel := m.timeout()
+	defer cancel()
+
+	stmt := `
+		delete from 
+			login_attempts 
+		where 
+			key = $1
+	`
+
+	_, err := m.DB.ExecContext(ctx, stmt, key)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// PruneLoginAttempts deletes the keys that have not failed since before
+// and are not blocked, and returns how many there were
+func (m *DBModel) PruneLoginAttempts(before time.Time) (int, error) {
+	ctx, cancel := m.timeout()
+	defer cancel()
+
+	stmt := `
+		delete from 
+			login_attempts 
+		where 
+			updated_at < $1 and (blocked_until is null or blocked_until < $2)
+	`
+
+	res, err := m.DB.ExecContext(ctx, stmt, before, time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := res.RowsAffected()
+	return int(n), err
+}
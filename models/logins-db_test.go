@@ -0,0 +1,55 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoginAttempts(t *testing.T) {
+	m := testDB(t)
+
+	// the second failure blocks the key for a minute
+	block := func(failures int) time.Time {
+		if failures < 2 {
+			return time.Time{}
+		}
+		return time.Now().Add(time.Minute)
+	}
+	since := time.Now().Add(-time.Hour)
+
+	for i, want := range []struct {
+		ok       bool
+		failures int
+	}{{true, 1}, {true, 2}, {false, 2}} {
+		attempt, ok, err := m.ReserveLoginAttempt("ip:10.0.0.1", since, block)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != want.ok || attempt.Failures != want.failures {
+			t.Errorf("reserve %d: got ok %v with %d failures, want %v with %d", i+1, ok, attempt.Failures, want.ok, want.failures)
+		}
+	}
+
+	err := m.ClearLoginAttempts("ip:10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	attempt, ok, err := m.ReserveLoginAttempt("ip:10.0.0.1", since, block)
+	if err != nil || !ok || attempt.Failures != 1 {
+		t.Errorf("got %+v, %v, %v after clearing", attempt, ok, err)
+	}
+
+	// only keys that are stale and not blocked are pruned
+	_, _, err = m.ReserveLoginAttempt("email:a@example.com", since, block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = m.ReserveLoginAttempt("email:a@example.com", since, block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := m.PruneLoginAttempts(time.Now().Add(time.Second))
+	if err != nil || n != 1 {
+		t.Errorf("pruned %d, %v, want 1", n, err)
+	}
+}
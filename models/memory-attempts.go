@@ -0,0 +1,77 @@
+package models
+
+import (
+	"sync"
+	"time"
+)
+
+// MemoryAttempts keeps failed signin counts local to one instance
+type MemoryAttempts struct {
+	mu        sync.Mutex
+	attempts  map[string]*LoginAttempt
+	window    time.Duration
+	lastPrune time.Time
+}
+
+// NewMemoryAttempts returns a MemoryAttempts forgetting failures older than window
+func NewMemoryAttempts(window time.Duration) *MemoryAttempts {
+	return &MemoryAttempts{
+		attempts: make(map[string]*LoginAttempt),
+		window:   window,
+	}
+}
+
+func (m *MemoryAttempts) ReserveLoginAttempt(key string, since time.Time, block func(failures int) time.Time) (*LoginAttempt, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(m.lastPrune) >= time.Minute {
+		m.lastPrune = now
+		m.prune(now, now.Add(-m.window))
+	}
+
+	attempt, ok := m.attempts[key]
+	if ok && now.Before(attempt.BlockedUntil) {
+		a := *attempt
+		return &a, false, nil
+	}
+	if !ok || attempt.UpdatedAt.Before(since) {
+		attempt = &LoginAttempt{Key: key}
+		m.attempts[key] = attempt
+	}
+	attempt.Failures++
+	attempt.UpdatedAt = now
+	attempt.BlockedUntil = block(attempt.Failures)
+
+	a := *attempt
+	return &a, true, nil
+}
+
+func (m *MemoryAttempts) ClearLoginAttempts(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.attempts, key)
+	return nil
+}
+
+func (m *MemoryAttempts) PruneLoginAttempts(before time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.prune(time.Now(), before), nil
+}
+
+// prune drops keys that are neither blocked nor updated since before, so
+// the map does not grow with every address that ever failed once
+func (m *MemoryAttempts) prune(now, before time.Time) int {
+	n := 0
+	for key, attempt := range m.attempts {
+		if attempt.UpdatedAt.Before(before) && now.After(attempt.BlockedUntil) {
+			delete(m.attempts, key)
+			n++
+		}
+	}
+	return n
+}
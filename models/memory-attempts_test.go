@@ -0,0 +1,28 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryAttemptsPrune(t *testing.T) {
+	m := NewMemoryAttempts(time.Minute)
+	block := func(failures int) time.Time {
+		if failures < 2 {
+			return time.Time{}
+		}
+		return time.Now().Add(time.Minute)
+	}
+
+	m.ReserveLoginAttempt("stale", time.Time{}, block)
+	m.ReserveLoginAttempt("blocked", time.Time{}, block)
+	m.ReserveLoginAttempt("blocked", time.Time{}, block)
+
+	n, err := m.PruneLoginAttempts(time.Now().Add(time.Second))
+	if err != nil || n != 1 {
+		t.Errorf("pruned %d, %v, want 1", n, err)
+	}
+	if _, ok := m.attempts["blocked"]; !ok {
+		t.Error("blocked key pruned")
+	}
+}
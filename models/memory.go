@@ -0,0 +1,1081 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"html"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryModel keeps everything in memory. It implements every store, so
+// handlers can run without postgres, and is safe for concurrent use
+type MemoryModel struct {
+	mu sync.Locker
+	*memoryData
+}
+
+// memoryData is what a MemoryModel stores
+type memoryData struct {
+	nextID    int
+	movies    map[int]*Movie
+	genres    map[int]*Genre
+	links     []MovieGenre
+	users     map[int]*User
+	tokens    map[int]*RefreshToken
+	apiKeys   map[int]*APIKey
+	audit     []*AuditEntry
+	revisions map[int][]*MovieRevision // by movie id
+}
+
+// NewMemoryModel returns an empty MemoryModel
+func NewMemoryModel() *MemoryModel {
+	return &MemoryModel{
+		mu: &sync.Mutex{},
+		memoryData: &memoryData{
+			movies:    make(map[int]*Movie),
+			genres:    make(map[int]*Genre),
+			users:     make(map[int]*User),
+			tokens:    make(map[int]*RefreshToken),
+			apiKeys:   make(map[int]*APIKey),
+			revisions: make(map[int][]*MovieRevision),
+		},
+	}
+}
+
+// NewMemoryModels returns models backed by one MemoryModel, whose
+// transactions roll back like postgres ones
+func NewMemoryModels(m *MemoryModel) Models {
+	attempts := NewMemoryAttempts(15 * time.Minute)
+
+	models := memoryModels(m, attempts)
+	models.withTx = func(ctx context.Context, fn func(tx Models) error) error {
+		return m.transaction(func(tx *MemoryModel) error {
+			// the tx models have no withTx, so transactions started
+			// inside fn join this one
+			return fn(memoryModels(tx, attempts))
+		})
+	}
+	return models
+}
+
+func memoryModels(m *MemoryModel, attempts *MemoryAttempts) Models {
+	return Models{
+		Movies:    m,
+		Genres:    m,
+		Users:     m,
+		Tokens:    m,
+		APIKeys:   m,
+		Attempts:  attempts,
+		Audit:     m,
+		Revisions: m,
+	}
+}
+
+// transaction locks the store while fn runs on it, and puts back what was
+// stored before when fn returns an error or panics. Other writes wait for
+// the transaction to end, so a rollback never undoes them
+func (m *MemoryModel) transaction(fn func(tx *MemoryModel) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	saved := m.snapshot()
+	committed := false
+	defer func() {
+		if !committed {
+			*m.memoryData = saved
+		}
+	}()
+
+	// the lock is already held, so the store fn sees does not lock again
+	err := fn(&MemoryModel{mu: noLock{}, memoryData: m.memoryData})
+	if err != nil {
+		return err
+	}
+	committed = true
+	return nil
+}
+
+// noLock is the Locker of a store already locked by its transaction
+type noLock struct{}
+
+func (noLock) Lock()   {}
+func (noLock) Unlock() {}
+
+// snapshot returns a deep copy of the stored records. Audit entries and
+// revisions are never changed once stored, so they are shared. Call it
+// with mu held
+func (m *MemoryModel) snapshot() memoryData {
+	s := memoryData{
+		nextID:    m.nextID,
+		movies:    make(map[int]*Movie, len(m.movies)),
+		genres:    make(map[int]*Genre, len(m.genres)),
+		links:     append([]MovieGenre(nil), m.links...),
+		users:     make(map[int]*User, len(m.users)),
+		tokens:    make(map[int]*RefreshToken, len(m.tokens)),
+		apiKeys:   make(map[int]*APIKey, len(m.apiKeys)),
+		audit:     append([]*AuditEntry(nil), m.audit...),
+		revisions: make(map[int][]*MovieRevision, len(m.revisions)),
+	}
+	for id, movie := range m.movies {
+		movie := *movie
+		s.movies[id] = &movie
+	}
+	for id, genre := range m.genres {
+		genre := *genre
+		s.genres[id] = &genre
+	}
+	for id, user := range m.users {
+		user := *user
+		s.users[id] = &user
+	}
+	for id, token := range m.tokens {
+		token := *token
+		s.tokens[id] = &token
+	}
+	for id, key := range m.apiKeys {
+		key := *key
+		s.apiKeys[id] = &key
+	}
+	for id, revisions := range m.revisions {
+		s.revisions[id] = append([]*MovieRevision(nil), revisions...)
+	}
+
+	return s
+}
+
+// id hands out ids from one sequence shared by all records
+func (m *MemoryModel) id() int {
+	m.nextID++
+	return m.nextID
+}
+
+// movie returns a copy of a stored movie with its genres filled in
+func (m *MemoryModel) movie(id int) *Movie {
+	movie := *m.movies[id]
+	if movie.DeletedAt != nil {
+		deletedAt := *movie.DeletedAt
+		movie.DeletedAt = &deletedAt
+	}
+	movie.MovieGenre = make(map[int]string)
+	for _, link := range m.links {
+		if link.MovieID == id {
+			movie.MovieGenre[link.ID] = m.genres[link.GenreID].GenreName
+		}
+	}
+	return &movie
+}
+
+func (m *MemoryModel) Get(id int) (*Movie, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if movie, ok := m.movies[id]; !ok || movie.DeletedAt != nil {
+		return nil, sql.ErrNoRows
+	}
+	return m.movie(id), nil
+}
+
+func (m *MemoryModel) GetTrashed(id int) (*Movie, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if movie, ok := m.movies[id]; !ok || movie.DeletedAt == nil {
+		return nil, sql.ErrNoRows
+	}
+	return m.movie(id), nil
+}
+
+func (m *MemoryModel) All(genre ...int) ([]*Movie, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f := MovieFilter{}
+	if len(genre) > 0 {
+		f.GenreIDs = genre[:1]
+	}
+	movies := m.filter(f)
+	sortMovies(movies, "title", false)
+
+	return movies, nil
+}
+
+func (m *MemoryModel) GenreMovies(genreIDs []int) (map[int][]*Movie, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	byGenre := make(map[int][]*Movie, len(genreIDs))
+	for _, id := range genreIDs {
+		byGenre[id] = []*Movie{}
+	}
+	for _, link := range m.links {
+		movies, ok := byGenre[link.GenreID]
+		if !ok || m.movies[link.MovieID].DeletedAt != nil {
+			continue
+		}
+		byGenre[link.GenreID] = append(movies, m.movie(link.MovieID))
+	}
+	for _, movies := range byGenre {
+		sortMovies(movies, "title", false)
+	}
+
+	return byGenre, nil
+}
+
+func (m *MemoryModel) List(f MovieFilter) ([]*Movie, Metadata, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if f.Sort == "" {
+		f.Sort = "title"
+	}
+	if _, ok := SortFields[f.Sort]; !ok {
+		return nil, Metadata{}, fmt.Errorf("cannot sort by %q", f.Sort)
+	}
+	sortKey := f.Sort + " asc"
+	if f.Desc {
+		sortKey = f.Sort + " desc"
+	}
+
+	movies := m.filter(f)
+	sortMovies(movies, f.Sort, f.Desc)
+	meta := Metadata{Total: len(movies), Limit: f.Limit}
+
+	start := f.Offset
+	if f.Cursor != "" {
+		c, err := decodeCursor(f.Cursor, sortKey)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		// the page starts at the first movie past the cursor's sort value
+		// and id, even when the cursor's own movie is gone
+		start = len(movies)
+		for i, movie := range movies {
+			if afterCursor(movie, f.Sort, f.Desc, c) {
+				start = i
+				break
+			}
+		}
+	} else {
+		meta.Offset = f.Offset
+	}
+	if start > len(movies) {
+		start = len(movies)
+	}
+
+	page := movies[start:]
+	if len(page) > f.Limit {
+		page = page[:f.Limit]
+		last := page[len(page)-1]
+		meta.NextCursor = encodeCursor(cursor{Sort: sortKey, Value: sortValue(last, f.Sort), ID: last.ID})
+	}
+
+	return page, meta, nil
+}
+
+// filter returns copies of the movies matching the filter, leaving out
+// the trash
+func (m *MemoryModel) filter(f MovieFilter) []*Movie {
+	movies := []*Movie{}
+	for id := range m.movies {
+		movie := m.movie(id)
+
+		if movie.DeletedAt != nil ||
+			f.YearFrom > 0 && movie.Year < f.YearFrom ||
+			f.YearTo > 0 && movie.Year > f.YearTo ||
+			f.MinRating > 0 && movie.Rating < f.MinRating ||
+			len(f.MPAARatings) > 0 && !containsString(f.MPAARatings, movie.MPAARating) ||
+			len(f.GenreIDs) > 0 && !m.inGenres(id, f.GenreIDs) ||
+			!strings.Contains(strings.ToLower(movie.Title), strings.ToLower(f.Title)) {
+			continue
+		}
+		movies = append(movies, movie)
+	}
+	return movies
+}
+
+func (m *MemoryModel) inGenres(movieID int, genreIDs []int) bool {
+	for _, link := range m.links {
+		if link.MovieID != movieID {
+			continue
+		}
+		for _, id := range genreIDs {
+			if link.GenreID == id {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// sortMovies orders movies the way List does in postgres, by a field and then id
+func sortMovies(movies []*Movie, field string, desc bool) {
+	sort.Slice(movies, func(i, j int) bool {
+		a, b := movies[i], movies[j]
+		if desc {
+			a, b = b, a
+		}
+		var less, equal bool
+		switch field {
+		case "year":
+			less, equal = a.Year < b.Year, a.Year == b.Year
+		case "rating":
+			less, equal = a.Rating < b.Rating, a.Rating == b.Rating
+		case "runtime":
+			less, equal = a.Runtime < b.Runtime, a.Runtime == b.Runtime
+		case "release_date":
+			less, equal = a.ReleaseDate.Before(b.ReleaseDate), a.ReleaseDate.Equal(b.ReleaseDate)
+		default:
+			less, equal = a.Title < b.Title, a.Title == b.Title
+		}
+		if equal {
+			return a.ID < b.ID
+		}
+		return less
+	})
+}
+
+// afterCursor reports whether movie sorts after the cursor position,
+// comparing (field, id) the way postgres compares the row values
+func afterCursor(movie *Movie, field string, desc bool, c cursor) bool {
+	cmp := compareSortValues(field, sortValue(movie, field), c.Value)
+	if cmp == 0 {
+		cmp = compareInts(movie.ID, c.ID)
+	}
+	if desc {
+		return cmp < 0
+	}
+	return cmp > 0
+}
+
+// compareSortValues compares two values written by sortValue
+func compareSortValues(field, a, b string) int {
+	if SortFields[field] == "integer" {
+		x, _ := strconv.Atoi(a)
+		y, _ := strconv.Atoi(b)
+		return compareInts(x, y)
+	}
+	// titles, and dates written as 2006-01-02, compare as strings
+	return strings.Compare(a, b)
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// Search matches case insensitive substrings of the title or description.
+// Title matches rank first; snippets are the unhighlighted fields, escaped
+// as html
+func (m *MemoryModel) Search(q string, limit int) ([]*SearchResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	results := []*SearchResult{}
+	q = strings.ToLower(strings.TrimSpace(q))
+	if q == "" {
+		return results, nil
+	}
+
+	for id, movie := range m.movies {
+		if movie.DeletedAt != nil {
+			continue
+		}
+
+		var rank float64
+		if strings.Contains(strings.ToLower(movie.Title), q) {
+			rank += 1
+		}
+		if strings.Contains(strings.ToLower(movie.Description), q) {
+			rank += 0.4
+		}
+		if rank == 0 {
+			continue
+		}
+
+		results = append(results, &SearchResult{
+			Movie:              m.movie(id),
+			Rank:               rank,
+			TitleSnippet:       html.EscapeString(movie.Title),
+			DescriptionSnippet: html.EscapeString(movie.Description),
+		})
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Rank != results[j].Rank {
+			return results[i].Rank > results[j].Rank
+		}
+		return results[i].Title < results[j].Title
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+
+	return results, nil
+}
+
+func (m *MemoryModel) InsertMovie(movie Movie, genreIDs []int) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.checkGenres(genreIDs); err != nil {
+		return 0, err
+	}
+
+	movie.ID = m.id()
+	movie.MovieGenre = nil
+	movie.DeletedAt = nil
+	movie.Version = 1
+	m.movies[movie.ID] = &movie
+	m.setMovieGenres(movie.ID, genreIDs)
+
+	return movie.ID, nil
+}
+
+func (m *MemoryModel) UpdateMovie(movie Movie, genreIDs []int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.movies[movie.ID]
+	if !ok || stored.DeletedAt != nil {
+		return sql.ErrNoRows
+	}
+	if stored.Version != movie.Version {
+		return ErrVersionConflict
+	}
+	if err := m.checkGenres(genreIDs); err != nil {
+		return err
+	}
+
+	movie.CreatedAt = stored.CreatedAt
+	movie.MovieGenre = nil
+	movie.DeletedAt = nil
+	movie.Version = stored.Version + 1
+	m.movies[movie.ID] = &movie
+	if genreIDs != nil {
+		m.setMovieGenres(movie.ID, genreIDs)
+	}
+
+	return nil
+}
+
+func (m *MemoryModel) DeleteMovie(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	movie, ok := m.movies[id]
+	if !ok || movie.DeletedAt != nil {
+		return sql.ErrNoRows
+	}
+	now := time.Now()
+	movie.DeletedAt = &now
+	movie.Version++
+
+	return nil
+}
+
+func (m *MemoryModel) TrashedMovies() ([]*Movie, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	movies := []*Movie{}
+	for id, movie := range m.movies {
+		if movie.DeletedAt != nil {
+			movies = append(movies, m.movie(id))
+		}
+	}
+	sort.Slice(movies, func(i, j int) bool {
+		if !movies[i].DeletedAt.Equal(*movies[j].DeletedAt) {
+			return movies[i].DeletedAt.After(*movies[j].DeletedAt)
+		}
+		return movies[i].ID > movies[j].ID
+	})
+
+	return movies, nil
+}
+
+func (m *MemoryModel) RestoreMovie(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	movie, ok := m.movies[id]
+	if !ok || movie.DeletedAt == nil {
+		return sql.ErrNoRows
+	}
+	movie.DeletedAt = nil
+	movie.UpdatedAt = time.Now()
+	movie.Version++
+
+	return nil
+}
+
+func (m *MemoryModel) PurgeMovie(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	movie, ok := m.movies[id]
+	if !ok || movie.DeletedAt == nil {
+		return sql.ErrNoRows
+	}
+	delete(m.movies, id)
+	delete(m.revisions, id)
+	m.setMovieGenres(id, nil)
+
+	return nil
+}
+
+// checkGenres returns an ErrUnknownGenre for ids of missing genres
+func (m *MemoryModel) checkGenres(genreIDs []int) error {
+	var unknown []int
+	for _, id := range genreIDs {
+		if _, ok := m.genres[id]; !ok {
+			unknown = append(unknown, id)
+		}
+	}
+	if len(unknown) > 0 {
+		return fmt.Errorf("%w: %v", ErrUnknownGenre, unknown)
+	}
+	return nil
+}
+
+func (m *MemoryModel) setMovieGenres(movieID int, genreIDs []int) {
+	links := m.links[:0]
+	for _, link := range m.links {
+		if link.MovieID != movieID {
+			links = append(links, link)
+		}
+	}
+
+	seen := make(map[int]bool)
+	for _, id := range genreIDs {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		links = append(links, MovieGenre{ID: m.id(), MovieID: movieID, GenreID: id})
+	}
+	m.links = links
+}
+
+func (m *MemoryModel) GenresAll() ([]*Genre, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var genres []*Genre
+	for _, genre := range m.genres {
+		g := *genre
+		genres = append(genres, &g)
+	}
+	sort.Slice(genres, func(i, j int) bool {
+		return genres[i].GenreName < genres[j].GenreName
+	})
+
+	return genres, nil
+}
+
+func (m *MemoryModel) GetGenre(id int) (*Genre, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	genre, ok := m.genres[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	g := *genre
+	return &g, nil
+}
+
+func (m *MemoryModel) MovieGenres(movieIDs []int) (map[int][]*Genre, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	byMovie := make(map[int][]*Genre, len(movieIDs))
+	for _, id := range movieIDs {
+		byMovie[id] = []*Genre{}
+	}
+	for _, link := range m.links {
+		genres, ok := byMovie[link.MovieID]
+		if !ok {
+			continue
+		}
+		g := *m.genres[link.GenreID]
+		byMovie[link.MovieID] = append(genres, &g)
+	}
+	for _, genres := range byMovie {
+		sort.Slice(genres, func(i, j int) bool {
+			return genres[i].GenreName < genres[j].GenreName
+		})
+	}
+
+	return byMovie, nil
+}
+
+func (m *MemoryModel) InsertGenre(genre Genre) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.genreNameTaken(genre.GenreName, 0) {
+		return 0, ErrDuplicateGenre
+	}
+
+	genre.ID = m.id()
+	genre.CreatedAt = time.Now()
+	genre.UpdatedAt = time.Now()
+	m.genres[genre.ID] = &genre
+
+	return genre.ID, nil
+}
+
+func (m *MemoryModel) UpdateGenre(genre Genre) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.genres[genre.ID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	if m.genreNameTaken(genre.GenreName, genre.ID) {
+		return ErrDuplicateGenre
+	}
+
+	stored.GenreName = genre.GenreName
+	stored.UpdatedAt = time.Now()
+
+	return nil
+}
+
+func (m *MemoryModel) DeleteGenre(id int, cascade bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.genres[id]; !ok {
+		return sql.ErrNoRows
+	}
+
+	var links []MovieGenre
+	var movieIDs []int
+	for _, link := range m.links {
+		if link.GenreID != id {
+			links = append(links, link)
+		} else {
+			movieIDs = append(movieIDs, link.MovieID)
+		}
+	}
+	if len(movieIDs) > 0 && !cascade {
+		return ErrGenreInUse
+	}
+	for _, movieID := range movieIDs {
+		m.movies[movieID].UpdatedAt = time.Now()
+		m.movies[movieID].Version++
+	}
+	m.links = links
+	delete(m.genres, id)
+
+	return nil
+}
+
+func (m *MemoryModel) genreNameTaken(name string, id int) bool {
+	for _, genre := range m.genres {
+		if genre.ID != id && strings.EqualFold(genre.GenreName, strings.TrimSpace(name)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Seed upserts a fixture like DBModel.Seed does
+func (m *MemoryModel) Seed(f *Fixture) (SeedStats, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var stats SeedStats
+	now := time.Now()
+
+	genreIDs := make(map[string]int)
+	for _, name := range f.genreNames() {
+		var stored *Genre
+		for _, genre := range m.genres {
+			if strings.EqualFold(genre.GenreName, name) {
+				stored = genre
+			}
+		}
+
+		switch {
+		case stored == nil:
+			stored = &Genre{ID: m.id(), GenreName: name, CreatedAt: now, UpdatedAt: now}
+			m.genres[stored.ID] = stored
+			stats.GenresCreated++
+		case stored.GenreName != name:
+			stored.GenreName = name
+			stored.UpdatedAt = now
+			stats.GenresUpdated++
+		}
+		genreIDs[strings.ToLower(name)] = stored.ID
+	}
+
+	for _, fm := range f.Movies {
+		movie, err := fm.movie(now)
+		if err != nil {
+			return stats, err
+		}
+
+		// the oldest live movie with the title and year, as in postgres
+		var stored *Movie
+		for _, existing := range m.movies {
+			if existing.DeletedAt == nil && strings.EqualFold(existing.Title, movie.Title) &&
+				existing.Year == movie.Year && (stored == nil || existing.ID < stored.ID) {
+				stored = existing
+			}
+		}
+
+		created, updated := false, false
+		switch {
+		case stored == nil:
+			movie.ID = m.id()
+			movie.Version = 1
+			stored = &movie
+			m.movies[movie.ID] = stored
+			created = true
+		case !sameMovie(stored, &movie):
+			movie.ID = stored.ID
+			movie.CreatedAt = stored.CreatedAt
+			movie.Version = stored.Version + 1
+			*stored = movie
+			updated = true
+		}
+
+		if fm.Genres != nil {
+			ids := make([]int, 0, len(fm.Genres))
+			for _, name := range fm.Genres {
+				ids = append(ids, genreIDs[strings.ToLower(strings.TrimSpace(name))])
+			}
+
+			var current []int
+			for _, link := range m.links {
+				if link.MovieID == stored.ID {
+					current = append(current, link.GenreID)
+				}
+			}
+			if !sameInts(current, ids) {
+				m.setMovieGenres(stored.ID, ids)
+				updated = true
+			}
+		}
+
+		if created {
+			stats.MoviesCreated++
+		} else if updated {
+			stats.MoviesUpdated++
+		}
+	}
+
+	return stats, nil
+}
+
+func (m *MemoryModel) GetUser(id int) (*User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	u := *user
+	return &u, nil
+}
+
+func (m *MemoryModel) GetUserByEmail(email string) (*User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, user := range m.users {
+		if strings.EqualFold(user.Email, email) {
+			u := *user
+			return &u, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (m *MemoryModel) InsertUser(user User) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, stored := range m.users {
+		if strings.EqualFold(stored.Email, user.Email) {
+			return 0, ErrDuplicateEmail
+		}
+	}
+
+	if user.Role == "" {
+		user.Role = RoleViewer
+	}
+	user.ID = m.id()
+	user.CreatedAt = time.Now()
+	user.UpdatedAt = time.Now()
+	m.users[user.ID] = &user
+
+	return user.ID, nil
+}
+
+func (m *MemoryModel) UpdatePassword(id int, hash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if user, ok := m.users[id]; ok {
+		user.Password = hash
+		user.UpdatedAt = time.Now()
+	}
+	return nil
+}
+
+func (m *MemoryModel) InsertRefreshToken(token RefreshToken) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	token.ID = m.id()
+	token.CreatedAt = time.Now()
+	m.tokens[token.ID] = &token
+
+	return nil
+}
+
+func (m *MemoryModel) GetRefreshToken(hash string) (*RefreshToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, token := range m.tokens {
+		if token.TokenHash == hash {
+			t := *token
+			return &t, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (m *MemoryModel) UseRefreshToken(id int) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	token, ok := m.tokens[id]
+	if !ok || token.UsedAt.Valid {
+		return false, nil
+	}
+	token.UsedAt = sql.NullTime{Time: time.Now(), Valid: true}
+
+	return true, nil
+}
+
+func (m *MemoryModel) RevokeTokenFamily(familyID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, token := range m.tokens {
+		if token.FamilyID == familyID && !token.RevokedAt.Valid {
+			token.RevokedAt = sql.NullTime{Time: time.Now(), Valid: true}
+		}
+	}
+	return nil
+}
+
+func (m *MemoryModel) SessionRevoked(familyID string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	found := false
+	for _, token := range m.tokens {
+		if token.FamilyID != familyID {
+			continue
+		}
+		if token.RevokedAt.Valid {
+			return true, nil
+		}
+		found = true
+	}
+	return !found, nil
+}
+
+func (m *MemoryModel) InsertAPIKey(key APIKey) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key.ID = m.id()
+	key.CreatedAt = time.Now()
+	m.apiKeys[key.ID] = &key
+
+	return key.ID, nil
+}
+
+func (m *MemoryModel) GetAPIKey(id int) (*APIKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key, ok := m.apiKeys[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	k := *key
+	return &k, nil
+}
+
+func (m *MemoryModel) GetAPIKeyByHash(hash string) (*APIKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, key := range m.apiKeys {
+		if key.KeyHash == hash {
+			k := *key
+			return &k, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (m *MemoryModel) APIKeysAll() ([]*APIKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var keys []*APIKey
+	for _, key := range m.apiKeys {
+		k := *key
+		keys = append(keys, &k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].ID > keys[j].ID
+	})
+
+	return keys, nil
+}
+
+func (m *MemoryModel) RevokeAPIKey(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key, ok := m.apiKeys[id]
+	if !ok || key.RevokedAt != nil {
+		return sql.ErrNoRows
+	}
+	now := time.Now()
+	key.RevokedAt = &now
+
+	return nil
+}
+
+func (m *MemoryModel) TouchAPIKey(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if key, ok := m.apiKeys[id]; ok {
+		now := time.Now()
+		key.LastUsedAt = &now
+	}
+	return nil
+}
+
+func (m *MemoryModel) InsertAudit(entry AuditEntry) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry.ID = m.id()
+	m.audit = append(m.audit, &entry)
+
+	return entry.ID, nil
+}
+
+func (m *MemoryModel) AuditLog(f AuditFilter) ([]*AuditEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entries := []*AuditEntry{}
+	// newest first
+	for i := len(m.audit) - 1; i >= 0; i-- {
+		entry := m.audit[i]
+		if f.Entity != "" && entry.Entity != f.Entity ||
+			f.EntityID > 0 && entry.EntityID != f.EntityID ||
+			f.UserID > 0 && entry.UserID != f.UserID ||
+			!f.From.IsZero() && entry.CreatedAt.Before(f.From) ||
+			!f.To.IsZero() && !entry.CreatedAt.Before(f.To) {
+			continue
+		}
+		e := *entry
+		entries = append(entries, &e)
+	}
+
+	if f.Offset > len(entries) {
+		f.Offset = len(entries)
+	}
+	entries = entries[f.Offset:]
+	if len(entries) > f.Limit {
+		entries = entries[:f.Limit]
+	}
+
+	return entries, nil
+}
+
+func (m *MemoryModel) SnapshotMovie(rev MovieRevision) (*MovieRevision, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	movie, ok := m.movies[rev.MovieID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	var genreIDs []int
+	for _, link := range m.links {
+		if link.MovieID == rev.MovieID {
+			genreIDs = append(genreIDs, link.GenreID)
+		}
+	}
+
+	rev.ID = m.id()
+	rev.Revision = len(m.revisions[rev.MovieID]) + 1
+	rev.Movie = snapshot(movie, genreIDs)
+	m.revisions[rev.MovieID] = append(m.revisions[rev.MovieID], &rev)
+
+	r := rev
+	return &r, nil
+}
+
+func (m *MemoryModel) MovieRevisions(movieID int) ([]*MovieRevision, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	revisions := []*MovieRevision{}
+	for _, rev := range m.revisions[movieID] {
+		r := *rev
+		revisions = append(revisions, &r)
+	}
+
+	return revisions, nil
+}
+
+func (m *MemoryModel) GetMovieRevision(movieID, revision int) (*MovieRevision, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	revisions := m.revisions[movieID]
+	if revision < 1 || revision > len(revisions) {
+		return nil, sql.ErrNoRows
+	}
+	r := *revisions[revision-1]
+	return &r, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,134 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestMemoryListCursor(t *testing.T) {
+	m := NewMemoryModel()
+	for _, title := range []string{"Airplane!", "Casablanca", "Heat", "The Godfather"} {
+		_, err := m.InsertMovie(Movie{Title: title}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, desc := range []bool{false, true} {
+		page, meta, err := m.List(MovieFilter{Limit: 2, Desc: desc})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// deleting the last movie of a page must not lose the next page
+		err = m.DeleteMovie(page[1].ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		next, _, err := m.List(MovieFilter{Limit: 2, Desc: desc, Cursor: meta.NextCursor})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := []string{"Heat", "The Godfather"}
+		if desc {
+			want = []string{"Airplane!"}
+		}
+		var got []string
+		for _, movie := range next {
+			got = append(got, movie.Title)
+		}
+		if len(got) != len(want) || len(got) > 0 && got[0] != want[0] {
+			t.Errorf("desc %v: got %v after the cursor's movie was deleted, want %v", desc, got, want)
+		}
+	}
+}
+
+func TestMemoryInsertUser(t *testing.T) {
+	m := NewMemoryModel()
+
+	_, err := m.InsertUser(User{Email: "me@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.InsertUser(User{Email: "ME@example.com"})
+	if !errors.Is(err, ErrDuplicateEmail) {
+		t.Errorf("got %v, want ErrDuplicateEmail", err)
+	}
+}
+
+func TestMemoryWithTx(t *testing.T) {
+	m := NewMemoryModel()
+	models := NewMemoryModels(m)
+	ctx := context.Background()
+
+	// an error rolls back everything written in fn, nested calls included
+	errStop := errors.New("stop")
+	err := models.WithTx(ctx, func(tx Models) error {
+		_, err := tx.Genres.InsertGenre(Genre{GenreName: "Drama"})
+		if err != nil {
+			return err
+		}
+		return tx.WithTx(ctx, func(nested Models) error {
+			_, err := nested.Genres.InsertGenre(Genre{GenreName: "Crime"})
+			if err != nil {
+				return err
+			}
+			return errStop
+		})
+	})
+	if !errors.Is(err, errStop) {
+		t.Fatalf("got %v, want errStop", err)
+	}
+	if genres, _ := m.GenresAll(); len(genres) != 0 {
+		t.Errorf("got genres %+v after rolling back", genres)
+	}
+
+	// so does a panic
+	func() {
+		defer func() { recover() }()
+		models.WithTx(ctx, func(tx Models) error {
+			tx.Genres.InsertGenre(Genre{GenreName: "Drama"})
+			panic("stop")
+		})
+	}()
+	if genres, _ := m.GenresAll(); len(genres) != 0 {
+		t.Errorf("got genres %+v after a panic", genres)
+	}
+
+	// writes outside the transaction wait for it, and a rollback keeps them
+	done := make(chan error)
+	err = models.WithTx(ctx, func(tx Models) error {
+		_, err := tx.Genres.InsertGenre(Genre{GenreName: "Drama"})
+		if err != nil {
+			return err
+		}
+		go func() {
+			_, err := m.InsertGenre(Genre{GenreName: "Crime"})
+			done <- err
+		}()
+		return errStop
+	})
+	if !errors.Is(err, errStop) {
+		t.Fatalf("got %v, want errStop", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	genres, _ := m.GenresAll()
+	if len(genres) != 1 || genres[0].GenreName != "Crime" {
+		t.Errorf("got genres %+v, want Crime written outside the transaction", genres)
+	}
+
+	err = models.WithTx(ctx, func(tx Models) error {
+		_, err := tx.Genres.InsertGenre(Genre{GenreName: "Drama"})
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if genres, _ := m.GenresAll(); len(genres) != 2 {
+		t.Errorf("got genres %+v, want Drama committed", genres)
+	}
+}
@@ -1,20 +1,81 @@
 package models
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
+	"sort"
 	"time"
 )
 
-// Models is the wrapper for database
+// Models is the wrapper for the stores. NewModels backs them with
+// postgres and NewMemoryModels with in-memory data
 type Models struct {
-	DB DBModel
+	Movies    MovieStore
+	Genres    GenreStore
+	Users     UserStore
+	Tokens    TokenStore
+	APIKeys   APIKeyStore
+	Attempts  LoginAttemptStore
+	Audit     AuditStore
+	Revisions RevisionStore
+
+	// withTx runs fn with models bound to one transaction, nil when the
+	// stores have no transactions
+	withTx func(ctx context.Context, fn func(tx Models) error) error
+	// withContext returns the models with queries bound to ctx, nil when
+	// the stores do not query anything
+	withContext func(ctx context.Context) Models
 }
 
 // NewModels returns models with db pool
 func NewModels(db *sql.DB) Models {
+	return newDBModels(DBModel{DB: db})
+}
+
+func newDBModels(base DBModel) Models {
+	m := dbModels(&base)
+	m.withTx = func(ctx context.Context, fn func(tx Models) error) error {
+		return base.WithTx(ctx, func(tx DBModel) error {
+			return fn(dbModels(&tx))
+		})
+	}
+	m.withContext = func(ctx context.Context) Models {
+		return newDBModels(DBModel{DB: base.DB, ctx: ctx})
+	}
+	return m
+}
+
+func dbModels(m *DBModel) Models {
 	return Models{
-		DB: DBModel{DB: db},
+		Movies:    m,
+		Genres:    m,
+		Users:     m,
+		Tokens:    m,
+		APIKeys:   m,
+		Attempts:  m,
+		Audit:     m,
+		Revisions: m,
+	}
+}
+
+// WithTx runs fn with models whose writes commit together when fn returns
+// nil and roll back when it returns an error
+func (m Models) WithTx(ctx context.Context, fn func(tx Models) error) error {
+	if m.withTx == nil {
+		return fn(m)
+	}
+	return m.withTx(ctx, fn)
+}
+
+// WithContext returns models whose queries run under ctx, typically the
+// context of a request, so they stop when it is cancelled. Queries keep
+// their own timeout as well
+func (m Models) WithContext(ctx context.Context) Models {
+	if m.withContext == nil {
+		return m
 	}
+	return m.withContext(ctx)
 }
 
 // Movie is the type for a movie
@@ -31,6 +92,40 @@ type Movie struct {
 	UpdatedAt   time.Time      `json:"updated_at"`
 	MovieGenre  map[int]string `json:"genres"`
 	Poster      string         `json:"poster"`
+	Version     int            `json:"version"`              // bumped by every change, for optimistic locking
+	DeletedAt   *time.Time     `json:"deleted_at,omitempty"` // set while the movie is in the trash
+}
+
+// MovieFilter selects, sorts and pages the movies returned by List
+type MovieFilter struct {
+	Limit       int
+	Offset      int
+	Cursor      string // continues after the last movie of a previous page, instead of Offset
+	Sort        string // one of SortFields, title by default
+	Desc        bool
+	YearFrom    int
+	YearTo      int
+	MPAARatings []string
+	MinRating   int
+	GenreIDs    []int  // movies in any of these genres
+	Title       string // movies whose title contains this, ignoring case
+}
+
+// Metadata describes one page of a list
+type Metadata struct {
+	Total      int    `json:"total"`
+	Limit      int    `json:"limit"`
+	Offset     int    `json:"offset"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// SearchResult is a movie matching a search, with highlighted snippets.
+// Snippets are escaped html, with matched words wrapped in <b></b>
+type SearchResult struct {
+	*Movie
+	Rank               float64 `json:"rank"`
+	TitleSnippet       string  `json:"title_snippet"`
+	DescriptionSnippet string  `json:"description_snippet"`
 }
 
 // Genre is the type for genre
@@ -51,9 +146,159 @@ type MovieGenre struct {
 	UpdatedAt time.Time `json:"-"`
 }
 
+// Roles a user can hold, from least to most privileged
+const (
+	RoleViewer = "viewer"
+	RoleEditor = "editor"
+	RoleAdmin  = "admin"
+)
+
+var roleRank = map[string]int{
+	RoleViewer: 1,
+	RoleEditor: 2,
+	RoleAdmin:  3,
+}
+
+// ValidRole reports whether role is one of the known roles
+func ValidRole(role string) bool {
+	_, ok := roleRank[role]
+	return ok
+}
+
+// RoleAtLeast reports whether role grants everything min does
+func RoleAtLeast(role, min string) bool {
+	return ValidRole(role) && roleRank[role] >= roleRank[min]
+}
+
 // User is the type for users
 type User struct {
-	ID       int
-	Email    string
-	Password string
+	ID        int       `json:"id"`
+	Email     string    `json:"email"`
+	Password  string    `json:"-"` // bcrypt hash
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"-"`
+	UpdatedAt time.Time `json:"-"`
+}
+
+// RefreshToken is the type for a server side refresh token.
+// Tokens issued from one signin share a FamilyID, which doubles as the
+// session id carried in access tokens
+type RefreshToken struct {
+	ID        int
+	UserID    int
+	FamilyID  string
+	TokenHash string // hex sha-256 of the token handed to the client
+	ExpiresAt time.Time
+	UsedAt    sql.NullTime
+	RevokedAt sql.NullTime
+	CreatedAt time.Time
+}
+
+// APIKey is the type for a machine-to-machine api key.
+// Scopes name the roles the key may act as
+type APIKey struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"` // first characters of the key, to tell keys apart
+	KeyHash    string     `json:"-"`      // hex sha-256 of the key
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedBy  int        `json:"created_by"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// LoginAttempt tracks failed signins for an ip address or account
+type LoginAttempt struct {
+	Key          string
+	Failures     int
+	BlockedUntil time.Time
+	UpdatedAt    time.Time
+}
+
+// AuditEntry records one change made through the admin api. Changes maps
+// each changed field to its old and new value
+type AuditEntry struct {
+	ID        int                    `json:"id"`
+	UserID    int                    `json:"user_id"`
+	APIKeyID  *int                   `json:"api_key_id,omitempty"` // set when the change came with an api key
+	Action    string                 `json:"action"`
+	Entity    string                 `json:"entity"`
+	EntityID  int                    `json:"entity_id"`
+	Changes   map[string]FieldChange `json:"changes"`
+	CreatedAt time.Time              `json:"created_at"`
+}
+
+// FieldChange is the value of a field before and after a change. Before is
+// null for creates and After is null for deletes
+type FieldChange struct {
+	Before json.RawMessage `json:"before"`
+	After  json.RawMessage `json:"after"`
+}
+
+// AuditFilter selects audit entries. Zero values match everything
+type AuditFilter struct {
+	Entity   string
+	EntityID int
+	UserID   int
+	From     time.Time // inclusive
+	To       time.Time // exclusive
+	Limit    int
+	Offset   int
+}
+
+// MovieRevision is a numbered snapshot of a movie, taken after each change
+type MovieRevision struct {
+	ID           int           `json:"id"`
+	MovieID      int           `json:"movie_id"`
+	Revision     int           `json:"revision"`
+	Action       string        `json:"action"`                   // baseline, create, update or rollback
+	RolledBackTo *int          `json:"rolled_back_to,omitempty"` // the revision a rollback restored
+	Movie        MovieSnapshot `json:"movie"`
+	CreatedBy    int           `json:"created_by"`
+	CreatedAt    time.Time     `json:"created_at"`
+}
+
+// MovieSnapshot holds the editable fields of a movie
+type MovieSnapshot struct {
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	Year        int       `json:"year"`
+	ReleaseDate time.Time `json:"release_date"`
+	Runtime     int       `json:"runtime"`
+	Rating      int       `json:"rating"`
+	MPAARating  string    `json:"mpaa_rating"`
+	Poster      string    `json:"poster"`
+	GenreIDs    []int     `json:"genre_ids"`
+}
+
+// Apply copies the snapshot onto a movie
+func (s MovieSnapshot) Apply(movie *Movie) {
+	movie.Title = s.Title
+	movie.Description = s.Description
+	movie.Year = s.Year
+	movie.ReleaseDate = s.ReleaseDate
+	movie.Runtime = s.Runtime
+	movie.Rating = s.Rating
+	movie.MPAARating = s.MPAARating
+	movie.Poster = s.Poster
+}
+
+// snapshot returns the editable fields of a movie
+func snapshot(movie *Movie, genreIDs []int) MovieSnapshot {
+	sorted := append([]int{}, genreIDs...)
+	sort.Ints(sorted)
+
+	return MovieSnapshot{
+		Title:       movie.Title,
+		Description: movie.Description,
+		Year:        movie.Year,
+		ReleaseDate: movie.ReleaseDate,
+		Runtime:     movie.Runtime,
+		Rating:      movie.Rating,
+		MPAARating:  movie.MPAARating,
+		Poster:      movie.Poster,
+		GenreIDs:    sorted,
+	}
 }
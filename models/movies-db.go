@@ -3,31 +3,64 @@ package models
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"time"
+
+	"github.com/lib/pq"
+)
+
+var (
+	// ErrUnknownGenre is returned when a movie is linked to a genre that does not exist
+	ErrUnknownGenre = errors.New("unknown genre")
+	// ErrVersionConflict is returned when a movie changed after the version being updated was read
+	ErrVersionConflict = errors.New("the movie was changed by someone else")
 )
 
+// DBModel runs queries against a database or, inside WithTx, a transaction
 type DBModel struct {
-	DB *sql.DB
+	DB DBTX
+
+	// ctx is the context queries run under, set by Models.WithContext
+	ctx context.Context
+}
+
+// timeout returns the context for one call: the model's context, or the
+// background when it has none, limited to three seconds
+func (m DBModel) timeout() (context.Context, context.CancelFunc) {
+	ctx := m.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithTimeout(ctx, 3*time.Second)
 }
 
 // Get returns one movie and error, if any
 func (m DBModel) Get(id int) (*Movie, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	return m.get(id, false)
+}
+
+// GetTrashed returns one movie in the trash
+func (m DBModel) GetTrashed(id int) (*Movie, error) {
+	return m.get(id, true)
+}
+
+func (m DBModel) get(id int, trashed bool) (*Movie, error) {
+	ctx, cancel := m.timeout()
 	defer cancel()
 
 	// coalesce(poster, '') means return poster if not null, else return an emtpy string
 	query := `
 		select 
 			id, title, description, year, release_date, rating, runtime, mpaa_rating,
-			created_at, updated_at, coalesce(poster, '')
+			created_at, updated_at, coalesce(poster, ''), version, deleted_at
 		from 
 			movies 
 		where
-			id = $1
+			id = $1 and (deleted_at is not null) = $2
 	`
 
-	row := m.DB.QueryRowContext(ctx, query, id)
+	row := m.DB.QueryRowContext(ctx, query, id, trashed)
 
 	var movie Movie
 
@@ -43,64 +76,36 @@ func (m DBModel) Get(id int) (*Movie, error) {
 		&movie.CreatedAt,
 		&movie.UpdatedAt,
 		&movie.Poster,
+		&movie.Version,
+		&movie.DeletedAt,
 	)
 	if err != nil {
 		return nil, err
 	}
 
 	// get genres for a movie
-	query = `
-		select
-			mg.id, mg.movie_id, mg.genre_id,
-			g.genre_name
-		from 
-			movies_genres mg
-			left join genres g on (g.id = mg.genre_id)
-		where 
-			mg.movie_id = $1
-	`
-
-	rows, err := m.DB.QueryContext(ctx, query, id)
+	err = m.loadGenres(ctx, []*Movie{&movie})
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-
-	genres := make(map[int]string)
-	for rows.Next() {
-		var mg MovieGenre
-
-		rows.Scan(
-			&mg.ID,
-			&mg.MovieID,
-			&mg.GenreID,
-			&mg.Genre.GenreName,
-		)
-		if err != nil {
-			return nil, err
-		}
-		genres[mg.ID] = mg.Genre.GenreName
-	}
-
-	movie.MovieGenre = genres
 
 	return &movie, nil
 }
 
 // GetAll returns all movies and error, if any
 func (m DBModel) All(genre ...int) ([]*Movie, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := m.timeout()
 	defer cancel()
 
-	where := ""
+	where := "where deleted_at is null"
 	if len(genre) > 0 {
-		where = fmt.Sprintf("where id in (select movie_id from movies_genres where genre_id = %d)", genre[0])
+		where += fmt.Sprintf(" and id in (select movie_id from movies_genres where genre_id = %d)", genre[0])
 	}
 
 	query := fmt.Sprintf(`
 		select 
 			id, title, description, year, release_date, rating, runtime, mpaa_rating,
-			created_at, updated_at, coalesce(poster, '')
+			created_at, updated_at, coalesce(poster, ''), version
 		from 
 			movies 
 		%s
@@ -130,54 +135,142 @@ func (m DBModel) All(genre ...int) ([]*Movie, error) {
 			&movie.CreatedAt,
 			&movie.UpdatedAt,
 			&movie.Poster,
+			&movie.Version,
 		)
 		if err != nil {
 			return nil, err
 		}
 
-		// get genres for movie
-		genreQuery := `
-			select
-				mg.id, mg.movie_id, mg.genre_id,
-				g.genre_name
-			from 
-				movies_genres mg
-				left join genres g on (g.id = mg.genre_id)
-			where 
-				mg.movie_id = $1
-		`
-
-		genreRows, err := m.DB.QueryContext(ctx, genreQuery, movie.ID)
+		movies = append(movies, &movie)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	// get genres for all movies in one query
+	err = m.loadGenres(ctx, movies)
+	if err != nil {
+		return nil, err
+	}
+
+	return movies, nil
+}
+
+// GenreMovies returns the movies in each of the given genres, sorted by
+// title, with a single query. Every genre id gets an entry, empty when it
+// has no movies. Genres of the movies are not filled in
+func (m DBModel) GenreMovies(genreIDs []int) (map[int][]*Movie, error) {
+	ctx, cancel := m.timeout()
+	defer cancel()
+
+	byGenre := make(map[int][]*Movie, len(genreIDs))
+	for _, id := range genreIDs {
+		byGenre[id] = []*Movie{}
+	}
+	if len(genreIDs) == 0 {
+		return byGenre, nil
+	}
+
+	query := `
+		select 
+			mg.genre_id,
+			m.id, m.title, m.description, m.year, m.release_date, m.rating, m.runtime, m.mpaa_rating,
+			m.created_at, m.updated_at, coalesce(m.poster, ''), m.version
+		from 
+			movies_genres mg
+			join movies m on (m.id = mg.movie_id)
+		where
+			mg.genre_id = any($1) and m.deleted_at is null
+		order by 
+			m.title, m.id
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(genreIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var genreID int
+		var movie Movie
+		err := rows.Scan(
+			&genreID,
+			&movie.ID,
+			&movie.Title,
+			&movie.Description,
+			&movie.Year,
+			&movie.ReleaseDate,
+			&movie.Rating,
+			&movie.Runtime,
+			&movie.MPAARating,
+			&movie.CreatedAt,
+			&movie.UpdatedAt,
+			&movie.Poster,
+			&movie.Version,
+		)
 		if err != nil {
 			return nil, err
 		}
+		byGenre[genreID] = append(byGenre[genreID], &movie)
+	}
 
-		genres := make(map[int]string)
-		for genreRows.Next() {
-			var mg MovieGenre
-
-			genreRows.Scan(
-				&mg.ID,
-				&mg.MovieID,
-				&mg.GenreID,
-				&mg.Genre.GenreName,
-			)
-			if err != nil {
-				return nil, err
-			}
-			genres[mg.ID] = mg.Genre.GenreName
-		}
-		genreRows.Close()
+	return byGenre, rows.Err()
+}
 
-		movie.MovieGenre = genres
-		movies = append(movies, &movie)
+// loadGenres fills in the genres of movies with a single query,
+// instead of one query per movie
+func (m DBModel) loadGenres(ctx context.Context, movies []*Movie) error {
+	if len(movies) == 0 {
+		return nil
 	}
 
-	return movies, nil
+	ids := make([]int, len(movies))
+	byID := make(map[int]*Movie, len(movies))
+	for i, movie := range movies {
+		ids[i] = movie.ID
+		byID[movie.ID] = movie
+		movie.MovieGenre = make(map[int]string)
+	}
+
+	query := `
+		select
+			mg.id, mg.movie_id, mg.genre_id,
+			g.genre_name
+		from 
+			movies_genres mg
+			left join genres g on (g.id = mg.genre_id)
+		where 
+			mg.movie_id = any($1)
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var mg MovieGenre
+
+		err = rows.Scan(
+			&mg.ID,
+			&mg.MovieID,
+			&mg.GenreID,
+			&mg.Genre.GenreName,
+		)
+		if err != nil {
+			return err
+		}
+		byID[mg.MovieID].MovieGenre[mg.ID] = mg.Genre.GenreName
+	}
+
+	return rows.Err()
 }
 
 func (m DBModel) GenresAll() ([]*Genre, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := m.timeout()
 	defer cancel()
 
 	query := `
@@ -212,37 +305,52 @@ func (m DBModel) GenresAll() ([]*Genre, error) {
 	return genres, nil
 }
 
-func (m *DBModel) InsertMovie(movie Movie) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// InsertMovie stores a new movie linked to the given genres and returns
+// its id. Both are written in one transaction
+func (m *DBModel) InsertMovie(movie Movie, genreIDs []int) (int, error) {
+	ctx, cancel := m.timeout()
 	defer cancel()
 
 	stmt := `
 		insert into movies (title, description, year, release_date, runtime, 
 			rating, mpaa_rating, created_at, updated_at, poster) 
 			values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		returning id
 	`
 
-	_, err := m.DB.ExecContext(ctx, stmt,
-		movie.Title,
-		movie.Description,
-		movie.Year,
-		movie.ReleaseDate,
-		movie.Runtime,
-		movie.Rating,
-		movie.MPAARating,
-		movie.CreatedAt,
-		movie.UpdatedAt,
-		movie.Poster,
-	)
+	var id int
+	err := m.WithTx(ctx, func(tx DBModel) error {
+		err := tx.DB.QueryRowContext(ctx, stmt,
+			movie.Title,
+			movie.Description,
+			movie.Year,
+			movie.ReleaseDate,
+			movie.Runtime,
+			movie.Rating,
+			movie.MPAARating,
+			movie.CreatedAt,
+			movie.UpdatedAt,
+			movie.Poster,
+		).Scan(&id)
+		if err != nil {
+			return err
+		}
+
+		return tx.setMovieGenres(ctx, id, genreIDs)
+	})
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	return nil
+	return id, nil
 }
 
-func (m *DBModel) UpdateMovie(movie Movie) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// UpdateMovie stores changes to a movie and bumps its version. movie.Version
+// must be the stored version, or ErrVersionConflict is returned, and a
+// movie that is missing or in the trash gives sql.ErrNoRows. When genreIDs
+// is not nil the movie's genres are replaced by them in the same transaction
+func (m *DBModel) UpdateMovie(movie Movie, genreIDs []int) error {
+	ctx, cancel := m.timeout()
 	defer cancel()
 
 	stmt := `
@@ -250,45 +358,134 @@ func (m *DBModel) UpdateMovie(movie Movie) error {
 			movies 
 		set title = $1, description = $2, year = $3, release_date = $4, 
 			runtime = $5, rating = $6, mpaa_rating = $7, 
-			updated_at = $8, poster = $9
+			updated_at = $8, poster = $9, version = version + 1
 		where
-			id = $10
+			id = $10 and deleted_at is null and version = $11
 	`
 
-	_, err := m.DB.ExecContext(ctx, stmt,
-		movie.Title,
-		movie.Description,
-		movie.Year,
-		movie.ReleaseDate,
-		movie.Runtime,
-		movie.Rating,
-		movie.MPAARating,
-		movie.UpdatedAt,
-		movie.Poster,
-		movie.ID,
-	)
+	return m.WithTx(ctx, func(tx DBModel) error {
+		res, err := tx.DB.ExecContext(ctx, stmt,
+			movie.Title,
+			movie.Description,
+			movie.Year,
+			movie.ReleaseDate,
+			movie.Runtime,
+			movie.Rating,
+			movie.MPAARating,
+			movie.UpdatedAt,
+			movie.Poster,
+			movie.ID,
+			movie.Version,
+		)
+		if err != nil {
+			return err
+		}
+
+		err = expectRow(res)
+		if errors.Is(err, sql.ErrNoRows) {
+			// tell a stale version from a missing movie
+			var exists bool
+			err = tx.DB.QueryRowContext(ctx,
+				`select exists (select 1 from movies where id = $1 and deleted_at is null)`, movie.ID,
+			).Scan(&exists)
+			if err == nil && exists {
+				return ErrVersionConflict
+			}
+			if err == nil {
+				return sql.ErrNoRows
+			}
+		}
+		if err != nil {
+			return err
+		}
+
+		if genreIDs == nil {
+			return nil
+		}
+		return tx.setMovieGenres(ctx, movie.ID, genreIDs)
+	})
+}
+
+// setMovieGenres replaces the genre links of a movie. Genre ids that do
+// not exist are reported as an ErrUnknownGenre. Call it inside WithTx
+func (m DBModel) setMovieGenres(ctx context.Context, movieID int, genreIDs []int) error {
+	// drop duplicates
+	seen := make(map[int]bool)
+	var ids []int
+	for _, id := range genreIDs {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+
+	if len(ids) > 0 {
+		rows, err := m.DB.QueryContext(ctx, `select id from genres where id = any($1)`, pq.Array(ids))
+		if err != nil {
+			return err
+		}
+		found := make(map[int]bool)
+		for rows.Next() {
+			var id int
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return err
+			}
+			found[id] = true
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		var unknown []int
+		for _, id := range ids {
+			if !found[id] {
+				unknown = append(unknown, id)
+			}
+		}
+		if len(unknown) > 0 {
+			return fmt.Errorf("%w: %v", ErrUnknownGenre, unknown)
+		}
+	}
+
+	_, err := m.DB.ExecContext(ctx, `delete from movies_genres where movie_id = $1`, movieID)
 	if err != nil {
 		return err
 	}
 
+	stmt := `
+		insert into movies_genres (movie_id, genre_id, created_at, updated_at)
+			values ($1, $2, $3, $4)
+	`
+	for _, id := range ids {
+		_, err = m.DB.ExecContext(ctx, stmt, movieID, id, time.Now(), time.Now())
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
+// DeleteMovie moves a movie to the trash. Its genre links are kept so
+// that RestoreMovie can bring it back as it was
 func (m *DBModel) DeleteMovie(id int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := m.timeout()
 	defer cancel()
 
-	query := `
-		delete from 
+	stmt := `
+		update 
 			movies 
+		set deleted_at = $1, version = version + 1
 		where 
-			id = $1
+			id = $2 and deleted_at is null
 	`
 
-	_, err := m.DB.ExecContext(ctx, query, id)
+	res, err := m.DB.ExecContext(ctx, stmt, time.Now(), id)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return expectRow(res)
 }
@@ -0,0 +1,174 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// benchMovies is how many movies the benchmarks seed
+const benchMovies = 1000
+
+// benchDB returns a testDB seeded with benchMovies movies in ten genres,
+// three genres each
+func benchDB(tb testing.TB) DBModel {
+	m := testDB(tb)
+
+	stmts := []string{
+		`insert into genres (genre_name) select 'Genre ' || g from generate_series(1, 10) g`,
+		fmt.Sprintf(`insert into movies (title, description, year, release_date, runtime, rating, mpaa_rating)
+			select 'Movie ' || i, 'A movie', 2000 + i %% 20, date '2000-01-01' + i, 90 + i %% 60, i %% 5 + 1, 'PG'
+			from generate_series(1, %d) i`, benchMovies),
+		`insert into movies_genres (movie_id, genre_id)
+			select m.id, (m.id + k) % 10 + 1 from movies m, generate_series(0, 2) k`,
+		`analyze genres, movies, movies_genres`,
+	}
+	for _, stmt := range stmts {
+		if _, err := m.DB.ExecContext(context.Background(), stmt); err != nil {
+			tb.Fatal(err)
+		}
+	}
+
+	return m
+}
+
+func TestAll(t *testing.T) {
+	m := benchDB(t)
+
+	movies, err := m.All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(movies) != benchMovies {
+		t.Fatalf("got %d movies, want %d", len(movies), benchMovies)
+	}
+	for _, movie := range movies {
+		if len(movie.MovieGenre) != 3 {
+			t.Fatalf("movie %d has genres %v, want 3", movie.ID, movie.MovieGenre)
+		}
+	}
+
+	movies, err = m.All(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(movies) == 0 || len(movies) == benchMovies {
+		t.Fatalf("got %d movies in genre 1", len(movies))
+	}
+	for _, movie := range movies {
+		found := false
+		for _, name := range movie.MovieGenre {
+			found = found || name == "Genre 1"
+		}
+		if !found {
+			t.Errorf("movie %d has genres %v, want Genre 1 among them", movie.ID, movie.MovieGenre)
+		}
+	}
+}
+
+func TestMovieGenres(t *testing.T) {
+	m := benchDB(t)
+
+	movie := Movie{
+		Title:       "Jaws",
+		Description: "A shark",
+		Year:        1975,
+		ReleaseDate: time.Date(1975, 6, 20, 0, 0, 0, 0, time.UTC),
+		Runtime:     124,
+		Rating:      5,
+		MPAARating:  "PG",
+	}
+
+	// nothing is stored when a genre does not exist
+	_, err := m.InsertMovie(movie, []int{1, 999})
+	if !errors.Is(err, ErrUnknownGenre) {
+		t.Fatalf("got %v, want ErrUnknownGenre", err)
+	}
+	var n int
+	m.DB.QueryRowContext(context.Background(), "select count(*) from movies where title = 'Jaws'").Scan(&n)
+	if n != 0 {
+		t.Fatal("movie stored with an unknown genre")
+	}
+
+	id, err := m.InsertMovie(movie, []int{1, 2, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	genres := func() map[int]string {
+		t.Helper()
+		got, err := m.Get(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return got.MovieGenre
+	}
+	if got := genres(); len(got) != 2 {
+		t.Errorf("got genres %v, want 2", got)
+	}
+
+	// nil genre ids keep the genres, an empty list clears them
+	movie.ID = id
+	movie.Rating = 4
+	for _, tt := range []struct {
+		ids  []int
+		want int
+	}{{nil, 2}, {[]int{3}, 1}, {[]int{}, 0}} {
+		err = m.UpdateMovie(movie, tt.ids)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := genres(); len(got) != tt.want {
+			t.Errorf("update with %v: got genres %v, want %d", tt.ids, got, tt.want)
+		}
+	}
+}
+
+func BenchmarkAll(b *testing.B) {
+	m := benchDB(b)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		movies, err := m.All()
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(movies) != benchMovies {
+			b.Fatalf("got %d movies, want %d", len(movies), benchMovies)
+		}
+	}
+}
+
+// BenchmarkAllPerMovieGenres loads genres with one query per movie, the way
+// All used to, as a baseline for BenchmarkAll
+func BenchmarkAllPerMovieGenres(b *testing.B) {
+	m := benchDB(b)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+		rows, err := m.DB.QueryContext(ctx, "select id from movies order by title")
+		if err != nil {
+			b.Fatal(err)
+		}
+		var movies []*Movie
+		for rows.Next() {
+			var movie Movie
+			if err := rows.Scan(&movie.ID); err != nil {
+				b.Fatal(err)
+			}
+			movies = append(movies, &movie)
+		}
+		rows.Close()
+
+		for _, movie := range movies {
+			err = m.loadGenres(ctx, []*Movie{movie})
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+		cancel()
+	}
+}
@@ -0,0 +1,229 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// SortFields maps the fields movies can be sorted by to their postgres type
+var SortFields = map[string]string{
+	"title":        "text",
+	"year":         "integer",
+	"rating":       "integer",
+	"release_date": "date",
+	"runtime":      "integer",
+}
+
+// ErrInvalidCursor is returned by List for cursors it did not issue
+// or that were issued for a different sort
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// cursor is the position after which the next page starts
+type cursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    int    `json:"id"`
+}
+
+// List returns one page of movies matching the filter, and metadata
+// with the total number of matches and the cursor of the next page
+func (m DBModel) List(f MovieFilter) ([]*Movie, Metadata, error) {
+	ctx, cancel := m.timeout()
+	defer cancel()
+
+	if f.Sort == "" {
+		f.Sort = "title"
+	}
+	sortType, ok := SortFields[f.Sort]
+	if !ok {
+		return nil, Metadata{}, fmt.Errorf("cannot sort by %q", f.Sort)
+	}
+
+	dir, cmp := "asc", ">"
+	if f.Desc {
+		dir, cmp = "desc", "<"
+	}
+	sortKey := f.Sort + " " + dir
+
+	// build the where clause shared by the page and the count
+	where := []string{"deleted_at is null"}
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if f.YearFrom > 0 {
+		where = append(where, "year >= "+arg(f.YearFrom))
+	}
+	if f.YearTo > 0 {
+		where = append(where, "year <= "+arg(f.YearTo))
+	}
+	if len(f.MPAARatings) > 0 {
+		where = append(where, "mpaa_rating = any("+arg(pq.Array(f.MPAARatings))+")")
+	}
+	if f.MinRating > 0 {
+		where = append(where, "rating >= "+arg(f.MinRating))
+	}
+	if len(f.GenreIDs) > 0 {
+		where = append(where, "id in (select movie_id from movies_genres where genre_id = any("+arg(pq.Array(f.GenreIDs))+"))")
+	}
+	if f.Title != "" {
+		where = append(where, "title ilike "+arg("%"+likeEscaper.Replace(f.Title)+"%"))
+	}
+
+	var meta Metadata
+	countQuery := "select count(*) from movies " + whereClause(where)
+	err := m.DB.QueryRowContext(ctx, countQuery, args...).Scan(&meta.Total)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	if f.Cursor != "" {
+		c, err := decodeCursor(f.Cursor, sortKey)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		where = append(where, fmt.Sprintf("(%s, id) %s (%s::%s, %s)",
+			f.Sort, cmp, arg(c.Value), sortType, arg(c.ID)))
+		f.Offset = 0
+	}
+
+	// fetch one extra row to know whether there is a next page
+	query := fmt.Sprintf(`
+		select 
+			id, title, description, year, release_date, rating, runtime, mpaa_rating,
+			created_at, updated_at, coalesce(poster, ''), version
+		from 
+			movies 
+		%s
+		order by 
+			%s %s, id %s
+		limit %s offset %s
+	`, whereClause(where), f.Sort, dir, dir, arg(f.Limit+1), arg(f.Offset))
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	movies := []*Movie{}
+	for rows.Next() {
+		var movie Movie
+		err := rows.Scan(
+			&movie.ID,
+			&movie.Title,
+			&movie.Description,
+			&movie.Year,
+			&movie.ReleaseDate,
+			&movie.Rating,
+			&movie.Runtime,
+			&movie.MPAARating,
+			&movie.CreatedAt,
+			&movie.UpdatedAt,
+			&movie.Poster,
+			&movie.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		movies = append(movies, &movie)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	rows.Close()
+
+	if len(movies) > f.Limit {
+		movies = movies[:f.Limit]
+		last := movies[len(movies)-1]
+		meta.NextCursor = encodeCursor(cursor{
+			Sort:  sortKey,
+			Value: sortValue(last, f.Sort),
+			ID:    last.ID,
+		})
+	}
+
+	err = m.loadGenres(ctx, movies)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	meta.Limit = f.Limit
+	meta.Offset = f.Offset
+
+	return movies, meta, nil
+}
+
+// likeEscaper escapes the wildcards of a like pattern, so user input
+// matches literally
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+func whereClause(conditions []string) string {
+	if len(conditions) == 0 {
+		return ""
+	}
+	return "where " + strings.Join(conditions, " and ")
+}
+
+// sortValue returns the value of the sort field of a movie as text
+func sortValue(movie *Movie, field string) string {
+	switch field {
+	case "year":
+		return strconv.Itoa(movie.Year)
+	case "rating":
+		return strconv.Itoa(movie.Rating)
+	case "runtime":
+		return strconv.Itoa(movie.Runtime)
+	case "release_date":
+		return movie.ReleaseDate.Format("2006-01-02")
+	default:
+		return movie.Title
+	}
+}
+
+func encodeCursor(c cursor) string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodeCursor decodes a cursor issued for sortKey. It returns
+// ErrInvalidCursor for cursors that are garbled, were issued for another
+// sort or hold a value postgres could not compare with the sort field
+func decodeCursor(s, sortKey string) (cursor, error) {
+	var c cursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cursor{}, ErrInvalidCursor
+	}
+	err = json.Unmarshal(b, &c)
+	if err != nil || c.Sort != sortKey || !validSortValue(strings.Fields(sortKey)[0], c.Value) {
+		return cursor{}, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// validSortValue reports whether value parses as the type of the sort
+// field, written the way sortValue writes it
+func validSortValue(field, value string) bool {
+	switch SortFields[field] {
+	case "integer":
+		_, err := strconv.ParseInt(value, 10, 32)
+		return err == nil
+	case "date":
+		_, err := time.Parse("2006-01-02", value)
+		return err == nil
+	case "text":
+		// postgres text cannot hold a nul byte
+		return !strings.ContainsRune(value, 0)
+	}
+	return false
+}
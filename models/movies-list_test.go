@@ -0,0 +1,48 @@
+package models
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+)
+
+func TestCursor(t *testing.T) {
+	movie := &Movie{ID: 7, Title: "Jaws", Year: 1975, ReleaseDate: time.Date(1975, 6, 20, 0, 0, 0, 0, time.UTC)}
+
+	for field := range SortFields {
+		sortKey := field + " desc"
+		want := cursor{Sort: sortKey, Value: sortValue(movie, field), ID: movie.ID}
+
+		got, err := decodeCursor(encodeCursor(want), sortKey)
+		if err != nil || got != want {
+			t.Errorf("%s: got %+v, %v, want %+v", field, got, err, want)
+		}
+		if _, err := decodeCursor(encodeCursor(want), field+" asc"); err != ErrInvalidCursor {
+			t.Errorf("%s: got %v for a cursor of the other direction", field, err)
+		}
+	}
+
+	forged := func(sortKey, value string) string {
+		c := `{"s":"` + sortKey + `","v":"` + value + `","id":1}`
+		return base64.RawURLEncoding.EncodeToString([]byte(c))
+	}
+
+	tests := []struct {
+		name    string
+		cursor  string
+		sortKey string
+	}{
+		{"not base64", "bogus!", "title asc"},
+		{"not json", base64.RawURLEncoding.EncodeToString([]byte("bogus")), "title asc"},
+		{"text year", forged("year asc", "abc"), "year asc"},
+		{"out of range runtime", forged("runtime asc", "99999999999"), "runtime asc"},
+		{"impossible date", forged("release_date asc", "1995-13-45"), "release_date asc"},
+		{"nul in title", forged("title asc", `\u0000`), "title asc"},
+	}
+
+	for _, tt := range tests {
+		if _, err := decodeCursor(tt.cursor, tt.sortKey); err != ErrInvalidCursor {
+			t.Errorf("%s: got %v, want ErrInvalidCursor", tt.name, err)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package models
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// searchDocument is the tsvector movies are searched by,
+// weighting title matches above description matches
+const searchDocument = `setweight(to_tsvector('english', title), 'A') || setweight(to_tsvector('english', coalesce(description, '')), 'B')`
+
+// fuzzyThreshold is the trigram similarity above which a title
+// matches a misspelled search
+const fuzzyThreshold = 0.3
+
+// escapeHTML wraps a text expression so postgres escapes it the way
+// html.EscapeString does, before ts_headline adds its <b> tags
+func escapeHTML(expr string) string {
+	return `replace(replace(replace(replace(replace(` + expr +
+		`, '&', '&amp;'), '<', '&lt;'), '>', '&gt;'), '"', '&#34;'), '''', '&#39;')`
+}
+
+// Search returns the movies best matching a free text search. Every word
+// matches as a prefix, and titles within a few typos match through
+// pg_trgm similarity. Snippets are escaped html
+func (m DBModel) Search(q string, limit int) ([]*SearchResult, error) {
+	ctx, cancel := m.timeout()
+	defer cancel()
+
+	results := []*SearchResult{}
+
+	tsquery := prefixQuery(q)
+	if tsquery == "" {
+		return results, nil
+	}
+
+	// each branch of the union has an index, movies_search_idx for the
+	// words and movies_title_trgm_idx for the typos
+	query := `
+		with matches as (
+			select id from movies where (` + searchDocument + `) @@ to_tsquery('english', $1)
+			union
+			select id from movies where title % $2
+		)
+		select
+			id, title, description, year, release_date, rating, runtime, mpaa_rating,
+			created_at, updated_at, coalesce(poster, ''), version,
+			ts_rank(doc, query) + similarity(title, $2) as rank,
+			ts_headline('english', ` + escapeHTML("title") + `, query, 'HighlightAll=true'),
+			ts_headline('english', ` + escapeHTML("coalesce(description, '')") + `, query, 'MaxFragments=2, MaxWords=20, MinWords=5')
+		from
+			movies
+			join matches using (id),
+			to_tsquery('english', $1) query,
+			lateral (select ` + searchDocument + ` as doc) d
+		where
+			deleted_at is null
+		order by
+			rank desc, title
+		limit $3
+	`
+
+	var movies []*Movie
+	err := m.WithTx(ctx, func(tx DBModel) error {
+		// title % $2 matches above pg_trgm.similarity_threshold,
+		// set for this transaction only
+		_, err := tx.DB.ExecContext(ctx, `select set_config('pg_trgm.similarity_threshold', $1, true)`,
+			strconv.FormatFloat(fuzzyThreshold, 'f', -1, 64))
+		if err != nil {
+			return err
+		}
+
+		rows, err := tx.DB.QueryContext(ctx, query, tsquery, q, limit)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var movie Movie
+			result := SearchResult{Movie: &movie}
+			err := rows.Scan(
+				&movie.ID,
+				&movie.Title,
+				&movie.Description,
+				&movie.Year,
+				&movie.ReleaseDate,
+				&movie.Rating,
+				&movie.Runtime,
+				&movie.MPAARating,
+				&movie.CreatedAt,
+				&movie.UpdatedAt,
+				&movie.Poster,
+				&movie.Version,
+				&result.Rank,
+				&result.TitleSnippet,
+				&result.DescriptionSnippet,
+			)
+			if err != nil {
+				return err
+			}
+			movies = append(movies, &movie)
+			results = append(results, &result)
+		}
+
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// genres are loaded once the transaction is over, so a pool of one
+	// connection is not left waiting for itself
+	err = m.loadGenres(ctx, movies)
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// prefixQuery turns free text into a tsquery matching every word as a
+// prefix. Anything but letters and digits is dropped, so user input cannot
+// inject tsquery operators
+func prefixQuery(q string) string {
+	words := strings.FieldsFunc(q, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	for i, word := range words {
+		words[i] = strings.ToLower(word) + ":*"
+	}
+
+	return strings.Join(words, " & ")
+}
@@ -0,0 +1,53 @@
+package models
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestPrefixQuery(t *testing.T) {
+	tests := []struct {
+		q    string
+		want string
+	}{
+		{"", ""},
+		{"Star", "star:*"},
+		{"  star   wars ", "star:* & wars:*"},
+		{"star & !wars | (x)", "star:* & wars:* & x:*"},
+		{"amélie 2001", "amélie:* & 2001:*"},
+		{"':* <-> !", ""},
+	}
+
+	for _, tt := range tests {
+		if got := prefixQuery(tt.q); got != tt.want {
+			t.Errorf("prefixQuery(%q) = %q, want %q", tt.q, got, tt.want)
+		}
+	}
+}
+
+func TestSearch(t *testing.T) {
+	m := benchDB(t)
+
+	_, err := m.DB.ExecContext(context.Background(), `
+		insert into movies (title, description, year, release_date, runtime, rating, mpaa_rating)
+			values ('Casablanca <i>Redux</i>', 'Play it again', 2020, '2020-01-01', 100, 3, 'PG')
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// prefixes and typos both match, and snippets are escaped html
+	for _, q := range []string{"redu", "Casablanka"} {
+		results, err := m.Search(q, 5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != 1 || results[0].Title != "Casablanca <i>Redux</i>" {
+			t.Fatalf("%s: got %d results", q, len(results))
+		}
+		if snippet := results[0].TitleSnippet; strings.Contains(snippet, "<i>") || !strings.Contains(snippet, "&lt;i&gt;") {
+			t.Errorf("%s: got unescaped title snippet %q", q, snippet)
+		}
+	}
+}
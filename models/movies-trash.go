@@ -0,0 +1,105 @@
+package models
+
+import (
+	"time"
+)
+
+// TrashedMovies returns the movies in the trash, most recently deleted first
+func (m DBModel) TrashedMovies() ([]*Movie, error) {
+	ctx, cancel := m.timeout()
+	defer cancel()
+
+	query := `
+		select 
+			id, title, description, year, release_date, rating, runtime, mpaa_rating,
+			created_at, updated_at, coalesce(poster, ''), version, deleted_at
+		from 
+			movies 
+		where
+			deleted_at is not null
+		order by 
+			deleted_at desc, id desc
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	movies := []*Movie{}
+
+	for rows.Next() {
+		var movie Movie
+		err := rows.Scan(
+			&movie.ID,
+			&movie.Title,
+			&movie.Description,
+			&movie.Year,
+			&movie.ReleaseDate,
+			&movie.Rating,
+			&movie.Runtime,
+			&movie.MPAARating,
+			&movie.CreatedAt,
+			&movie.UpdatedAt,
+			&movie.Poster,
+			&movie.Version,
+			&movie.DeletedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		movies = append(movies, &movie)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	err = m.loadGenres(ctx, movies)
+	if err != nil {
+		return nil, err
+	}
+
+	return movies, nil
+}
+
+// RestoreMovie takes a movie out of the trash. It returns sql.ErrNoRows
+// when the movie is not in the trash
+func (m *DBModel) RestoreMovie(id int) error {
+	ctx, cancel := m.timeout()
+	defer cancel()
+
+	stmt := `
+		update 
+			movies 
+		set deleted_at = null, updated_at = $1, version = version + 1
+		where 
+			id = $2 and deleted_at is not null
+	`
+
+	res, err := m.DB.ExecContext(ctx, stmt, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return expectRow(res)
+}
+
+// PurgeMovie permanently deletes a movie in the trash. Its genre links
+// go with it through the foreign key cascade. It returns sql.ErrNoRows
+// when the movie is not in the trash
+func (m *DBModel) PurgeMovie(id int) error {
+	ctx, cancel := m.timeout()
+	defer cancel()
+
+	stmt := `delete from movies where id = $1 and deleted_at is not null`
+
+	res, err := m.DB.ExecContext(ctx, stmt, id)
+	if err != nil {
+		return err
+	}
+
+	return expectRow(res)
+}
@@ -0,0 +1,52 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+)
+
+func TestPurgeMovie(t *testing.T) {
+	m := testDB(t)
+
+	genreID, err := m.InsertGenre(Genre{GenreName: "Crime"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := m.InsertMovie(Movie{Title: "Heat", Year: 1995, Rating: 4}, []int{genreID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// only movies in the trash can be purged
+	err = m.PurgeMovie(id)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("purging a live movie: got %v, want sql.ErrNoRows", err)
+	}
+
+	err = m.DeleteMovie(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.PurgeMovie(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the genre links went with the movie
+	var links int
+	err = m.DB.QueryRowContext(context.Background(),
+		`select count(*) from movies_genres where movie_id = $1`, id).Scan(&links)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if links != 0 {
+		t.Errorf("got %d genre links after purging, want 0", links)
+	}
+
+	err = m.PurgeMovie(id)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("purging twice: got %v, want sql.ErrNoRows", err)
+	}
+}
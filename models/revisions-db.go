@@ -0,0 +1,159 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+)
+
+// SnapshotMovie stores the current state of a movie as its next revision.
+// rev carries the action, author and time; the revision number and the
+// snapshot are filled in
+func (m *DBModel) SnapshotMovie(rev MovieRevision) (*MovieRevision, error) {
+	ctx, cancel := m.timeout()
+	defer cancel()
+
+	// the row lock makes concurrent snapshots of a movie number their
+	// revisions in turn
+	query := `
+		select 
+			title, description, year, release_date, runtime, rating, mpaa_rating,
+			coalesce(poster, '')
+		from 
+			movies 
+		where
+			id = $1
+		for update
+	`
+
+	err := m.WithTx(ctx, func(tx DBModel) error {
+		var movie Movie
+		err := tx.DB.QueryRowContext(ctx, query, rev.MovieID).Scan(
+			&movie.Title,
+			&movie.Description,
+			&movie.Year,
+			&movie.ReleaseDate,
+			&movie.Runtime,
+			&movie.Rating,
+			&movie.MPAARating,
+			&movie.Poster,
+		)
+		if err != nil {
+			return err
+		}
+
+		genreIDs, err := tx.movieGenreIDs(ctx, rev.MovieID)
+		if err != nil {
+			return err
+		}
+		rev.Movie = snapshot(&movie, genreIDs)
+
+		js, err := json.Marshal(rev.Movie)
+		if err != nil {
+			return err
+		}
+
+		stmt := `
+			insert into movie_revisions (movie_id, revision, action, rolled_back_to, snapshot, 
+				created_by, created_at)
+				select $1, coalesce(max(revision), 0) + 1, $2, $3, $4, $5, $6
+				from movie_revisions where movie_id = $1
+			returning id, revision
+		`
+
+		return tx.DB.QueryRowContext(ctx, stmt,
+			rev.MovieID,
+			rev.Action,
+			rev.RolledBackTo,
+			js,
+			rev.CreatedBy,
+			rev.CreatedAt,
+		).Scan(&rev.ID, &rev.Revision)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &rev, nil
+}
+
+// MovieRevisions returns the revisions of a movie, oldest first
+func (m DBModel) MovieRevisions(movieID int) ([]*MovieRevision, error) {
+	ctx, cancel := m.timeout()
+	defer cancel()
+
+	query := `
+		select 
+			id, movie_id, revision, action, rolled_back_to, snapshot, created_by, created_at
+		from 
+			movie_revisions 
+		where
+			movie_id = $1
+		order by
+			revision
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := []*MovieRevision{}
+	for rows.Next() {
+		rev, err := scanRevision(rows)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, rev)
+	}
+
+	return revisions, rows.Err()
+}
+
+// GetMovieRevision returns one revision of a movie
+func (m DBModel) GetMovieRevision(movieID, revision int) (*MovieRevision, error) {
+	ctx, cancel := m.timeout()
+	defer cancel()
+
+	query := `
+		select 
+			id, movie_id, revision, action, rolled_back_to, snapshot, created_by, created_at
+		from 
+			movie_revisions 
+		where
+			movie_id = $1 and revision = $2
+	`
+
+	return scanRevision(m.DB.QueryRowContext(ctx, query, movieID, revision))
+}
+
+func scanRevision(row scanner) (*MovieRevision, error) {
+	var rev MovieRevision
+	var rolledBackTo sql.NullInt64
+	var js []byte
+
+	err := row.Scan(
+		&rev.ID,
+		&rev.MovieID,
+		&rev.Revision,
+		&rev.Action,
+		&rolledBackTo,
+		&js,
+		&rev.CreatedBy,
+		&rev.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if rolledBackTo.Valid {
+		n := int(rolledBackTo.Int64)
+		rev.RolledBackTo = &n
+	}
+	err = json.Unmarshal(js, &rev.Movie)
+	if err != nil {
+		return nil, err
+	}
+
+	return &rev, nil
+}
@@ -0,0 +1,103 @@
+package models
+
+import "time"
+
+// MovieStore reads and writes movies
+type MovieStore interface {
+	Get(id int) (*Movie, error)
+	GetTrashed(id int) (*Movie, error)
+	All(genre ...int) ([]*Movie, error)
+	GenreMovies(genreIDs []int) (map[int][]*Movie, error)
+	List(f MovieFilter) ([]*Movie, Metadata, error)
+	Search(q string, limit int) ([]*SearchResult, error)
+	InsertMovie(movie Movie, genreIDs []int) (int, error)
+	UpdateMovie(movie Movie, genreIDs []int) error
+	DeleteMovie(id int) error
+	TrashedMovies() ([]*Movie, error)
+	RestoreMovie(id int) error
+	PurgeMovie(id int) error
+}
+
+// GenreStore reads and writes genres
+type GenreStore interface {
+	GenresAll() ([]*Genre, error)
+	GetGenre(id int) (*Genre, error)
+	MovieGenres(movieIDs []int) (map[int][]*Genre, error)
+	InsertGenre(genre Genre) (int, error)
+	UpdateGenre(genre Genre) error
+	DeleteGenre(id int, cascade bool) error
+}
+
+// UserStore reads and writes user accounts
+type UserStore interface {
+	GetUser(id int) (*User, error)
+	GetUserByEmail(email string) (*User, error)
+	InsertUser(user User) (int, error)
+	UpdatePassword(id int, hash string) error
+}
+
+// TokenStore keeps refresh tokens and the sessions they belong to
+type TokenStore interface {
+	InsertRefreshToken(token RefreshToken) error
+	GetRefreshToken(hash string) (*RefreshToken, error)
+	UseRefreshToken(id int) (bool, error)
+	RevokeTokenFamily(familyID string) error
+	SessionRevoked(familyID string) (bool, error)
+}
+
+// APIKeyStore keeps api keys
+type APIKeyStore interface {
+	InsertAPIKey(key APIKey) (int, error)
+	GetAPIKey(id int) (*APIKey, error)
+	GetAPIKeyByHash(hash string) (*APIKey, error)
+	APIKeysAll() ([]*APIKey, error)
+	RevokeAPIKey(id int) error
+	TouchAPIKey(id int) error
+}
+
+// LoginAttemptStore keeps failed signin counts
+type LoginAttemptStore interface {
+	ReserveLoginAttempt(key string, since time.Time, block func(failures int) time.Time) (*LoginAttempt, bool, error)
+	ClearLoginAttempts(key string) error
+	PruneLoginAttempts(before time.Time) (int, error)
+}
+
+// AuditStore keeps the audit log
+type AuditStore interface {
+	InsertAudit(entry AuditEntry) (int, error)
+	AuditLog(f AuditFilter) ([]*AuditEntry, error)
+}
+
+// RevisionStore keeps numbered snapshots of movies
+type RevisionStore interface {
+	SnapshotMovie(rev MovieRevision) (*MovieRevision, error)
+	MovieRevisions(movieID int) ([]*MovieRevision, error)
+	GetMovieRevision(movieID, revision int) (*MovieRevision, error)
+}
+
+// Seeder loads fixtures
+type Seeder interface {
+	Seed(f *Fixture) (SeedStats, error)
+}
+
+var (
+	_ MovieStore        = (*DBModel)(nil)
+	_ GenreStore        = (*DBModel)(nil)
+	_ UserStore         = (*DBModel)(nil)
+	_ TokenStore        = (*DBModel)(nil)
+	_ APIKeyStore       = (*DBModel)(nil)
+	_ LoginAttemptStore = (*DBModel)(nil)
+	_ AuditStore        = (*DBModel)(nil)
+	_ RevisionStore     = (*DBModel)(nil)
+	_ Seeder            = (*DBModel)(nil)
+
+	_ MovieStore        = (*MemoryModel)(nil)
+	_ GenreStore        = (*MemoryModel)(nil)
+	_ UserStore         = (*MemoryModel)(nil)
+	_ TokenStore        = (*MemoryModel)(nil)
+	_ APIKeyStore       = (*MemoryModel)(nil)
+	_ AuditStore        = (*MemoryModel)(nil)
+	_ RevisionStore     = (*MemoryModel)(nil)
+	_ Seeder            = (*MemoryModel)(nil)
+	_ LoginAttemptStore = (*MemoryAttempts)(nil)
+)
@@ -0,0 +1,136 @@
+package models
+
+import (
+	"time"
+)
+
+// InsertRefreshToken stores a new refresh token
+func (m *DBModel) InsertRefreshToken(token RefreshToken) error {
+	ctx, cancel := m.timeout()
+	defer cancel()
+
+	stmt := `
+		insert into refresh_tokens (user_id, family_id, token_hash, expires_at, created_at) 
+			values ($1, $2, $3, $4, $5)
+	`
+
+	_, err := m.DB.ExecContext(ctx, stmt,
+		token.UserID,
+		token.FamilyID,
+		token.TokenHash,
+		token.ExpiresAt,
+		time.Now(),
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetRefreshToken returns the refresh token with the given hash and error, if any
+func (m DBModel) GetRefreshToken(hash string) (*RefreshToken, error) {
+	ctx, cancel := m.timeout()
+	defer cancel()
+
+	query := `
+		select 
+			id, user_id, family_id, token_hash, expires_at, used_at, revoked_at, created_at
+		from 
+			refresh_tokens 
+		where
+			token_hash = $1
+	`
+
+	row := m.DB.QueryRowContext(ctx, query, hash)
+
+	var token RefreshToken
+
+	err := row.Scan(
+		&token.ID,
+		&token.UserID,
+		&token.FamilyID,
+		&token.TokenHash,
+		&token.ExpiresAt,
+		&token.UsedAt,
+		&token.RevokedAt,
+		&token.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+// UseRefreshToken marks a refresh token as used. It reports false when the
+// token had already been used, which means it is being replayed
+func (m *DBModel) UseRefreshToken(id int) (bool, error) {
+	ctx, cancel := m.timeout()
+	defer cancel()
+
+	stmt := `
+		update 
+			refresh_tokens 
+		set used_at = $1
+		where
+			id = $2 and used_at is null
+	`
+
+	res, err := m.DB.ExecContext(ctx, stmt, time.Now(), id)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n == 1, nil
+}
+
+// RevokeTokenFamily revokes every refresh token of a session
+func (m *DBModel) RevokeTokenFamily(familyID string) error {
+	ctx, cancel := m.timeout()
+	defer cancel()
+
+	stmt := `
+		update 
+			refresh_tokens 
+		set revoked_at = $1
+		where
+			family_id = $2 and revoked_at is null
+	`
+
+	_, err := m.DB.ExecContext(ctx, stmt, time.Now(), familyID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// SessionRevoked reports whether a session has been revoked.
+// Unknown sessions count as revoked
+func (m DBModel) SessionRevoked(familyID string) (bool, error) {
+	ctx, cancel := m.timeout()
+	defer cancel()
+
+	query := `
+		select 
+			count(*), count(revoked_at)
+		from 
+			refresh_tokens 
+		where
+			family_id = $1
+	`
+
+	var total, revoked int
+	err := m.DB.QueryRowContext(ctx, query, familyID).Scan(&total, &revoked)
+	if err != nil {
+		return true, err
+	}
+
+	return total == 0 || revoked > 0, nil
+}
@@ -0,0 +1,64 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRefreshTokens(t *testing.T) {
+	m := testDB(t)
+
+	userID, err := m.InsertUser(User{Email: "editor@example.com", Password: "hash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.InsertRefreshToken(RefreshToken{
+		UserID:    userID,
+		FamilyID:  "session",
+		TokenHash: "token hash",
+		ExpiresAt: time.Now().Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := m.GetRefreshToken("token hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.UserID != userID || token.FamilyID != "session" || token.UsedAt.Valid || token.RevokedAt.Valid {
+		t.Errorf("got %+v", token)
+	}
+
+	// a refresh token works once, and a second use is reported as reuse
+	for i, want := range []bool{true, false} {
+		fresh, err := m.UseRefreshToken(token.ID)
+		if err != nil || fresh != want {
+			t.Errorf("use %d: got %v, %v, want %v", i+1, fresh, err, want)
+		}
+	}
+
+	revoked, err := m.SessionRevoked("session")
+	if err != nil || revoked {
+		t.Errorf("got revoked %v, %v before signing out", revoked, err)
+	}
+
+	err = m.RevokeTokenFamily("session")
+	if err != nil {
+		t.Fatal(err)
+	}
+	revoked, err = m.SessionRevoked("session")
+	if err != nil || !revoked {
+		t.Errorf("got revoked %v, %v after signing out", revoked, err)
+	}
+	token, _ = m.GetRefreshToken("token hash")
+	if !token.RevokedAt.Valid {
+		t.Error("refresh token not revoked with its session")
+	}
+
+	// sessions that were never started count as revoked
+	revoked, err = m.SessionRevoked("unknown")
+	if err != nil || !revoked {
+		t.Errorf("got revoked %v, %v for an unknown session", revoked, err)
+	}
+}
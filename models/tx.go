@@ -0,0 +1,40 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+)
+
+// DBTX is what the models need from a database handle. Both *sql.DB and
+// *sql.Tx satisfy it, so every DBModel method can run on its own or as
+// part of a transaction
+type DBTX interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// WithTx runs fn with a DBModel bound to a new transaction. The transaction
+// commits when fn returns nil and rolls back when it returns an error or
+// panics. Called on a DBModel that is already in a transaction, fn simply
+// joins it, so writes can be composed freely
+func (m DBModel) WithTx(ctx context.Context, fn func(tx DBModel) error) error {
+	db, ok := m.DB.(*sql.DB)
+	if !ok {
+		return fn(m)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// no-op once committed
+	defer tx.Rollback()
+
+	err = fn(DBModel{DB: tx, ctx: m.ctx})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
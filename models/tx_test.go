@@ -0,0 +1,48 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+)
+
+func TestWithTx(t *testing.T) {
+	m := testDB(t)
+	ctx := context.Background()
+
+	// an error rolls back everything written in fn, nested calls included
+	errStop := errors.New("stop")
+	err := m.WithTx(ctx, func(tx DBModel) error {
+		_, err := tx.InsertUser(User{Email: "a@example.com", Password: "hash"})
+		if err != nil {
+			return err
+		}
+		return tx.WithTx(ctx, func(nested DBModel) error {
+			_, err := nested.InsertUser(User{Email: "b@example.com", Password: "hash"})
+			if err != nil {
+				return err
+			}
+			return errStop
+		})
+	})
+	if !errors.Is(err, errStop) {
+		t.Fatalf("got %v, want errStop", err)
+	}
+	for _, email := range []string{"a@example.com", "b@example.com"} {
+		if _, err := m.GetUserByEmail(email); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("%s: got %v after rolling back, want sql.ErrNoRows", email, err)
+		}
+	}
+
+	err = m.WithTx(ctx, func(tx DBModel) error {
+		_, err := tx.InsertUser(User{Email: "a@example.com", Password: "hash"})
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.GetUserByEmail("a@example.com"); err != nil {
+		t.Errorf("got %v after committing", err)
+	}
+}
@@ -0,0 +1,141 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ErrDuplicateEmail is returned when an email is already registered
+var ErrDuplicateEmail = errors.New("email already registered")
+
+// GetUser returns one user by id and error, if any
+func (m DBModel) GetUser(id int) (*User, error) {
+	ctx, cancel := m.timeout()
+	defer cancel()
+
+	query := `
+		select 
+			id, email, password, role, created_at, updated_at
+		from 
+			users 
+		where
+			id = $1
+	`
+
+	row := m.DB.QueryRowContext(ctx, query, id)
+
+	var user User
+
+	err := row.Scan(
+		&user.ID,
+		&user.Email,
+		&user.Password,
+		&user.Role,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// GetUserByEmail returns one user and error, if any.
+// sql.ErrNoRows is returned when no user has the given email
+func (m DBModel) GetUserByEmail(email string) (*User, error) {
+	ctx, cancel := m.timeout()
+	defer cancel()
+
+	query := `
+		select 
+			id, email, password, role, created_at, updated_at
+		from 
+			users 
+		where
+			lower(email) = lower($1)
+	`
+
+	row := m.DB.QueryRowContext(ctx, query, email)
+
+	var user User
+
+	err := row.Scan(
+		&user.ID,
+		&user.Email,
+		&user.Password,
+		&user.Role,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// InsertUser stores a new user and returns its id. Users without a role
+// are created as viewers. It returns ErrDuplicateEmail when the email is
+// taken, whatever its case
+func (m *DBModel) InsertUser(user User) (int, error) {
+	ctx, cancel := m.timeout()
+	defer cancel()
+
+	if user.Role == "" {
+		user.Role = RoleViewer
+	}
+
+	stmt := `
+		insert into users (email, password, role, created_at, updated_at) 
+			values ($1, $2, $3, $4, $5)
+		returning id
+	`
+
+	var id int
+	err := m.DB.QueryRowContext(ctx, stmt,
+		user.Email,
+		user.Password,
+		user.Role,
+		time.Now(),
+		time.Now(),
+	).Scan(&id)
+	if err != nil {
+		return 0, userError(err)
+	}
+
+	return id, nil
+}
+
+// UpdatePassword replaces the password hash of a user
+func (m *DBModel) UpdatePassword(id int, hash string) error {
+	ctx, cancel := m.timeout()
+	defer cancel()
+
+	stmt := `
+		update 
+			users 
+		set password = $1, updated_at = $2
+		where
+			id = $3
+	`
+
+	_, err := m.DB.ExecContext(ctx, stmt, hash, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// userError maps the unique email index to ErrDuplicateEmail, for
+// signups that race past the check for an existing user
+func userError(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" && pqErr.Constraint == "users_email_key" {
+		return ErrDuplicateEmail
+	}
+	return err
+}
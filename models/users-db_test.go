@@ -0,0 +1,48 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+)
+
+func TestUsers(t *testing.T) {
+	m := testDB(t)
+
+	id, err := m.InsertUser(User{Email: "Editor@Example.com", Password: "hash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// emails match whatever their case
+	user, err := m.GetUserByEmail("editor@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// users are viewers unless given a role
+	if user.ID != id || user.Email != "Editor@Example.com" || user.Password != "hash" || user.Role != RoleViewer {
+		t.Errorf("got %+v", user)
+	}
+
+	_, err = m.InsertUser(User{Email: "editor@EXAMPLE.com", Password: "hash"})
+	if !errors.Is(err, ErrDuplicateEmail) {
+		t.Errorf("got %v registering a taken email, want ErrDuplicateEmail", err)
+	}
+
+	_, err = m.GetUserByEmail("nobody@example.com")
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("got %v for an unknown email, want sql.ErrNoRows", err)
+	}
+
+	err = m.UpdatePassword(id, "new hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err = m.GetUser(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Password != "new hash" {
+		t.Errorf("got password %q after the update", user.Password)
+	}
+}